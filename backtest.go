@@ -0,0 +1,319 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/sberserker/dcagdax/exchanges"
+)
+
+// runBacktest loads the candles, replays the plan and prints the report.
+func runBacktest(config zap.Config, req syncRequest) error {
+	// every simulated window logs, keep the output to the report and problems
+	config.Level = zap.NewAtomicLevelAt(zap.WarnLevel)
+	l, err := config.Build()
+	if err != nil {
+		return err
+	}
+	logger := l.Sugar()
+	defer logger.Sync()
+
+	from := *backtestFrom
+	to := *backtestTo
+	if to.IsZero() {
+		to = time.Now().UTC().Truncate(24 * time.Hour)
+	}
+	if !from.Before(to) {
+		return fmt.Errorf("--from %s must be before --to %s", from.Format("2006-01-02"), to.Format("2006-01-02"))
+	}
+
+	var exchange exchanges.Exchange
+	source := candleSource{
+		dir:      *candlesDir,
+		cacheDir: filepath.Join(*dataDir, "candles", *exchangeType),
+		currency: req.currency,
+		fetch: func() (exchanges.Exchange, error) {
+			if exchange == nil {
+				var err error
+				if exchange, err = initExchange(*exchangeType); err != nil {
+					return nil, err
+				}
+			}
+			return exchange, nil
+		},
+	}
+
+	candles := map[string][]exchanges.Candle{}
+	for _, c := range req.coins {
+		coin := planCoin(c)
+		coinCandles, err := source.load(coin, candleHistoryStart(req, from), to)
+		if err != nil {
+			return fmt.Errorf("cannot load %s candles: %w", coin, err)
+		}
+		candles[coin+"-"+req.currency] = coinCandles
+	}
+
+	b, err := newBacktest(logger, req, candles, from)
+	if err != nil {
+		return err
+	}
+
+	b.run(to)
+
+	return b.report(from, to).print(os.Stdout)
+}
+
+// backtest replays the schedule against a simulated exchange, one Sync per step of simulated time.
+type backtest struct {
+	schedule *gdaxSchedule
+	exchange *exchanges.Simulated
+	candles  map[string][]exchanges.Candle
+	now      time.Time
+}
+
+func newBacktest(
+	l *zap.SugaredLogger,
+	req syncRequest,
+	candles map[string][]exchanges.Candle,
+	from time.Time,
+) (*backtest, error) {
+	b := &backtest{
+		candles: candles,
+		now:     from,
+	}
+
+	b.exchange = exchanges.NewSimulated(req.currency, req.fee, candles)
+	b.exchange.Now = b.clock
+
+	req.exchange = "backtest"
+	// deposits are simulated, so funding is always on
+	req.autoFund = true
+	req.force = false
+	if req.after.IsZero() {
+		// Sync acts strictly after the after date only
+		req.after = from.Add(-time.Nanosecond)
+	}
+
+	purchases, err := openLedger("")
+	if err != nil {
+		return nil, err
+	}
+
+	schedule, err := newGdaxSchedule(b.exchange, l, false, req, purchases)
+	if err != nil {
+		return nil, err
+	}
+
+	schedule.nowFunc = b.clock
+	// simulated deposits settle instantly
+	schedule.sleepFunc = func(time.Duration) {}
+	b.schedule = schedule
+
+	return b, nil
+}
+
+func (b *backtest) clock() time.Time {
+	return b.now
+}
+
+// run syncs the schedule from the start until the end date, stepping by the shortest cadence but at most a day.
+func (b *backtest) run(to time.Time) {
+	step := 24 * time.Hour
+	for _, coin := range b.schedule.coinNames() {
+		if every := b.schedule.everyFor(coin); every < step {
+			step = every
+		}
+	}
+
+	for ; b.now.Before(to); b.now = b.now.Add(step) {
+		if err := b.schedule.Sync(); err != nil {
+			b.schedule.logger.Debugw(
+				"No purchase",
+				"time", b.now,
+				"reason", err.Error(),
+			)
+		}
+	}
+}
+
+type planCoinReport struct {
+	coin     string
+	invested float64
+	fees     float64
+	acquired float64
+	value    float64
+}
+
+func (c planCoinReport) averageCost() float64 {
+	if c.acquired == 0 {
+		return 0
+	}
+
+	return c.invested / c.acquired
+}
+
+type backtestReport struct {
+	from      time.Time
+	to        time.Time
+	deposited float64
+	coins     []planCoinReport
+}
+
+// report sums up the fills and values the coins at the last close before the end date.
+func (b *backtest) report(from time.Time, to time.Time) backtestReport {
+	r := backtestReport{
+		from:      from,
+		to:        to,
+		deposited: b.exchange.Deposited(),
+	}
+
+	for _, coin := range b.schedule.coinNames() {
+		productId := b.schedule.coins[coin].symbol
+		c := planCoinReport{coin: coin}
+
+		for _, f := range b.exchange.Fills() {
+			if f.ProductId != productId {
+				continue
+			}
+			c.invested += f.Amount
+			c.fees += f.Fee
+			c.acquired += f.Size
+		}
+
+		for _, candle := range b.candles[productId] {
+			if candle.Time.Before(to) {
+				c.value = c.acquired * candle.Close
+			}
+		}
+
+		r.coins = append(r.coins, c)
+	}
+
+	return r
+}
+
+func (r backtestReport) print(out io.Writer) error {
+	fmt.Fprintf(out, "Backtest from %s to %s\n\n", r.from.Format("2006-01-02"), r.to.Format("2006-01-02"))
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "coin\tinvested\tfees\tacquired\tavg cost\tvalue\treturn\t")
+
+	var invested, fees, value float64
+	for _, c := range r.coins {
+		fmt.Fprintf(w, "%s\t%.2f\t%.2f\t%.8f\t%.2f\t%.2f\t%s\t\n",
+			c.coin, c.invested, c.fees, c.acquired, c.averageCost(), c.value, percentageReturn(c.invested, c.value))
+		invested += c.invested
+		fees += c.fees
+		value += c.value
+	}
+
+	fmt.Fprintf(w, "total\t%.2f\t%.2f\t\t\t%.2f\t%s\t\n", invested, fees, value, percentageReturn(invested, value))
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(out, "\nDeposited %.2f\n", r.deposited)
+	return err
+}
+
+func percentageReturn(invested float64, value float64) string {
+	if invested == 0 {
+		return "-"
+	}
+
+	return fmt.Sprintf("%+.2f%%", (value-invested)/invested*100)
+}
+
+// candleHistoryStart returns how far back candles are needed, the dip strategy needs days before the first window.
+func candleHistoryStart(req syncRequest, from time.Time) time.Time {
+	if req.strategy == "dip" {
+		return from.AddDate(0, 0, -3*req.maPeriod-1)
+	}
+
+	return from
+}
+
+// candleSource loads daily candles from a directory of csv files or fetches them from the exchange once and caches them.
+type candleSource struct {
+	dir      string // user provided csv files, nothing is fetched when set
+	cacheDir string
+	currency string
+	fetch    func() (exchanges.Exchange, error)
+}
+
+func (c *candleSource) load(coin string, start time.Time, end time.Time) ([]exchanges.Candle, error) {
+	name := coin + "-" + c.currency + ".csv"
+
+	if c.dir != "" {
+		return readCandlesFile(filepath.Join(c.dir, name))
+	}
+
+	path := filepath.Join(c.cacheDir, name)
+	if candles, err := readCandlesFile(path); err == nil && coversRange(candles, start, end) {
+		return candles, nil
+	}
+
+	exchange, err := c.fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	candles, err := exchange.GetCandles(exchange.GetTickerSymbol(coin, c.currency), start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(c.cacheDir, 0700); err != nil {
+		return nil, err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if err := exchanges.WriteCandlesCSV(file, candles); err != nil {
+		return nil, err
+	}
+
+	return candles, nil
+}
+
+func readCandlesFile(path string) ([]exchanges.Candle, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	candles, err := exchanges.ReadCandlesCSV(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return candles, nil
+}
+
+// coversRange tells if the candles span the range, a day of slack on both ends allows for the last candle still being open.
+func coversRange(candles []exchanges.Candle, start time.Time, end time.Time) bool {
+	if len(candles) == 0 {
+		return false
+	}
+
+	day := 24 * time.Hour
+	return !candles[0].Time.After(start.Add(day)) && !candles[len(candles)-1].Time.Before(end.Add(-2*day))
+}
+
+// planCoin returns the coin of a --coin COIN:PERCENTAGE[:EVERY] value.
+func planCoin(c string) string {
+	coin, _, _ := strings.Cut(c, ":")
+	return strings.ToUpper(coin)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/sberserker/dcagdax/exchanges"
+	"github.com/stretchr/testify/assert"
+)
+
+func flatCandles(from time.Time, days int, price float64) []exchanges.Candle {
+	candles := []exchanges.Candle{}
+	for i := 0; i < days; i++ {
+		candles = append(candles, exchanges.Candle{
+			Time:  from.AddDate(0, 0, i),
+			Open:  price,
+			High:  price,
+			Low:   price,
+			Close: price,
+		})
+	}
+	return candles
+}
+
+func TestBacktest(t *testing.T) {
+	from := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 0, 30)
+
+	candles := map[string][]exchanges.Candle{
+		"BTC-USD": flatCandles(from, 30, 100),
+		"ETH-USD": flatCandles(from, 30, 10),
+	}
+
+	req := syncRequest{
+		coins:     []string{"BTC:70", "ETH:30"},
+		every:     7 * 24 * time.Hour,
+		usd:       100,
+		fee:       0.5,
+		currency:  "USD",
+		orderType: exchanges.Market,
+	}
+
+	b, err := newBacktest(loggerStub(t).Sugar(), req, candles, from)
+	assert.Nil(t, err)
+
+	b.run(to)
+
+	// purchases on days 0, 7, 14, 21 and 28
+	assert.Len(t, b.exchange.Fills(), 10)
+
+	r := b.report(from, to)
+	assert.Equal(t, 500.0, r.deposited)
+	assert.Equal(t, "BTC", r.coins[0].coin)
+	assert.InDelta(t, 350, r.coins[0].invested, 0.0001)
+	assert.InDelta(t, 1.75, r.coins[0].fees, 0.0001)
+	assert.InDelta(t, 3.4825, r.coins[0].acquired, 0.0001)
+	assert.InDelta(t, 348.25, r.coins[0].value, 0.0001)
+	assert.InDelta(t, 100.5025, r.coins[0].averageCost(), 0.0001)
+	assert.InDelta(t, 150, r.coins[1].invested, 0.0001)
+	assert.InDelta(t, 14.925, r.coins[1].acquired, 0.0001)
+
+	var out bytes.Buffer
+	assert.Nil(t, r.print(&out))
+	assert.Contains(t, out.String(), "Backtest from 2020-01-01 to 2020-01-31")
+	assert.Contains(t, out.String(), "Deposited 500.00")
+}
+
+func TestCandleSource(t *testing.T) {
+	dir := t.TempDir()
+	from := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	candles := flatCandles(from, 10, 100)
+	fetched := 0
+
+	source := candleSource{
+		cacheDir: dir,
+		currency: "USD",
+		fetch: func() (exchanges.Exchange, error) {
+			fetched++
+			return exchanges.NewSimulated("USD", 0, map[string][]exchanges.Candle{"BTC-USD": candles}), nil
+		},
+	}
+
+	loaded, err := source.load("BTC", from, from.AddDate(0, 0, 10))
+	assert.Nil(t, err)
+	assert.Equal(t, candles, loaded)
+
+	// second time it comes from the cache
+	loaded, err = source.load("BTC", from, from.AddDate(0, 0, 10))
+	assert.Nil(t, err)
+	assert.Equal(t, candles, loaded)
+	assert.Equal(t, 1, fetched)
+
+	source.dir = t.TempDir()
+	_, err = source.load("BTC", from, from.AddDate(0, 0, 10))
+	assert.NotNil(t, err)
+}
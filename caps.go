@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	capMonth = "month"
+	capYear  = "year"
+)
+
+var errCapsNeedLedger = errors.New("--cap totals the spend from the ledger, it cannot be used with --no-ledger")
+
+var capRegex = regexp.MustCompile(`^(?:([A-Za-z0-9]+):)?(\d+(?:\.\d+)?)/(month|year)$`)
+
+// spendCap is the most the plans may spend in a calendar month or year, on all coins or a single one.
+type spendCap struct {
+	coin   string // empty caps the spend on all coins
+	amount float64
+	period string // month or year
+}
+
+// parseCap parses a --cap in [COIN:]AMOUNT/PERIOD format, e.g. 1000/month or BTC:5000/year.
+func parseCap(value string) (spendCap, error) {
+	matches := capRegex.FindStringSubmatch(value)
+	if matches == nil {
+		return spendCap{}, fmt.Errorf("--cap %s misformatted, expected [COIN:]AMOUNT/month or [COIN:]AMOUNT/year", value)
+	}
+
+	amount, err := strconv.ParseFloat(matches[2], 64)
+	if err != nil {
+		return spendCap{}, err
+	}
+
+	return spendCap{coin: strings.ToUpper(matches[1]), amount: amount, period: matches[3]}, nil
+}
+
+// start returns the beginning of the calendar month or year now falls into.
+func (c spendCap) start(now time.Time) time.Time {
+	if c.period == capYear {
+		return time.Date(now.Year(), time.January, 1, 0, 0, 0, 0, now.Location())
+	}
+
+	return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+}
+
+// spent totals what all plans spent in the currency since the start of the cap's period.
+// An order counts what it filled once it is done, until then the amount it was placed for as the rest may still execute.
+// Paper trading counts only against paper plans' caps.
+func (s *gdaxSchedule) spent(c spendCap, now time.Time) float64 {
+	start := c.start(now)
+	paper := s.req.exchange == "paper"
+
+	//orders are matched to their fills by id
+	placed := map[string]float64{}
+	filled := map[string]ledgerEntry{} // the latest fill of every order
+	total := decimal.Zero
+	for _, e := range s.ledger.entries {
+		if e.Currency != s.req.currency || (e.Exchange == "paper") != paper || e.Time.Before(start) {
+			continue
+		}
+		if c.coin != "" && e.Coin != c.coin {
+			continue
+		}
+
+		switch {
+		case e.Kind == ledgerOrder && e.OrderId != "":
+			placed[e.OrderId] = e.Amount
+		case e.Kind == ledgerFill && e.OrderId != "":
+			filled[e.OrderId] = e
+		case e.Kind == ledgerFill:
+			total = total.Add(decimal.NewFromFloat(e.Amount))
+		}
+	}
+
+	for id, amount := range placed {
+		if fill, found := filled[id]; found && (fill.Done || fill.Amount > amount) {
+			continue
+		}
+		total = total.Add(decimal.NewFromFloat(amount))
+	}
+	for id, fill := range filled {
+		if amount, found := placed[id]; found && !fill.Done && amount >= fill.Amount {
+			continue
+		}
+		total = total.Add(decimal.NewFromFloat(fill.Amount))
+	}
+
+	t, _ := total.Float64()
+	return t
+}
+
+// capRemaining returns what the caps leave to spend on the coin, all coins when coin is empty.
+// ok is false when no cap applies.
+func (s *gdaxSchedule) capRemaining(coin string, now time.Time) (float64, bool) {
+	remaining := 0.0
+	ok := false
+
+	for _, c := range s.caps {
+		if c.coin != coin {
+			continue
+		}
+
+		left, _ := decimal.NewFromFloat(c.amount).Sub(decimal.NewFromFloat(s.spent(c, now))).Truncate(2).Float64()
+		if left < 0 {
+			left = 0
+		}
+
+		if !ok || left < remaining {
+			remaining = left
+		}
+		ok = true
+	}
+
+	return remaining, ok
+}
+
+// applyCaps cuts the amounts to what the caps leave to spend.
+// Coin caps cut their coin, the caps on all coins cut every coin in proportion, coins cut below the minimum are skipped.
+func (s *gdaxSchedule) applyCaps(due []string, amounts map[string]float64, now time.Time) map[string]float64 {
+	if len(s.caps) == 0 {
+		return amounts
+	}
+
+	capped := map[string]float64{}
+	total := decimal.Zero
+	for _, coin := range due {
+		amount, found := amounts[coin]
+		if !found {
+			continue
+		}
+
+		if left, ok := s.capRemaining(coin, now); ok && amount > left {
+			s.logger.Infow(
+				"Spending cap cuts the purchase",
+				"coin", coin,
+				"amount", amount,
+				"remaining", left,
+			)
+			amount = left
+		}
+
+		capped[coin] = amount
+		total = total.Add(decimal.NewFromFloat(amount))
+	}
+
+	if left, ok := s.capRemaining("", now); ok && total.GreaterThan(decimal.NewFromFloat(left)) {
+		s.logger.Infow(
+			"Spending cap cuts the purchase",
+			"amount", total.String(),
+			"remaining", left,
+		)
+
+		ratio := decimal.Zero
+		if total.IsPositive() {
+			ratio = decimal.NewFromFloat(left).Div(total)
+		}
+		for coin, amount := range capped {
+			capped[coin], _ = decimal.NewFromFloat(amount).Mul(ratio).Truncate(2).Float64()
+		}
+	}
+
+	for _, coin := range due {
+		amount, found := capped[coin]
+		if !found {
+			continue
+		}
+
+		if amount <= 0 || amount < s.coins[coin].minimum {
+			reason := fmt.Sprintf("Spending cap leaves $%.02f for %s, below the minimum trade amount $%.02f", amount, coin, s.coins[coin].minimum)
+			s.logger.Infow(reason)
+			s.skipCoin(coin, amounts[coin], reason)
+			delete(capped, coin)
+		}
+	}
+
+	return capped
+}
+
+// capsReached tells whether the caps on all coins leave nothing to spend, the funding plan doesn't deposit then.
+func (s *gdaxSchedule) capsReached(now time.Time) bool {
+	left, ok := s.capRemaining("", now)
+	return ok && left <= 0
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sberserker/dcagdax/exchanges"
+	"github.com/sberserker/dcagdax/mocks"
+)
+
+func TestParseCap(t *testing.T) {
+	c, err := parseCap("1000/month")
+	assert.Nil(t, err)
+	assert.Equal(t, spendCap{amount: 1000, period: capMonth}, c)
+
+	c, err = parseCap("BTC:5000.50/year")
+	assert.Nil(t, err)
+	assert.Equal(t, spendCap{coin: "BTC", amount: 5000.5, period: capYear}, c)
+
+	c, err = parseCap("btc:500/month")
+	assert.Nil(t, err)
+	assert.Equal(t, "BTC", c.coin)
+
+	for _, value := range []string{"1000", "1000/week", "BTC:/month", "-5/month"} {
+		_, err := parseCap(value)
+		assert.NotNil(t, err, value)
+	}
+}
+
+func TestApplyCaps(t *testing.T) {
+	now := time.Date(2024, time.March, 15, 12, 0, 0, 0, time.UTC)
+
+	l, _ := openLedger("")
+	l.record(ledgerEntry{Time: time.Date(2024, time.February, 20, 0, 0, 0, 0, time.UTC), Kind: ledgerFill, Plan: "other", Exchange: "coinbase", Currency: "USD", Coin: "BTC", OrderId: "1", Amount: 500, Done: true})
+	l.record(ledgerEntry{Time: time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC), Kind: ledgerFill, Plan: "other", Exchange: "gemini", Currency: "USD", Coin: "BTC", OrderId: "2", Amount: 40, Done: true})
+	l.record(ledgerEntry{Time: time.Date(2024, time.March, 8, 0, 0, 0, 0, time.UTC), Kind: ledgerOrder, Exchange: "coinbase", Currency: "USD", Coin: "ETH", OrderId: "3", Amount: 20})
+	l.record(ledgerEntry{Time: time.Date(2024, time.March, 8, 0, 0, 0, 0, time.UTC), Kind: ledgerFill, Exchange: "coinbase", Currency: "USD", Coin: "ETH", OrderId: "3", Amount: 19.9, Done: true})
+	l.record(ledgerEntry{Time: time.Date(2024, time.March, 9, 0, 0, 0, 0, time.UTC), Kind: ledgerFill, Exchange: "coinbase", Currency: "EUR", Coin: "ETH", OrderId: "4", Amount: 1000, Done: true})
+	//left to the exchange unconfirmed
+	l.record(ledgerEntry{Time: time.Date(2024, time.March, 10, 0, 0, 0, 0, time.UTC), Kind: ledgerOrder, Exchange: "coinbase", Currency: "USD", Coin: "BTC", OrderId: "5", Amount: 20})
+	l.record(ledgerEntry{Time: time.Date(2024, time.March, 10, 0, 0, 0, 0, time.UTC), Kind: ledgerOrder, Exchange: "coinbase", Currency: "USD", Coin: "BTC", OrderId: "6", Amount: 10})
+	l.record(ledgerEntry{Time: time.Date(2024, time.March, 10, 0, 0, 0, 0, time.UTC), Kind: ledgerFill, Exchange: "coinbase", Currency: "USD", Coin: "BTC", OrderId: "6", Amount: 5})
+	//cancelled without executing
+	l.record(ledgerEntry{Time: time.Date(2024, time.March, 11, 0, 0, 0, 0, time.UTC), Kind: ledgerOrder, Exchange: "coinbase", Currency: "USD", Coin: "ETH", OrderId: "7", Amount: 40})
+	l.record(ledgerEntry{Time: time.Date(2024, time.March, 11, 0, 0, 0, 0, time.UTC), Kind: ledgerFill, Exchange: "coinbase", Currency: "USD", Coin: "ETH", OrderId: "7", Done: true})
+	l.record(ledgerEntry{Time: time.Date(2024, time.March, 12, 0, 0, 0, 0, time.UTC), Kind: ledgerOrder, Plan: "paper", Exchange: "paper", Currency: "USD", Coin: "BTC", OrderId: "8", Amount: 100})
+
+	s := gdaxSchedule{}
+	s.logger = loggerStub(t).Sugar()
+	s.req = syncRequest{exchange: "coinbase", currency: "USD"}
+	s.coins = map[string]orderDetails{"BTC": {symbol: "BTC-USD", minimum: 1}, "ETH": {symbol: "ETH-USD", minimum: 15}}
+	s.ledger = l
+
+	due := []string{"BTC", "ETH"}
+	amounts := map[string]float64{"BTC": 50, "ETH": 50}
+
+	t.Run("when no cap applies", func(t *testing.T) {
+		assert.Equal(t, amounts, s.applyCaps(due, amounts, now))
+	})
+
+	t.Run("when a coin cap is nearly reached", func(t *testing.T) {
+		s.caps = []spendCap{{coin: "BTC", amount: 90, period: capMonth}}
+
+		assert.InDelta(t, 89.9, s.spent(spendCap{amount: 1, period: capMonth}, now), 0.000001)
+		assert.Equal(t, 70.0, s.spent(spendCap{coin: "BTC", amount: 1, period: capMonth}, now))
+		assert.Equal(t, map[string]float64{"BTC": 20, "ETH": 50}, s.applyCaps(due, amounts, now))
+	})
+
+	t.Run("when the cap on all coins is nearly reached", func(t *testing.T) {
+		s.caps = []spendCap{{amount: 1000, period: capYear}, {amount: 129.9, period: capMonth}}
+
+		assert.Equal(t, map[string]float64{"BTC": 20, "ETH": 20}, s.applyCaps(due, amounts, now))
+	})
+
+	t.Run("when a cap leaves less than the minimum", func(t *testing.T) {
+		s.caps = []spendCap{{amount: 609.9, period: capYear}}
+
+		assert.Equal(t, map[string]float64{"BTC": 10}, s.applyCaps(due, amounts, now))
+		assert.Equal(t, ledgerSkip, l.entries[len(l.entries)-1].Kind)
+		assert.Equal(t, "ETH", l.entries[len(l.entries)-1].Coin)
+	})
+
+	t.Run("when the cap is reached", func(t *testing.T) {
+		s.caps = []spendCap{{amount: 589.9, period: capYear}}
+
+		assert.Empty(t, s.applyCaps(due, amounts, now))
+		assert.True(t, s.capsReached(now))
+	})
+
+	t.Run("when a paper plan is capped", func(t *testing.T) {
+		paper := s
+		paper.req.exchange = "paper"
+
+		assert.Equal(t, 100.0, paper.spent(spendCap{amount: 1, period: capMonth}, now))
+	})
+}
+
+func TestSyncDoesNotForceThroughCaps(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockExchange(ctrl)
+
+	l, _ := openLedger("")
+	l.record(ledgerEntry{Kind: ledgerFill, Exchange: "coinbase", Currency: "USD", Coin: "BTC", Amount: 100})
+
+	s := gdaxSchedule{}
+	s.logger = loggerStub(t).Sugar()
+	s.req = syncRequest{exchange: "coinbase", every: 24 * time.Hour, orderType: exchanges.Market, currency: "USD", usd: 50, force: true}
+	s.coins = map[string]orderDetails{"BTC": {symbol: "btcusd", amount: 50}}
+	s.confirmFunc = func(string) bool { return true }
+	s.exchange = m
+	s.ledger = l
+	s.caps = []spendCap{{amount: 100, period: capMonth}}
+
+	err := s.Sync()
+
+	assert.Equal(t, "Spending caps leave nothing to spend this window", err.Error())
+}
+
+func TestNewScheduleWithCaps(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockExchange(ctrl)
+
+	_, err := newGdaxSchedule(m, loggerStub(t).Sugar(), false, syncRequest{caps: []string{"1000/month"}}, nil)
+	assert.Equal(t, errCapsNeedLedger, err)
+
+	l, _ := openLedger("")
+	_, err = newGdaxSchedule(m, loggerStub(t).Sugar(), false, syncRequest{caps: []string{"1000/week"}}, l)
+	assert.EqualError(t, err, "--cap 1000/week misformatted, expected [COIN:]AMOUNT/month or [COIN:]AMOUNT/year")
+
+	//a lower case plan coin is held to the cap of the coin
+	now := time.Now()
+	l.record(ledgerEntry{Time: now, Kind: ledgerFill, Exchange: "coinbase", Currency: "USD", Coin: "BTC", OrderId: "1", Amount: 450, Done: true})
+
+	m.EXPECT().GetTickerSymbol("BTC", "USD").Return("BTC-USD")
+	m.EXPECT().GetProduct("BTC-USD").Return(&exchanges.Product{BaseMinSize: 0.0001}, nil)
+	m.EXPECT().GetTicker("BTC-USD").Return(&exchanges.Ticker{Price: 1000}, nil)
+
+	s, err := newGdaxSchedule(m, loggerStub(t).Sugar(), false, syncRequest{
+		exchange: "coinbase",
+		currency: "USD",
+		usd:      100,
+		every:    24 * time.Hour,
+		coins:    []string{"btc:100"},
+		caps:     []string{"BTC:500/month"},
+	}, l)
+	assert.Nil(t, err)
+	assert.Contains(t, s.coins, "BTC")
+	assert.Equal(t, map[string]float64{"BTC": 50}, s.applyCaps([]string{"BTC"}, map[string]float64{"BTC": 100}, now))
+}
@@ -2,6 +2,7 @@ package coinbase
 
 import (
 	"fmt"
+	"net/url"
 	"time"
 )
 
@@ -43,10 +44,18 @@ func (c *Client) Deposit(accountId string, deposit DepositParams) (DepositRespon
 }
 
 type ListDeposits struct {
-	Data []Deposit `json:"data"`
+	Pagination Pagination `json:"pagination"`
+	Data       []Deposit  `json:"data"`
+}
+
+// Pagination is the cursor of a list, NextStartingAfter is empty on the last page.
+type Pagination struct {
+	NextStartingAfter string `json:"next_starting_after"`
 }
 
 type Deposit struct {
+	ID        string    `json:"id"`
+	Status    string    `json:"status"` // created, completed or canceled
 	Amount    Amount    `json:"amount"`
 	Subtotal  Amount    `json:"subtotal"`
 	Fee       Amount    `json:"fee"`
@@ -60,9 +69,24 @@ type Amount struct {
 	Currency string  `json:"currency"`
 }
 
+// ListDeposits returns all deposits of the account, following the pages of the list.
 func (c *Client) ListDeposits(id string) ([]Deposit, error) {
-	var response ListDeposits
-	_, err := c.Request("GET", fmt.Sprintf("/accounts/%s/deposits", id), nil, &response)
+	deposits := []Deposit{}
+	params := url.Values{"limit": {"100"}}
+
+	for {
+		var response ListDeposits
+		_, err := c.Request("GET", fmt.Sprintf("/accounts/%s/deposits?%s", id, params.Encode()), nil, &response)
+		if err != nil {
+			return nil, err
+		}
+
+		deposits = append(deposits, response.Data...)
 
-	return response.Data, err
+		next := response.Pagination.NextStartingAfter
+		if next == "" || next == params.Get("starting_after") {
+			return deposits, nil
+		}
+		params.Set("starting_after", next)
+	}
 }
@@ -0,0 +1,930 @@
+// Package coinbasefake is an in memory Coinbase server for offline end to end tests.
+// It serves the Advanced Trade (v3) accounts, products, market trades, orders, fills, fee tier and payment methods
+// endpoints and the v2 deposit endpoints, keeping balances and orders consistent between calls.
+package coinbasefake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sberserker/dcagdax/clients/coinbase"
+	"github.com/sberserker/dcagdax/clients/coinbasev3"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	StatusOpen      = "OPEN"
+	StatusFilled    = "FILLED"
+	StatusCancelled = "CANCELLED"
+)
+
+type Server struct {
+	*httptest.Server
+
+	// Fee is the fraction of the filled value charged on every fill, e.g. 0.006.
+	Fee float64
+	// MakerFee is the fraction charged on fills of post-only orders, Fee when zero.
+	MakerFee float64
+	// HoldDeposits keeps deposits pending until SettleDeposits is called, otherwise they are available right away.
+	HoldDeposits bool
+	// Now is the server's clock, orders, fills and deposits are stamped with it.
+	Now func() time.Time
+	// DepositsPageSize caps the deposits listed per page, zero lists as many as the request's limit.
+	DepositsPageSize int
+
+	mu             sync.Mutex
+	accounts       []*account
+	products       map[string]*product
+	orders         []*order
+	fills          []coinbasev3.Fill
+	paymentMethods []coinbasev3.PaymentMethod
+	deposits       []*Deposit
+}
+
+type account struct {
+	uuid      string
+	currency  string
+	available decimal.Decimal
+	hold      decimal.Decimal
+}
+
+type product struct {
+	id          string
+	base        string
+	quote       string
+	price       decimal.Decimal
+	baseMinSize string
+	candles     []coinbasev3.ProductCandles
+	book        *coinbasev3.PriceBook
+}
+
+type order struct {
+	coinbasev3.Order
+	hold decimal.Decimal // quote amount put on hold for an open limit order
+}
+
+// Deposit is a deposit initiated through the v2 api.
+type Deposit struct {
+	Id        string
+	AccountId string
+	Currency  string
+	Amount    float64
+	MethodId  string // payment method the deposit is made from
+	Settled   bool
+	Canceled  bool
+	CreatedAt time.Time
+	PayoutAt  time.Time
+}
+
+// NewServer starts a fake server, close it when done.
+func NewServer() *Server {
+	s := &Server{
+		Now:      time.Now,
+		products: map[string]*product{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+
+	return s
+}
+
+// UrlV3 is the base url for the Advanced Trade api.
+func (s *Server) UrlV3() string {
+	return s.URL + "/api/v3"
+}
+
+// UrlV2 is the base url for the v2 api.
+func (s *Server) UrlV2() string {
+	return s.URL + "/v2"
+}
+
+// SetAccount creates the currency's account or replaces its available balance.
+func (s *Server) SetAccount(currency string, available float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.account(currency).available = decimal.NewFromFloat(available)
+}
+
+// Balance returns the available and held balance of the currency's account.
+func (s *Server) Balance(currency string) (available float64, hold float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a := s.account(currency)
+	available, _ = a.available.Float64()
+	hold, _ = a.hold.Float64()
+
+	return available, hold
+}
+
+// SetProduct creates the product or changes its price, open limit orders at or above the new price fill.
+func (s *Server) SetProduct(productId string, price float64, baseMinSize float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, found := s.products[productId]
+	if !found {
+		base, quote, _ := strings.Cut(productId, "-")
+		p = &product{id: productId, base: base, quote: quote}
+		s.products[productId] = p
+	}
+
+	p.price = decimal.NewFromFloat(price)
+	p.baseMinSize = decimal.NewFromFloat(baseMinSize).String()
+
+	for _, o := range s.orders {
+		if o.ProductId == productId && o.Status == StatusOpen {
+			s.matchLimit(o, p)
+		}
+	}
+}
+
+// SetCandles sets the candles served for the product.
+func (s *Server) SetCandles(productId string, candles []coinbasev3.ProductCandles) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p, found := s.products[productId]; found {
+		p.candles = candles
+	}
+}
+
+// SetBook sets the order book served for the product, otherwise the book has deep levels at the price on both sides.
+func (s *Server) SetBook(productId string, bids []coinbasev3.PriceBookOrder, asks []coinbasev3.PriceBookOrder) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p, found := s.products[productId]; found {
+		p.book = &coinbasev3.PriceBook{ProductId: productId, Bids: bids, Asks: asks}
+	}
+}
+
+// AddPaymentMethod makes the payment method available for deposits.
+func (s *Server) AddPaymentMethod(method coinbasev3.PaymentMethod) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.paymentMethods = append(s.paymentMethods, method)
+}
+
+// SettleDeposits makes all pending deposits available.
+func (s *Server) SettleDeposits() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, d := range s.deposits {
+		if !d.Settled && !d.Canceled {
+			s.settle(d)
+		}
+	}
+}
+
+// CancelDeposit cancels the pending deposit, its money never becomes available.
+func (s *Server) CancelDeposit(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, d := range s.deposits {
+		if d.Id == id && !d.Settled {
+			d.Canceled = true
+		}
+	}
+}
+
+// Orders returns all orders placed, oldest first.
+func (s *Server) Orders() []coinbasev3.Order {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	orders := []coinbasev3.Order{}
+	for _, o := range s.orders {
+		orders = append(orders, o.Order)
+	}
+
+	return orders
+}
+
+// Deposits returns all deposits initiated, oldest first.
+func (s *Server) Deposits() []Deposit {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deposits := []Deposit{}
+	for _, d := range s.deposits {
+		deposits = append(deposits, *d)
+	}
+
+	return deposits
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/api/v3/brokerage/"):
+		s.serveV3(w, r, strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v3/brokerage/"), "/"))
+	case strings.HasPrefix(r.URL.Path, "/v2/"):
+		s.serveV2(w, r, strings.Split(strings.TrimPrefix(r.URL.Path, "/v2/"), "/"))
+	default:
+		notFound(w, r)
+	}
+}
+
+func (s *Server) serveV3(w http.ResponseWriter, r *http.Request, path []string) {
+	switch {
+	case r.Method == http.MethodGet && len(path) == 1 && path[0] == "accounts":
+		s.listAccounts(w)
+	case r.Method == http.MethodGet && len(path) == 2 && path[0] == "accounts":
+		s.getAccount(w, r, path[1])
+	case r.Method == http.MethodGet && len(path) == 2 && path[0] == "products":
+		s.getProduct(w, r, path[1])
+	case r.Method == http.MethodGet && len(path) == 3 && path[0] == "products" && path[2] == "ticker":
+		s.getMarketTrades(w, r, path[1])
+	case r.Method == http.MethodGet && len(path) == 3 && path[0] == "products" && path[2] == "candles":
+		s.getCandles(w, r, path[1])
+	case r.Method == http.MethodGet && len(path) == 1 && path[0] == "product_book":
+		s.getProductBook(w, r)
+	case r.Method == http.MethodGet && len(path) == 1 && path[0] == "transaction_summary":
+		writeJSON(w, http.StatusOK, coinbasev3.TransactionSummaryData{FeeTier: coinbasev3.FeeTier{
+			PricingTier:  "Advanced 1",
+			MakerFeeRate: strconv.FormatFloat(s.makerFee(), 'f', -1, 64),
+			TakerFeeRate: strconv.FormatFloat(s.Fee, 'f', -1, 64),
+		}})
+	case r.Method == http.MethodGet && len(path) == 1 && path[0] == "payment_methods":
+		writeJSON(w, http.StatusOK, coinbasev3.PaymentMethods{PaymentMethods: s.paymentMethods})
+	case r.Method == http.MethodPost && len(path) == 1 && path[0] == "orders":
+		s.createOrder(w, r)
+	case r.Method == http.MethodPost && len(path) == 2 && path[0] == "orders" && path[1] == "batch_cancel":
+		s.cancelOrders(w, r)
+	case r.Method == http.MethodPost && len(path) == 2 && path[0] == "orders" && path[1] == "edit":
+		s.editOrder(w, r)
+	case r.Method == http.MethodGet && len(path) == 3 && path[0] == "orders" && path[1] == "historical" && path[2] == "batch":
+		s.listOrders(w, r)
+	case r.Method == http.MethodGet && len(path) == 3 && path[0] == "orders" && path[1] == "historical" && path[2] == "fills":
+		s.listFills(w, r)
+	case r.Method == http.MethodGet && len(path) == 3 && path[0] == "orders" && path[1] == "historical":
+		s.getOrder(w, r, path[2])
+	default:
+		notFound(w, r)
+	}
+}
+
+func (s *Server) serveV2(w http.ResponseWriter, r *http.Request, path []string) {
+	switch {
+	case r.Method == http.MethodPost && len(path) == 3 && path[0] == "accounts" && path[2] == "deposits":
+		s.createDeposit(w, r, path[1])
+	case r.Method == http.MethodGet && len(path) == 3 && path[0] == "accounts" && path[2] == "deposits":
+		s.listDeposits(w, r, path[1])
+	default:
+		notFound(w, r)
+	}
+}
+
+func (s *Server) listAccounts(w http.ResponseWriter) {
+	accounts := []coinbasev3.Account{}
+	for _, a := range s.accounts {
+		accounts = append(accounts, a.toAccount())
+	}
+
+	writeJSON(w, http.StatusOK, coinbasev3.ListAccountsData{
+		Accounts: accounts,
+		Size:     len(accounts),
+	})
+}
+
+func (s *Server) getAccount(w http.ResponseWriter, r *http.Request, id string) {
+	for _, a := range s.accounts {
+		if a.uuid == id {
+			writeJSON(w, http.StatusOK, coinbasev3.GetAccountData{Account: a.toAccount()})
+			return
+		}
+	}
+
+	notFound(w, r)
+}
+
+func (s *Server) getProduct(w http.ResponseWriter, r *http.Request, id string) {
+	p, found := s.products[id]
+	if !found {
+		notFound(w, r)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, coinbasev3.Product{
+		ProductId:       p.id,
+		Price:           p.price.String(),
+		BaseMinSize:     p.baseMinSize,
+		BaseCurrencyId:  p.base,
+		QuoteCurrencyId: p.quote,
+		Status:          "online",
+		ProductType:     string(coinbasev3.ProductTypeSpot),
+	})
+}
+
+func (s *Server) getMarketTrades(w http.ResponseWriter, r *http.Request, id string) {
+	p, found := s.products[id]
+	if !found {
+		notFound(w, r)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, coinbasev3.MarketTradesData{
+		Trades: []coinbasev3.MarketTrade{{
+			TradeId:   uuid.NewString(),
+			ProductId: p.id,
+			Price:     p.price.String(),
+			Size:      "1",
+			Side:      string(coinbasev3.OrderSideBuy),
+			Time:      s.Now(),
+		}},
+		BestBid: p.price.String(),
+		BestAsk: p.price.String(),
+	})
+}
+
+func (s *Server) getProductBook(w http.ResponseWriter, r *http.Request) {
+	p, found := s.products[r.URL.Query().Get("product_id")]
+	if !found {
+		notFound(w, r)
+		return
+	}
+
+	book := p.book
+	if book == nil {
+		level := []coinbasev3.PriceBookOrder{{Price: p.price.String(), Size: "1000000"}}
+		book = &coinbasev3.PriceBook{ProductId: p.id, Bids: level, Asks: level}
+	}
+
+	writeJSON(w, http.StatusOK, coinbasev3.ProductBookData{PriceBook: *book})
+}
+
+func (s *Server) getCandles(w http.ResponseWriter, r *http.Request, id string) {
+	p, found := s.products[id]
+	if !found {
+		notFound(w, r)
+		return
+	}
+
+	start, err := strconv.ParseInt(r.URL.Query().Get("start"), 10, 64)
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	end, err := strconv.ParseInt(r.URL.Query().Get("end"), 10, 64)
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+
+	candles := []coinbasev3.ProductCandles{}
+	for _, c := range p.candles {
+		if t, _ := strconv.ParseInt(c.Start, 10, 64); t < start || t > end {
+			continue
+		}
+		candles = append(candles, c)
+	}
+
+	writeJSON(w, http.StatusOK, coinbasev3.ProductCandlesData{Candles: candles})
+}
+
+func (s *Server) createOrder(w http.ResponseWriter, r *http.Request) {
+	var req coinbasev3.CreateOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+
+	// the same client order id returns the order placed before
+	for _, o := range s.orders {
+		if req.ClientOrderID != "" && o.ClientOrderId == req.ClientOrderID {
+			writeJSON(w, http.StatusOK, orderCreated(o))
+			return
+		}
+	}
+
+	p, found := s.products[req.ProductID]
+	if !found {
+		writeJSON(w, http.StatusOK, orderFailed("UNKNOWN_FAILURE_REASON", "product not found"))
+		return
+	}
+
+	if req.Side != coinbasev3.OrderSideBuy {
+		writeJSON(w, http.StatusOK, orderFailed("UNSUPPORTED_ORDER_CONFIGURATION", "only buy orders are supported"))
+		return
+	}
+
+	quote := s.account(p.quote)
+	o := &order{Order: coinbasev3.Order{
+		OrderId:            uuid.NewString(),
+		ProductId:          p.id,
+		ClientOrderId:      req.ClientOrderID,
+		Side:               string(req.Side),
+		OrderConfiguration: req.OrderConfiguration,
+		CreatedTime:        s.Now(),
+		Status:             StatusOpen,
+		FilledSize:         "0",
+		FilledValue:        "0",
+		TotalFees:          "0",
+		AverageFilledPrice: "0",
+	}}
+
+	config := req.OrderConfiguration
+
+	switch {
+	case config.MarketMarketIoc != nil:
+		amount, err := decimal.NewFromString(config.MarketMarketIoc.QuoteSize)
+		if err != nil {
+			writeJSON(w, http.StatusOK, orderFailed("INVALID_QUOTE_SIZE", err.Error()))
+			return
+		}
+
+		if quote.available.LessThan(amount) {
+			writeJSON(w, http.StatusOK, orderFailed("INSUFFICIENT_FUND", "Insufficient balance in source account"))
+			return
+		}
+
+		o.OrderType = string(coinbasev3.OrderTypeMarket)
+		o.TimeInForce = "IMMEDIATE_OR_CANCEL"
+		o.SizeInQuote = true
+
+		fee := amount.Mul(decimal.NewFromFloat(s.Fee))
+		size := amount.Sub(fee).Div(p.price).Truncate(8)
+		quote.available = quote.available.Sub(amount)
+		s.fill(o, p, size, p.price, fee)
+	case config.LimitLimitGtc != nil:
+		size, err := decimal.NewFromString(config.LimitLimitGtc.BaseSize)
+		if err != nil {
+			writeJSON(w, http.StatusOK, orderFailed("INVALID_SIZE", err.Error()))
+			return
+		}
+
+		limit, err := decimal.NewFromString(config.LimitLimitGtc.LimitPrice)
+		if err != nil {
+			writeJSON(w, http.StatusOK, orderFailed("INVALID_LIMIT_PRICE", err.Error()))
+			return
+		}
+
+		if config.LimitLimitGtc.PostOnly && limit.GreaterThanOrEqual(p.price) {
+			writeJSON(w, http.StatusOK, orderFailed("INVALID_LIMIT_PRICE_POST_ONLY", "Post only order would cross the book"))
+			return
+		}
+
+		hold := size.Mul(limit).Mul(decimal.NewFromFloat(1 + s.Fee))
+		if quote.available.LessThan(hold) {
+			writeJSON(w, http.StatusOK, orderFailed("INSUFFICIENT_FUND", "Insufficient balance in source account"))
+			return
+		}
+
+		o.OrderType = string(coinbasev3.OrderTypeLimit)
+		o.TimeInForce = "GOOD_UNTIL_CANCELLED"
+		o.hold = hold
+		quote.available = quote.available.Sub(hold)
+		quote.hold = quote.hold.Add(hold)
+		s.matchLimit(o, p)
+	default:
+		writeJSON(w, http.StatusOK, orderFailed("UNSUPPORTED_ORDER_CONFIGURATION", "order configuration is not supported"))
+		return
+	}
+
+	s.orders = append(s.orders, o)
+	writeJSON(w, http.StatusOK, orderCreated(o))
+}
+
+// matchLimit fills the open limit order when the price is at or below its limit.
+func (s *Server) matchLimit(o *order, p *product) {
+	config := o.OrderConfiguration.LimitLimitGtc
+	limit, _ := decimal.NewFromString(config.LimitPrice)
+	size, _ := decimal.NewFromString(config.BaseSize)
+
+	if p.price.GreaterThan(limit) {
+		return
+	}
+
+	quote := s.account(p.quote)
+	quote.hold = quote.hold.Sub(o.hold)
+	quote.available = quote.available.Add(o.hold)
+	o.hold = decimal.Zero
+
+	rate := s.Fee
+	if config.PostOnly {
+		rate = s.makerFee()
+	}
+
+	fee := size.Mul(p.price).Mul(decimal.NewFromFloat(rate))
+	quote.available = quote.available.Sub(size.Mul(p.price)).Sub(fee)
+	s.fill(o, p, size, p.price, fee)
+}
+
+func (s *Server) makerFee() float64 {
+	if s.MakerFee == 0 {
+		return s.Fee
+	}
+
+	return s.MakerFee
+}
+
+// fill completes the order, the quote currency is already paid.
+func (s *Server) fill(o *order, p *product, size decimal.Decimal, price decimal.Decimal, fee decimal.Decimal) {
+	base := s.account(p.base)
+	base.available = base.available.Add(size)
+
+	now := s.Now()
+	value := size.Mul(price)
+
+	o.Status = StatusFilled
+	o.CompletionPercentage = "100"
+	o.FilledSize = size.String()
+	o.FilledValue = value.String()
+	o.AverageFilledPrice = price.String()
+	o.Fee = fee.String()
+	o.TotalFees = fee.String()
+	o.TotalValueAfterFees = value.Add(fee).String()
+	o.NumberOfFills = "1"
+	o.LastFillTime = now.Format(time.RFC3339Nano)
+	o.Settled = true
+
+	s.fills = append(s.fills, coinbasev3.Fill{
+		EntryId:           uuid.NewString(),
+		TradeId:           uuid.NewString(),
+		OrderId:           o.OrderId,
+		TradeTime:         now,
+		TradeType:         "FILL",
+		Price:             price.String(),
+		Size:              size.String(),
+		Commission:        fee.String(),
+		ProductId:         p.id,
+		SequenceTimestamp: now,
+		Side:              o.Side,
+	})
+}
+
+func (s *Server) cancelOrders(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		OrderIds []string `json:"order_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+
+	results := coinbasev3.CancelOrderResults{}
+	for _, id := range req.OrderIds {
+		result := coinbasev3.CancelOrderResult{OrderId: id, FailureReason: "UNKNOWN_CANCEL_ORDER"}
+
+		if o := s.order(id); o != nil {
+			if o.Status == StatusOpen {
+				quote := s.account(s.products[o.ProductId].quote)
+				quote.hold = quote.hold.Sub(o.hold)
+				quote.available = quote.available.Add(o.hold)
+				o.hold = decimal.Zero
+				o.Status = StatusCancelled
+
+				result.Success = true
+				result.FailureReason = ""
+			} else {
+				result.FailureReason = "INVALID_CANCEL_REQUEST"
+			}
+		}
+
+		results = append(results, result)
+	}
+
+	writeJSON(w, http.StatusOK, coinbasev3.CancelOrdersData{Results: results})
+}
+
+func (s *Server) editOrder(w http.ResponseWriter, r *http.Request) {
+	var req coinbasev3.EditOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+
+	o := s.order(req.OrderId)
+	if o == nil || o.Status != StatusOpen || o.OrderConfiguration.LimitLimitGtc == nil {
+		writeJSON(w, http.StatusOK, coinbasev3.EditOrderData{Errors: coinbasev3.EditOrderErrors{EditFailureReason: "ORDER_NOT_FOUND"}})
+		return
+	}
+
+	size, err := decimal.NewFromString(req.Size)
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	limit, err := decimal.NewFromString(req.Price)
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+
+	p := s.products[o.ProductId]
+	quote := s.account(p.quote)
+	hold := size.Mul(limit).Mul(decimal.NewFromFloat(1 + s.Fee))
+
+	if quote.available.Add(o.hold).LessThan(hold) {
+		writeJSON(w, http.StatusOK, coinbasev3.EditOrderData{Errors: coinbasev3.EditOrderErrors{EditFailureReason: "INSUFFICIENT_FUND"}})
+		return
+	}
+
+	quote.available = quote.available.Add(o.hold).Sub(hold)
+	quote.hold = quote.hold.Sub(o.hold).Add(hold)
+	o.hold = hold
+
+	config := *o.OrderConfiguration.LimitLimitGtc
+	config.BaseSize = size.String()
+	config.LimitPrice = limit.String()
+	o.OrderConfiguration.LimitLimitGtc = &config
+	o.EditHistory = append(o.EditHistory, coinbasev3.EditHistory{
+		Price:                  req.Price,
+		Size:                   req.Size,
+		ReplaceAcceptTimestamp: s.Now().Format(time.RFC3339Nano),
+	})
+
+	s.matchLimit(o, p)
+
+	writeJSON(w, http.StatusOK, coinbasev3.EditOrderData{Success: true})
+}
+
+func (s *Server) listOrders(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	statuses := query["order_status"]
+
+	var start time.Time
+	if query.Get("start_date") != "" {
+		var err error
+		// the client doesn't escape the date, so a + of the time zone arrives as a space
+		if start, err = time.Parse(time.RFC3339Nano, strings.ReplaceAll(query.Get("start_date"), " ", "+")); err != nil {
+			badRequest(w, err.Error())
+			return
+		}
+	}
+
+	orders := []coinbasev3.Order{}
+	for _, o := range s.orders {
+		if productId := query.Get("product_id"); productId != "" && o.ProductId != productId {
+			continue
+		}
+		if len(statuses) > 0 && !contains(statuses, o.Status) {
+			continue
+		}
+		if o.CreatedTime.Before(start) {
+			continue
+		}
+		orders = append(orders, o.Order)
+	}
+
+	// newest first like the real api
+	sort.SliceStable(orders, func(i, j int) bool {
+		return orders[i].CreatedTime.After(orders[j].CreatedTime)
+	})
+
+	writeJSON(w, http.StatusOK, coinbasev3.ListOrdersData{Orders: orders})
+}
+
+func (s *Server) listFills(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	fills := coinbasev3.Fills{}
+	for _, f := range s.fills {
+		if orderId := query.Get("order_id"); orderId != "" && f.OrderId != orderId {
+			continue
+		}
+		if productId := query.Get("product_id"); productId != "" && f.ProductId != productId {
+			continue
+		}
+		fills = append(fills, f)
+	}
+
+	writeJSON(w, http.StatusOK, coinbasev3.ListFillsData{Fills: fills})
+}
+
+func (s *Server) getOrder(w http.ResponseWriter, r *http.Request, id string) {
+	o := s.order(id)
+	if o == nil {
+		notFound(w, r)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, coinbasev3.GetOrderData{Order: o.Order})
+}
+
+func (s *Server) createDeposit(w http.ResponseWriter, r *http.Request, accountId string) {
+	var params coinbase.DepositParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+
+	var a *account
+	for _, candidate := range s.accounts {
+		if candidate.uuid == accountId {
+			a = candidate
+		}
+	}
+	if a == nil || a.currency != params.Currency {
+		notFound(w, r)
+		return
+	}
+
+	method := false
+	for _, m := range s.paymentMethods {
+		if m.ID == params.PaymentMethodID {
+			method = true
+		}
+	}
+	if !method {
+		badRequest(w, fmt.Sprintf("payment method %s not found", params.PaymentMethodID))
+		return
+	}
+
+	now := s.Now()
+	d := &Deposit{
+		Id:        uuid.NewString(),
+		AccountId: accountId,
+		Currency:  params.Currency,
+		Amount:    params.Amount,
+		MethodId:  params.PaymentMethodID,
+		CreatedAt: now,
+		PayoutAt:  now,
+	}
+	s.deposits = append(s.deposits, d)
+
+	if !s.HoldDeposits {
+		s.settle(d)
+	}
+
+	writeJSON(w, http.StatusCreated, coinbase.DepositResponse{Data: d.toDeposit()})
+}
+
+func (s *Server) listDeposits(w http.ResponseWriter, r *http.Request, accountId string) {
+	query := r.URL.Query()
+
+	limit := 25
+	if query.Get("limit") != "" {
+		var err error
+		if limit, err = strconv.Atoi(query.Get("limit")); err != nil {
+			badRequest(w, err.Error())
+			return
+		}
+	}
+	if s.DepositsPageSize > 0 && s.DepositsPageSize < limit {
+		limit = s.DepositsPageSize
+	}
+
+	deposits := []coinbase.Deposit{}
+	after := query.Get("starting_after")
+	for _, d := range s.deposits {
+		if d.AccountId != accountId {
+			continue
+		}
+		if after != "" {
+			if d.Id == after {
+				after = ""
+			}
+			continue
+		}
+		deposits = append(deposits, d.toDeposit())
+	}
+
+	response := coinbase.ListDeposits{Data: deposits}
+	if len(deposits) > limit {
+		response.Data = deposits[:limit]
+		response.Pagination.NextStartingAfter = deposits[limit-1].ID
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) settle(d *Deposit) {
+	a := s.account(d.Currency)
+	a.available = a.available.Add(decimal.NewFromFloat(d.Amount))
+	d.Settled = true
+}
+
+// account returns the currency's account creating an empty one when missing.
+func (s *Server) account(currency string) *account {
+	for _, a := range s.accounts {
+		if a.currency == currency {
+			return a
+		}
+	}
+
+	a := &account{uuid: uuid.NewString(), currency: currency}
+	s.accounts = append(s.accounts, a)
+
+	return a
+}
+
+func (s *Server) order(id string) *order {
+	for _, o := range s.orders {
+		if o.OrderId == id {
+			return o
+		}
+	}
+
+	return nil
+}
+
+func (a *account) toAccount() coinbasev3.Account {
+	return coinbasev3.Account{
+		Uuid:     a.uuid,
+		Name:     a.currency + " Wallet",
+		Currency: a.currency,
+		AvailableBalance: coinbasev3.AccountAvailableBalance{
+			Value:    a.available.String(),
+			Currency: a.currency,
+		},
+		Hold: coinbasev3.AccountHold{
+			Value:    a.hold.String(),
+			Currency: a.currency,
+		},
+		Active: true,
+		Ready:  true,
+	}
+}
+
+func (d *Deposit) toDeposit() coinbase.Deposit {
+	amount := coinbase.Amount{Amount: d.Amount, Currency: d.Currency}
+
+	status := "created"
+	switch {
+	case d.Settled:
+		status = "completed"
+	case d.Canceled:
+		status = "canceled"
+	}
+
+	return coinbase.Deposit{
+		ID:        d.Id,
+		Status:    status,
+		Amount:    amount,
+		Subtotal:  amount,
+		Fee:       coinbase.Amount{Currency: d.Currency},
+		CreatedAt: d.CreatedAt,
+		UpdatedAt: d.CreatedAt,
+		PayoutAt:  d.PayoutAt,
+	}
+}
+
+func orderCreated(o *order) coinbasev3.CreateOrderData {
+	return coinbasev3.CreateOrderData{
+		Success: true,
+		OrderId: o.OrderId,
+		SuccessResponse: coinbasev3.CreateOrderSuccessResponse{
+			OrderId:       o.OrderId,
+			ProductId:     o.ProductId,
+			Side:          o.Side,
+			ClientOrderId: o.ClientOrderId,
+		},
+		OrderConfiguration: o.OrderConfiguration,
+	}
+}
+
+func orderFailed(reason string, message string) coinbasev3.CreateOrderData {
+	return coinbasev3.CreateOrderData{
+		Success:       false,
+		FailureReason: reason,
+		ErrorResponse: coinbasev3.CreatOrderErrorResponse{
+			Error:   reason,
+			Message: message,
+		},
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func notFound(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusNotFound, coinbasev3.CoinbaseError{
+		Error:   "NOT_FOUND",
+		Message: fmt.Sprintf("%s %s not found", r.Method, r.URL.Path),
+	})
+}
+
+func badRequest(w http.ResponseWriter, message string) {
+	writeJSON(w, http.StatusBadRequest, coinbasev3.CoinbaseError{
+		Error:   "INVALID_ARGUMENT",
+		Message: message,
+	})
+}
@@ -40,7 +40,7 @@ func (c *ApiClient) ListAccounts(limit int, cursor string) (ListAccountsData, er
 		limit = 250
 	}
 
-	u := fmt.Sprintf("https://api.coinbase.com/api/v3/brokerage/accounts?limit=%d&cursor=%s", limit, cursor)
+	u := c.makeV3Url(fmt.Sprintf("/brokerage/accounts?limit=%d&cursor=%s", limit, cursor))
 
 	var data ListAccountsData
 	resp, err := c.client.R().SetSuccessResult(&data).Get(u)
@@ -64,7 +64,7 @@ type ListAccountsData struct {
 
 // GetAccount get a list of information about an account, given an account UUID.
 func (c *ApiClient) GetAccount(uuid string) (Account, error) {
-	u := fmt.Sprintf("https://api.coinbase.com/api/v3/brokerage/accounts/%s", uuid)
+	u := c.makeV3Url(fmt.Sprintf("/brokerage/accounts/%s", uuid))
 
 	var data GetAccountData
 	resp, err := c.client.R().SetSuccessResult(&data).Get(u)
@@ -2,7 +2,7 @@ package coinbasev3
 
 // GetPaymentMethods get payment methods.
 func (c *ApiClient) GetPaymentMethods() (PaymentMethods, error) {
-	u := "https://api.coinbase.com/api/v3/brokerage/payment_methods"
+	u := c.makeV3Url("/brokerage/payment_methods")
 
 	var result PaymentMethods
 	resp, err := c.client.R().
@@ -29,4 +29,6 @@ type PaymentMethod struct {
 	Type     string `json:"type"`
 	Name     string `json:"name"`
 	Currency string `json:"currency"`
+
+	AllowDeposit bool `json:"allow_deposit"`
 }
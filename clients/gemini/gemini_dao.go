@@ -1,6 +1,10 @@
 package gemini
 
-import "time"
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
 
 const (
 	base_URL    = "https://api.gemini.com"
@@ -16,19 +20,21 @@ const (
 	book_URI           = "/v1/book/"
 	trades_URI         = "/v1/trades/"
 	auction_URI        = "/v1/auction/"
+	candles_URI        = "/v2/candles/"
 
 	// authenticated
-	past_trades_URI    = "/v1/mytrades"
-	trade_volume_URI   = "/v1/tradevolume"
-	active_orders_URI  = "/v1/orders"
-	order_status_URI   = "/v1/order/status"
-	new_order_URI      = "/v1/order/new"
-	cancel_order_URI   = "/v1/order/cancel"
-	cancel_all_URI     = "/v1/order/cancel/all"
-	cancel_session_URI = "/v1/order/cancel/session"
-	heartbeat_URI      = "/v1/heartbeat"
-	account_URI        = "/v1/account"
-	transfers_URI      = "/v1/transfers"
+	past_trades_URI     = "/v1/mytrades"
+	trade_volume_URI    = "/v1/tradevolume"
+	notional_volume_URI = "/v1/notionalvolume"
+	active_orders_URI   = "/v1/orders"
+	order_status_URI    = "/v1/order/status"
+	new_order_URI       = "/v1/order/new"
+	cancel_order_URI    = "/v1/order/cancel"
+	cancel_all_URI      = "/v1/order/cancel/all"
+	cancel_session_URI  = "/v1/order/cancel/session"
+	heartbeat_URI       = "/v1/heartbeat"
+	account_URI         = "/v1/account"
+	transfers_URI       = "/v1/transfers"
 
 	// fund mgmt
 	balances_URI            = "/v1/balances"
@@ -122,6 +128,37 @@ type TickerV2 struct {
 	Ask     float64  `json:"ask,string"`
 }
 
+// Candle is [time ms, open, high, low, close, volume] array in the api response
+type Candle struct {
+	Timestampms  int64
+	TimestampmsT time.Time
+	Open         float64
+	High         float64
+	Low          float64
+	Close        float64
+	Volume       float64
+}
+
+func (c *Candle) UnmarshalJSON(data []byte) error {
+	var values []float64
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+
+	if len(values) != 6 {
+		return fmt.Errorf("candle should have 6 values, got %d", len(values))
+	}
+
+	c.Timestampms = int64(values[0])
+	c.Open = values[1]
+	c.High = values[2]
+	c.Low = values[3]
+	c.Close = values[4]
+	c.Volume = values[5]
+
+	return nil
+}
+
 type TradeVolume struct {
 	Symbol            string  `json:"symbol"`
 	BaseCurrency      string  `json:"base_currency"`
@@ -143,6 +180,16 @@ type TradeVolume struct {
 	SellTakerCount    float64 `json:"sell_taker_count"`
 }
 
+type NotionalVolume struct {
+	Date              string  `json:"date"`
+	LastUpdatedMs     int64   `json:"last_updated_ms"`
+	WebMakerFeeBps    float64 `json:"web_maker_fee_bps"`
+	WebTakerFeeBps    float64 `json:"web_taker_fee_bps"`
+	ApiMakerFeeBps    float64 `json:"api_maker_fee_bps"`
+	ApiTakerFeeBps    float64 `json:"api_taker_fee_bps"`
+	Notional30dVolume float64 `json:"notional_30d_volume"`
+}
+
 type CurrentAuction struct {
 	ClosedUntil                  int64     `json:"closed_until_ms,omitempty"`
 	LastAuctionEid               int64     `json:"last_auction_eid,omitempty"`
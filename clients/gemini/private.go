@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/claudiocandio/gemini-api/logger"
@@ -123,6 +124,37 @@ func (api *Api) TradeVolume() ([][]TradeVolume, error) {
 	return tradeVolume, nil
 }
 
+// Notional Volume
+func (api *Api) NotionalVolume() (NotionalVolume, error) {
+
+	url := api.url + notional_volume_URI
+	params := map[string]interface{}{
+		"request": notional_volume_URI,
+		"nonce":   nonce(),
+	}
+
+	logger.Debug("func NotionalVolume",
+		fmt.Sprintf("url:%v", url),
+		fmt.Sprintf("params:%v", params),
+	)
+
+	var notionalVolume NotionalVolume
+
+	body, err := api.request("POST", url, params)
+	if err != nil {
+		return notionalVolume, err
+	}
+	if err := json.Unmarshal(body, &notionalVolume); err != nil {
+		return notionalVolume, err
+	}
+
+	logger.Debug("func NotionalVolume: unmarshal",
+		fmt.Sprintf("notionalVolume:%v", notionalVolume),
+	)
+
+	return notionalVolume, nil
+}
+
 // Active Orders
 func (api *Api) ActiveOrders() ([]Order, error) {
 
@@ -188,6 +220,43 @@ func (api *Api) OrderStatus(orderId string) (Order, error) {
 	return order, nil
 }
 
+// Order Status by client order id, orders placed with it in the last 7 days
+func (api *Api) OrderStatusByClientId(clientOrderId string) ([]Order, error) {
+
+	url := api.url + order_status_URI
+	params := map[string]interface{}{
+		"request":         order_status_URI,
+		"nonce":           nonce(),
+		"client_order_id": clientOrderId,
+	}
+
+	logger.Debug("func OrderStatusByClientId",
+		fmt.Sprintf("url:%v", url),
+		fmt.Sprintf("params:%v", params),
+	)
+
+	var orders []Order
+
+	body, err := api.request("POST", url, params)
+	if err != nil {
+		// no order was placed with the id
+		if strings.Contains(err.Error(), "OrderNotFound") {
+			return orders, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(body, &orders); err != nil {
+		return nil, err
+	}
+
+	logger.Debug("func OrderStatusByClientId: unmarshal",
+		fmt.Sprintf("orders:%v", orders),
+	)
+
+	return orders, nil
+}
+
 // New Order
 func (api *Api) NewOrder(symbol, clientOrderId string, amount, price float64, side string, options []string) (Order, error) {
 
@@ -230,3 +230,34 @@ func (api *Api) AuctionHistory(symbol string, args Args) ([]Auction, error) {
 
 	return auction, nil
 }
+
+// Candles
+// timeFrame: 1m, 5m, 15m, 30m, 1hr, 6hr, 1day
+func (api *Api) Candles(symbol, timeFrame string) ([]Candle, error) {
+
+	url := api.url + candles_URI + symbol + "/" + timeFrame
+
+	logger.Debug("func Candles", fmt.Sprintf("url:%s", url))
+
+	var candles []Candle
+
+	body, err := api.request("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(body, &candles); err != nil {
+		return nil, err
+	}
+
+	// adding TimestampmsT
+	for i, c := range candles {
+		candles[i].TimestampmsT = msToTime(c.Timestampms)
+	}
+
+	logger.Debug("func Candles: unmarshal",
+		fmt.Sprintf("candles:%v", candles),
+	)
+
+	return candles, nil
+}
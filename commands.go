@@ -0,0 +1,403 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/sberserker/dcagdax/exchanges"
+)
+
+const timeFormat = "2006-01-02 15:04"
+
+// title names the plan in the output of the read only commands.
+func (s *gdaxSchedule) title() string {
+	if s.req.plan == "" {
+		return fmt.Sprintf("Plan on %s", s.req.exchange)
+	}
+
+	return fmt.Sprintf("Plan %s on %s", s.req.plan, s.req.exchange)
+}
+
+// status prints every coin's last purchase and next purchase window, the deposits in flight and an unfinished run.
+func (s *gdaxSchedule) status(out io.Writer) error {
+	fmt.Fprintf(out, "%s\n\n", s.title())
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "coin\tevery\tlast purchase\tnext window\t")
+
+	now := s.now()
+	for _, coin := range s.coinNames() {
+		every := s.everyFor(coin)
+
+		last, err := s.lastPurchaseTime(coin, now.Add(-every))
+		if err != nil {
+			return err
+		}
+
+		next, err := s.coinNextPurchaseTime(coin)
+		if err != nil {
+			return err
+		}
+
+		lastText := "-"
+		if last != nil {
+			lastText = last.Local().Format(timeFormat)
+		}
+
+		nextText := next.Local().Format(timeFormat)
+		if !next.After(s.now()) {
+			nextText = "open"
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t\n", coin, every.String(), lastText, nextText)
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	pending, err := s.pendingTransfers()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "\nPending deposits %.2f %s\n", pending, s.req.currency)
+
+	if s.fundingPlan() && s.ledger != nil {
+		if failed := s.ledger.lastDepositFailure(s.req.plan, s.req.exchange, s.req.currency); failed != nil {
+			fmt.Fprintf(out, "Funding plan failed to deposit at %s, %s\n", failed.Time.Local().Format(timeFormat), failed.Reason)
+		}
+	}
+
+	for _, c := range s.caps {
+		subject := "all coins"
+		if c.coin != "" {
+			subject = c.coin
+		}
+
+		fmt.Fprintf(out, "Spent %.2f of %.2f %s on %s this %s\n", s.spent(c, now), c.amount, s.req.currency, subject, c.period)
+	}
+
+	if s.statePath == "" {
+		return nil
+	}
+
+	state, err := loadRunState(s.statePath)
+	if err != nil || state == nil || state.Phase == phaseDone {
+		return err
+	}
+
+	_, err = fmt.Fprintf(out, "Unfinished run since %s, stopped %s\n", state.Started.Local().Format(timeFormat), state.Phase)
+	return err
+}
+
+// balance prints the fiat and the plan's coin balances with their current value.
+func (s *gdaxSchedule) balance(out io.Writer) error {
+	fmt.Fprintf(out, "%s\n\n", s.title())
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "currency\tavailable\tbalance\tvalue %s\t\n", s.req.currency)
+
+	fiat, err := s.exchange.GetFiatAccount(s.req.currency)
+	if err != nil {
+		return err
+	}
+
+	total := fiat.Balance
+	fmt.Fprintf(w, "%s\t%.2f\t%.2f\t%.2f\t\n", s.req.currency, fiat.Available, fiat.Balance, fiat.Balance)
+
+	for _, coin := range s.coinNames() {
+		account, err := s.exchange.GetCryptoAccount(coin)
+		if err != nil {
+			return err
+		}
+
+		ticker, err := s.exchange.GetTicker(s.coins[coin].symbol)
+		if err != nil {
+			return err
+		}
+
+		value := account.Balance * ticker.Price
+		total += value
+		fmt.Fprintf(w, "%s\t%.8f\t%.8f\t%.2f\t\n", coin, account.Available, account.Balance, value)
+	}
+
+	fmt.Fprintf(w, "total\t\t\t%.2f\t\n", total)
+
+	return w.Flush()
+}
+
+// history prints the plan's latest fills from the ledger, most recent first.
+func (s *gdaxSchedule) history(out io.Writer, limit int) error {
+	if s.ledger == nil {
+		return errors.New("history is read from the ledger, which is disabled with --no-ledger")
+	}
+
+	fmt.Fprintf(out, "%s\n\n", s.title())
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "time\tcoin\torder\tspent\tsize\tprice\tfee\t")
+
+	shown := 0
+	orders := map[string]bool{}
+	for i := len(s.ledger.entries) - 1; i >= 0 && (limit <= 0 || shown < limit); i-- {
+		e := s.ledger.entries[i]
+		if e.Kind != ledgerFill || e.Plan != s.req.plan || e.Exchange != s.req.exchange || e.Currency != s.req.currency {
+			continue
+		}
+
+		//the latest fill of an order sums up what it executed, orders which executed nothing are left out
+		if orders[e.OrderId] || e.Size == 0 {
+			continue
+		}
+		orders[e.OrderId] = true
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%.2f\t%.8f\t%.2f\t%.2f\t\n",
+			e.Time.Local().Format(timeFormat), e.Coin, e.OrderId, e.Amount, e.Size, e.Price, e.Fee)
+		shown++
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if shown == 0 {
+		fmt.Fprintln(out, "No orders filled yet")
+	}
+
+	return nil
+}
+
+// preview prints what Sync would do right now, the orders it would place and the deposit it would make.
+// Nothing is placed, deposited or written to the ledger.
+func (s *gdaxSchedule) preview(out io.Writer) error {
+	p := *s
+	p.debug = true
+
+	fmt.Fprintf(out, "%s\n\n", s.title())
+
+	now := p.now()
+	if err := p.checkDates(now); err != nil {
+		_, err = fmt.Fprintln(out, err.Error())
+		return err
+	}
+
+	due := p.coinNames()
+	if !p.req.force {
+		var err error
+		if due, err = p.dueCoins(now); err != nil {
+			return err
+		}
+
+		if len(due) == 0 {
+			next, err := p.nextPurchaseTime()
+			if err != nil {
+				return err
+			}
+
+			_, err = fmt.Fprintf(out, "No purchase window is open, the next one opens at %s\n", next.Local().Format(timeFormat))
+			return err
+		}
+	}
+
+	amounts, err := p.purchaseAmounts(due, now)
+	if err != nil {
+		return err
+	}
+	amounts = p.applyCaps(due, amounts, now)
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "coin\tslice\tamount\ttype\tprice\tsize\t")
+
+	total := decimal.Zero
+	for _, coin := range due {
+		amount, found := amounts[coin]
+		if !found {
+			fmt.Fprintf(w, "%s\t\t\tskip\t\t\t\n", coin)
+			continue
+		}
+		total = total.Add(decimal.NewFromFloat(amount))
+
+		slices := p.sliceAmounts(amount, p.coins[coin].minimum)
+		for i, slice := range slices {
+			orderType, price, size, err := p.previewOrder(coin, slice)
+			if err != nil {
+				fmt.Fprintf(w, "%s\t%d/%d\t%.2f\t%s\t%s\t\t\n", coin, i+1, len(slices), slice, orderType, err.Error())
+				continue
+			}
+
+			fmt.Fprintf(w, "%s\t%d/%d\t%.2f\t%s\t%s\t%s\t\n", coin, i+1, len(slices), slice, orderType, price.StringFixed(2), size.String())
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if len(amounts) == 0 {
+		_, err = fmt.Fprintln(out, "\nStrategy decided not to buy anything this window")
+		return err
+	}
+
+	totalf, _ := total.Float64()
+	needed, err := p.additionalUsdNeeded(totalf)
+	if err != nil {
+		return err
+	}
+
+	if p.fundingPlan() {
+		return p.previewFunding(out, now, needed)
+	}
+
+	switch {
+	case needed <= 0:
+		_, err = fmt.Fprintf(out, "\nSpends %.2f %s of the available balance\n", totalf, p.req.currency)
+		return err
+	case !p.req.autoFund:
+		_, err = fmt.Fprintf(out, "\nNeeds %.2f %s more and autofund is disabled, the purchase would be skipped\n", needed, p.req.currency)
+		return err
+	}
+
+	pending, err := p.pendingTransfers()
+	if err != nil {
+		return err
+	}
+
+	if pending > 0 {
+		_, err = fmt.Fprintf(out, "\nNeeds %.2f %s more, waits for %.2f %s of deposits to settle\n", needed, p.req.currency, pending, p.req.currency)
+		return err
+	}
+
+	_, err = fmt.Fprintf(out, "\nDeposits %.2f %s before buying\n", needed, p.req.currency)
+	return err
+}
+
+// previewFunding prints the funding plan's deposit and whether the window's purchase is paid from the balance, waits or is skipped.
+func (s *gdaxSchedule) previewFunding(out io.Writer, now time.Time, needed float64) error {
+	deposit, reason, err := s.fundingDeposit(now)
+	if err != nil {
+		return err
+	}
+
+	if deposit > 0 {
+		fmt.Fprintf(out, "\nFunding plan deposits %.2f %s\n", deposit, s.req.currency)
+	} else {
+		fmt.Fprintf(out, "\nFunding plan does not deposit, %s\n", reason)
+	}
+
+	if needed <= 0 {
+		_, err = fmt.Fprintln(out, "Purchase is paid from the available balance")
+		return err
+	}
+
+	pending, err := s.pendingTransfers()
+	if err != nil {
+		return err
+	}
+
+	if pending+deposit >= needed {
+		_, err = fmt.Fprintf(out, "Needs %.2f %s more, waits for the funding plan's deposits to settle\n", needed, s.req.currency)
+		return err
+	}
+
+	_, err = fmt.Fprintf(out, "Needs %.2f %s more, the purchase would be skipped until the funding plan's next deposit\n", needed, s.req.currency)
+	return err
+}
+
+// previewOrder prices the order placeOrder would place from the current order book.
+// Market orders show the average price the asks would fill at.
+func (s *gdaxSchedule) previewOrder(coin string, amount float64) (string, decimal.Decimal, decimal.Decimal, error) {
+	productId := s.coins[coin].symbol
+	fiatAmount := decimal.NewFromFloat(amount)
+
+	book, err := s.exchange.GetOrderBook(productId)
+	if err != nil {
+		return "", decimal.Zero, decimal.Zero, err
+	}
+
+	switch s.req.orderType {
+	case exchanges.Maker:
+		if len(book.Bids) == 0 {
+			return "maker", decimal.Zero, decimal.Zero, errors.New("order book is empty")
+		}
+
+		price, size := s.calcMakerOrder(decimal.NewFromFloat(book.Bids[0].Price), fiatAmount)
+		return "maker", price, size, nil
+	case exchanges.Limit:
+		if s.req.pricing == pricingBook {
+			calc, err := s.bookLimitOrder(productId, amount)
+			if err != nil {
+				return "limit", decimal.Zero, decimal.Zero, err
+			}
+
+			price, size := calc(decimal.Zero, fiatAmount)
+			return "limit", price, size, nil
+		}
+
+		if len(book.Asks) == 0 {
+			return "limit", decimal.Zero, decimal.Zero, errors.New("order book is empty")
+		}
+
+		price, size := s.calcLimitOrder(decimal.NewFromFloat(book.Asks[0].Price), fiatAmount)
+		return "limit", price, size, nil
+	default:
+		_, average, err := book.BuyPrice(amount)
+		if err != nil {
+			return "market", decimal.Zero, decimal.Zero, err
+		}
+
+		//amount less the fee at the average price
+		price := decimal.NewFromFloat(average)
+		size := decimal.NewFromFloat((100 - s.takerFee()) / 100).Mul(fiatAmount).Div(price).Truncate(8)
+		return "market", price, size, nil
+	}
+}
+
+// printPaymentMethods lists the payment methods of the exchange account and marks the one deposits in the currency use.
+func printPaymentMethods(out io.Writer, exchange exchanges.Exchange, currency string, wanted string) error {
+	methods, err := exchange.GetPaymentMethods()
+	if err != nil {
+		return err
+	}
+
+	if len(methods) == 0 {
+		_, err = fmt.Fprintln(out, "No payment methods linked to this account")
+		return err
+	}
+
+	selected, selectErr := exchanges.SelectPaymentMethod(methods, currency, wanted)
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "\tid\tname\ttype\tcurrency\tdeposits\t")
+
+	for _, m := range methods {
+		mark := ""
+		if selected != nil && selected.Id == m.Id {
+			mark = "*"
+		}
+
+		deposits := "no"
+		if m.Deposit {
+			deposits = "yes"
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t\n", mark, m.Id, m.Name, m.Type, m.Currency, deposits)
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if selectErr != nil {
+		_, err = fmt.Fprintf(out, "\n%s\n", selectErr.Error())
+		return err
+	}
+
+	_, err = fmt.Fprintf(out, "\nDeposits in %s use %s\n", currency, selected.Name)
+	return err
+}
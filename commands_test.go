@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sberserker/dcagdax/exchanges"
+	"github.com/sberserker/dcagdax/mocks"
+)
+
+func TestPreview(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockExchange(ctrl)
+
+	l, _ := openLedger("")
+
+	s := gdaxSchedule{}
+	s.logger = loggerStub(t).Sugar()
+	s.req = syncRequest{every: 24 * time.Hour, exchange: "coinbase", currency: "USD", orderType: exchanges.Limit, orderSpread: 1, fee: 0.5, autoFund: true}
+	s.coins = map[string]orderDetails{"BTC": {symbol: "BTC-USD", amount: 50, minimum: 1}}
+	s.exchange = m
+	s.ledger = l
+
+	t.Run("when the window is open", func(t *testing.T) {
+		book := &exchanges.OrderBook{
+			Bids: []exchanges.BookLevel{{Price: 99, Size: 1}},
+			Asks: []exchanges.BookLevel{{Price: 100, Size: 1}},
+		}
+
+		m.EXPECT().GetOrderBook("BTC-USD").Return(book, nil)
+		m.EXPECT().GetFiatAccount("USD").Return(&exchanges.Account{Available: 20, Balance: 20}, nil)
+		m.EXPECT().GetPendingTransfers("USD").Return(nil, nil)
+
+		var out bytes.Buffer
+		err := s.preview(&out)
+
+		assert.Nil(t, err)
+		assert.Contains(t, out.String(), "101.00")
+		assert.Contains(t, out.String(), "0.49257425")
+		assert.Contains(t, out.String(), "Deposits 30.00 USD before buying")
+		assert.Empty(t, l.entries)
+	})
+
+	t.Run("when the window is not open", func(t *testing.T) {
+		last := time.Now().Add(-12 * time.Hour)
+		preview := s
+		preview.ledger = &ledger{entries: []ledgerEntry{{Time: last, Kind: ledgerOrder, Exchange: "coinbase", Currency: "USD", Coin: "BTC"}}}
+
+		var out bytes.Buffer
+		err := preview.preview(&out)
+
+		assert.Nil(t, err)
+		assert.Contains(t, out.String(), "No purchase window is open, the next one opens at "+last.Add(24*time.Hour).Local().Format(timeFormat))
+	})
+}
+
+func TestStatusAndBalance(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockExchange(ctrl)
+
+	last := time.Now().Add(-12 * time.Hour)
+
+	s := gdaxSchedule{}
+	s.logger = loggerStub(t).Sugar()
+	s.req = syncRequest{every: 24 * time.Hour, exchange: "coinbase", currency: "USD"}
+	s.coins = map[string]orderDetails{"BTC": {symbol: "BTC-USD", amount: 50}, "ETH": {symbol: "ETH-USD", amount: 50}}
+	s.exchange = m
+	s.ledger = &ledger{entries: []ledgerEntry{{Time: last, Kind: ledgerOrder, Exchange: "coinbase", Currency: "USD", Coin: "BTC"}}}
+
+	t.Run("status", func(t *testing.T) {
+		s.statePath = filepath.Join(t.TempDir(), "coinbase-local-default.json")
+		assert.Nil(t, s.saveState(&runState{Phase: phaseAwaitingFunds, Started: last}))
+		s.caps = []spendCap{{amount: 500, period: capMonth}}
+		s.req.fundEvery = 28 * 24 * time.Hour
+		s.ledger.entries = append(s.ledger.entries, ledgerEntry{Time: last, Kind: ledgerDepositFailed, Exchange: "coinbase", Currency: "USD", Amount: 200, Reason: "service unavailable"})
+		defer func() {
+			s.statePath = ""
+			s.caps = nil
+			s.req.fundEvery = 0
+			s.ledger.entries = s.ledger.entries[:1]
+		}()
+
+		m.EXPECT().GetPendingTransfers("USD").Return([]exchanges.PendingTransfer{{Amount: 25}}, nil)
+
+		var out bytes.Buffer
+		err := s.status(&out)
+
+		assert.Nil(t, err)
+		assert.Contains(t, out.String(), last.Local().Format(timeFormat))
+		assert.Contains(t, out.String(), last.Add(24*time.Hour).Local().Format(timeFormat))
+		assert.Contains(t, out.String(), "open")
+		assert.Contains(t, out.String(), "Pending deposits 25.00 USD")
+		assert.Contains(t, out.String(), "stopped awaiting-funds")
+		assert.Contains(t, out.String(), "Spent 0.00 of 500.00 USD on all coins this month")
+		assert.Contains(t, out.String(), "Funding plan failed to deposit at "+last.Local().Format(timeFormat)+", service unavailable")
+	})
+
+	t.Run("balance", func(t *testing.T) {
+		m.EXPECT().GetFiatAccount("USD").Return(&exchanges.Account{Available: 10, Balance: 15}, nil)
+		m.EXPECT().GetCryptoAccount("BTC").Return(&exchanges.Account{Available: 0.5, Balance: 0.5}, nil)
+		m.EXPECT().GetTicker("BTC-USD").Return(&exchanges.Ticker{Price: 100}, nil)
+		m.EXPECT().GetCryptoAccount("ETH").Return(&exchanges.Account{Available: 2, Balance: 2}, nil)
+		m.EXPECT().GetTicker("ETH-USD").Return(&exchanges.Ticker{Price: 10}, nil)
+
+		var out bytes.Buffer
+		err := s.balance(&out)
+
+		assert.Nil(t, err)
+		assert.Contains(t, out.String(), "50.00")
+		assert.Contains(t, out.String(), "20.00")
+		assert.Contains(t, out.String(), "85.00")
+	})
+}
+
+func TestHistory(t *testing.T) {
+	l, _ := openLedger("")
+	l.record(ledgerEntry{Kind: ledgerFill, Plan: "weekly", Exchange: "coinbase", Currency: "USD", Coin: "BTC", OrderId: "first", Amount: 20, Size: 0.2, Price: 99.5, Fee: 0.1})
+	l.record(ledgerEntry{Kind: ledgerFill, Plan: "weekly", Exchange: "coinbase", Currency: "USD", Coin: "BTC", OrderId: "cancelled", Done: true})
+	l.record(ledgerEntry{Kind: ledgerFill, Plan: "weekly", Exchange: "coinbase", Currency: "USD", Coin: "BTC", OrderId: "first", Amount: 50, Size: 0.5, Price: 99.5, Fee: 0.25})
+	l.record(ledgerEntry{Kind: ledgerFill, Plan: "daily", Exchange: "coinbase", Currency: "USD", Coin: "BTC", OrderId: "other", Amount: 10, Size: 0.1, Price: 99.5, Fee: 0.05})
+	l.record(ledgerEntry{Kind: ledgerOrder, Plan: "weekly", Exchange: "coinbase", Currency: "USD", Coin: "BTC", OrderId: "second", Amount: 50})
+	l.record(ledgerEntry{Kind: ledgerFill, Plan: "weekly", Exchange: "coinbase", Currency: "USD", Coin: "BTC", OrderId: "second", Amount: 50, Size: 0.49, Price: 101.5, Fee: 0.25})
+
+	s := gdaxSchedule{}
+	s.req = syncRequest{plan: "weekly", exchange: "coinbase", currency: "USD"}
+	s.ledger = l
+
+	var out bytes.Buffer
+	assert.Nil(t, s.history(&out, 1))
+	assert.Contains(t, out.String(), "second")
+	assert.Contains(t, out.String(), "101.50")
+	assert.NotContains(t, out.String(), "first")
+
+	out.Reset()
+	assert.Nil(t, s.history(&out, 0))
+	assert.Contains(t, out.String(), "first")
+	assert.NotContains(t, out.String(), "other")
+	//the latest fill of an order is shown once, orders which executed nothing are left out
+	assert.Equal(t, 1, strings.Count(out.String(), "first"))
+	assert.NotContains(t, out.String(), "0.20000000")
+	assert.NotContains(t, out.String(), "cancelled")
+
+	s.ledger = nil
+	assert.NotNil(t, s.history(&out, 0))
+}
+
+func TestPrintPaymentMethods(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockExchange(ctrl)
+
+	methods := []exchanges.PaymentMethod{
+		{Id: "checking", Name: "Checking", Type: "ACH", Currency: "USD", Deposit: true},
+		{Id: "sepa", Name: "Euro bank", Type: "SEPA", Currency: "EUR", Deposit: true},
+	}
+
+	m.EXPECT().GetPaymentMethods().Return(methods, nil).Times(2)
+
+	var out bytes.Buffer
+	assert.Nil(t, printPaymentMethods(&out, m, "EUR", ""))
+	assert.Contains(t, out.String(), "*  sepa")
+	assert.Contains(t, out.String(), "Deposits in EUR use Euro bank")
+
+	out.Reset()
+	assert.Nil(t, printPaymentMethods(&out, m, "GBP", ""))
+	assert.Contains(t, out.String(), "No payment method which deposits GBP found on this account")
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/sberserker/dcagdax/exchanges"
+)
+
+// config is the --config file, a list of named plans evaluated together.
+type config struct {
+	Plans []planConfig `yaml:"plans"`
+	Caps  []string     `yaml:"caps"` // spending caps of all plans like --cap, replace the flags' caps
+}
+
+// planConfig is a named plan of the config file, settings it leaves out keep the value of the flags.
+type planConfig struct {
+	Name     string   `yaml:"name"`
+	Exchange string   `yaml:"exchange"`
+	Coins    []string `yaml:"coins"` // COIN:PERCENTAGE[:EVERY] like --coin
+	Usd      float64  `yaml:"usd"`
+	Every    string   `yaml:"every"` // 1h, 7d, 3w like --every
+	Currency string   `yaml:"currency"`
+	After    string   `yaml:"after"`
+	Until    string   `yaml:"until"`
+	Type     string   `yaml:"type"`
+	Spread   *float64 `yaml:"spread"`
+	Fee      *float64 `yaml:"fee"`
+	AutoFund *bool    `yaml:"autofund"`
+	Strategy string   `yaml:"strategy"`
+	MaxUsd   float64  `yaml:"max-usd"`
+
+	PaymentMethod string `yaml:"payment-method"` // id or name like --payment-method
+
+	FundEvery      string  `yaml:"fund-every"` // 4w, 30d like --fund-every
+	FundUsd        float64 `yaml:"fund-usd"`
+	FundBuffer     *int    `yaml:"fund-buffer"`
+	FundMaxPending *int    `yaml:"fund-max-pending"`
+}
+
+func loadConfig(path string) (*config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	var c config
+	if err := decoder.Decode(&c); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if len(c.Plans) == 0 {
+		return nil, fmt.Errorf("%s: no plans defined", path)
+	}
+
+	names := map[string]bool{}
+	for _, p := range c.Plans {
+		if p.Name == "" {
+			return nil, fmt.Errorf("%s: every plan needs a name", path)
+		}
+		if names[p.Name] {
+			return nil, fmt.Errorf("%s: plan %s is defined twice", path, p.Name)
+		}
+		names[p.Name] = true
+	}
+
+	return &c, nil
+}
+
+// requests returns a sync request for every plan, the flags' request provides the defaults.
+func (c *config) requests(base syncRequest) ([]syncRequest, error) {
+	if len(c.Caps) > 0 {
+		base.caps = c.Caps
+	}
+
+	requests := []syncRequest{}
+	for _, p := range c.Plans {
+		req, err := p.request(base)
+		if err != nil {
+			return nil, fmt.Errorf("plan %s: %w", p.Name, err)
+		}
+		requests = append(requests, req)
+	}
+
+	return requests, nil
+}
+
+func (p planConfig) request(base syncRequest) (syncRequest, error) {
+	req := base
+	req.plan = p.Name
+
+	if p.Exchange != "" {
+		req.exchange = p.Exchange
+	}
+	if len(p.Coins) > 0 {
+		req.coins = p.Coins
+	}
+	if p.Usd != 0 {
+		req.usd = p.Usd
+	}
+	if p.Currency != "" {
+		req.currency = p.Currency
+	}
+	if p.Strategy != "" {
+		req.strategy = p.Strategy
+	}
+	if p.MaxUsd != 0 {
+		req.maxUsd = p.MaxUsd
+	}
+	if p.Spread != nil {
+		req.orderSpread = *p.Spread
+	}
+	if p.Fee != nil {
+		req.fee = *p.Fee
+		req.lookupFees = false
+	}
+	if p.AutoFund != nil {
+		req.autoFund = *p.AutoFund
+	}
+	if p.PaymentMethod != "" {
+		req.paymentMethod = p.PaymentMethod
+	}
+
+	if p.FundUsd != 0 {
+		req.fundUsd = p.FundUsd
+	}
+	if p.FundBuffer != nil {
+		req.fundBuffer = *p.FundBuffer
+	}
+	if p.FundMaxPending != nil {
+		req.fundMaxPending = *p.FundMaxPending
+	}
+	if p.FundEvery != "" {
+		var fundEvery generousDuration
+		if err := fundEvery.Set(p.FundEvery); err != nil {
+			return req, fmt.Errorf("fund-every %s misformatted, e.g. 4w, 30d", p.FundEvery)
+		}
+		req.fundEvery = time.Duration(fundEvery)
+	}
+
+	if p.Every != "" {
+		var every generousDuration
+		if err := every.Set(p.Every); err != nil {
+			return req, fmt.Errorf("every %s misformatted, e.g. 1h, 7d, 3w", p.Every)
+		}
+		req.every = time.Duration(every)
+	}
+	if req.every == 0 {
+		return req, errors.New("every is required")
+	}
+
+	if p.Type != "" {
+		orderType, err := parseOrderType(p.Type)
+		if err != nil {
+			return req, err
+		}
+		req.orderType = orderType
+	}
+
+	for _, d := range []struct {
+		value  string
+		target *time.Time
+	}{{p.After, &req.after}, {p.Until, &req.until}} {
+		if d.value == "" {
+			continue
+		}
+
+		t, err := time.Parse("2006-01-02", d.value)
+		if err != nil {
+			return req, err
+		}
+		*d.target = t
+	}
+
+	return req, nil
+}
+
+func parseOrderType(orderType string) (exchanges.OrderTypeType, error) {
+	switch orderType {
+	case "market":
+		return exchanges.Market, nil
+	case "limit":
+		return exchanges.Limit, nil
+	case "maker":
+		return exchanges.Maker, nil
+	default:
+		return exchanges.Market, fmt.Errorf("unsupported order type %s", orderType)
+	}
+}
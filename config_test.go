@@ -0,0 +1,121 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sberserker/dcagdax/exchanges"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "plans.yaml")
+	assert.Nil(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	base := syncRequest{
+		exchange:    "coinbase",
+		currency:    "USD",
+		orderType:   exchanges.Market,
+		orderSpread: 1,
+		fee:         0.5,
+		lookupFees:  true,
+		every:       24 * time.Hour,
+	}
+
+	t.Run("when plans override the flags", func(t *testing.T) {
+		path := writeConfig(t, `
+caps: ["1000/month"]
+plans:
+  - name: weekly-btc
+    coins: ["BTC:100"]
+    usd: 100
+    every: 1w
+    type: limit
+    spread: 0.5
+    fee: 0.2
+    autofund: true
+    payment-method: Checking
+    until: 2030-01-01
+    fund-every: 4w
+    fund-usd: 400
+    fund-max-pending: 2
+  - name: daily-eth
+    exchange: gemini
+    coins: ["ETH:100"]
+    usd: 10
+    currency: EUR
+`)
+
+		c, err := loadConfig(path)
+		assert.Nil(t, err)
+
+		requests, err := c.requests(base)
+		assert.Nil(t, err)
+		assert.Len(t, requests, 2)
+
+		weekly := requests[0]
+		assert.Equal(t, "weekly-btc", weekly.plan)
+		assert.Equal(t, "coinbase", weekly.exchange)
+		assert.Equal(t, []string{"BTC:100"}, weekly.coins)
+		assert.Equal(t, 100.0, weekly.usd)
+		assert.Equal(t, 7*24*time.Hour, weekly.every)
+		assert.Equal(t, exchanges.Limit, weekly.orderType)
+		assert.Equal(t, 0.5, weekly.orderSpread)
+		assert.Equal(t, 0.2, weekly.fee)
+		assert.False(t, weekly.lookupFees)
+		assert.True(t, weekly.autoFund)
+		assert.Equal(t, "Checking", weekly.paymentMethod)
+		assert.Equal(t, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC), weekly.until)
+		assert.Equal(t, 28*24*time.Hour, weekly.fundEvery)
+		assert.Equal(t, 400.0, weekly.fundUsd)
+		assert.Equal(t, 2, weekly.fundMaxPending)
+		assert.Equal(t, []string{"1000/month"}, weekly.caps)
+
+		daily := requests[1]
+		assert.Equal(t, "daily-eth", daily.plan)
+		assert.Equal(t, "gemini", daily.exchange)
+		assert.Equal(t, 24*time.Hour, daily.every)
+		assert.Equal(t, exchanges.Market, daily.orderType)
+		assert.Equal(t, 1.0, daily.orderSpread)
+		assert.True(t, daily.lookupFees)
+		assert.False(t, daily.autoFund)
+		assert.Equal(t, "EUR", daily.currency)
+		assert.Empty(t, daily.paymentMethod)
+	})
+
+	t.Run("when a plan has no cadence", func(t *testing.T) {
+		c, err := loadConfig(writeConfig(t, "plans:\n  - name: btc\n    coins: [\"BTC:100\"]\n"))
+		assert.Nil(t, err)
+
+		_, err = c.requests(syncRequest{})
+		assert.EqualError(t, err, "plan btc: every is required")
+	})
+
+	t.Run("when a plan has a bad setting", func(t *testing.T) {
+		for _, plan := range []string{"every: 2m", "type: stop", "after: tomorrow", "fund-every: monthly"} {
+			c, err := loadConfig(writeConfig(t, "plans:\n  - name: btc\n    "+plan+"\n"))
+			assert.Nil(t, err)
+
+			_, err = c.requests(base)
+			assert.NotNil(t, err, plan)
+		}
+	})
+
+	t.Run("when the file is invalid", func(t *testing.T) {
+		for _, content := range []string{
+			"plans: []\n",
+			"plans:\n  - usd: 10\n",
+			"plans:\n  - name: btc\n  - name: btc\n",
+			"plans:\n  - name: btc\n    amount: 10\n",
+		} {
+			_, err := loadConfig(writeConfig(t, content))
+			assert.NotNil(t, err, content)
+		}
+	})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// runDaemon keeps syncing the schedules until the context is cancelled or the until dates of all have passed.
+// Between runs it sleeps until the next purchase window of any schedule opens.
+// A run in progress stops at its next wait once the context is cancelled and a later run continues it.
+func runDaemon(ctx context.Context, schedules []*gdaxSchedule, retry time.Duration) error {
+	for _, s := range schedules {
+		s.ctx = ctx
+		s.fundRetry = retry
+		s.logger.Infow(
+			"Starting daemon",
+			"every", s.req.every.String(),
+			"retry", retry.String(),
+		)
+	}
+
+	for {
+		active := 0
+		wait := retry
+
+		for _, s := range schedules {
+			if !s.req.until.IsZero() && time.Now().After(s.req.until) {
+				continue
+			}
+			active++
+
+			if planWait := s.daemonRun(retry); planWait < wait {
+				wait = planWait
+			}
+		}
+
+		if active == 0 {
+			schedules[0].logger.Infow("Deadline has passed, stopping daemon")
+			return nil
+		}
+
+		schedules[0].logger.Infow(
+			"Next run scheduled",
+			"at", time.Now().Add(wait).Local(),
+			"in", wait.Round(time.Second).String(),
+		)
+
+		select {
+		case <-ctx.Done():
+			schedules[0].logger.Infow("Received shutdown signal, stopping daemon")
+			return nil
+		case <-time.After(wait):
+		}
+	}
+}
+
+// daemonRun syncs the schedule when its purchase window is open or the funding plan is due to deposit
+// and returns how long it can wait for the next of them.
+func (s *gdaxSchedule) daemonRun(retry time.Duration) time.Duration {
+	next, err := s.nextPurchaseTime()
+	if err != nil {
+		s.logger.Warn(err.Error())
+		return retry
+	}
+
+	if s.fundingPlan() {
+		if funding := s.nextFunding(s.now()); funding.Before(next) {
+			next = funding
+		}
+	}
+
+	if untilNext := time.Until(next); untilNext > 0 {
+		return untilNext
+	}
+
+	//no new run starts once the daemon is stopping
+	if s.runContext().Err() != nil {
+		return retry
+	}
+
+	if err := s.Sync(); err != nil {
+		s.logger.Warn(err.Error())
+	}
+
+	return retry
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/sberserker/dcagdax/exchanges"
+	"github.com/sberserker/dcagdax/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunDaemon(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockExchange(ctrl)
+
+	s := gdaxSchedule{}
+	s.logger = loggerStub(t).Sugar()
+	s.req = syncRequest{every: 24 * time.Hour, currency: "USD"} // setup run every 24 hrs
+	s.coins = map[string]orderDetails{"BTC": {symbol: "btcusd", amount: 50}}
+	s.exchange = m
+
+	t.Run("when deadline has passed", func(t *testing.T) {
+		s.req.until = time.Now().AddDate(0, 0, -1)
+
+		err := runDaemon(context.Background(), []*gdaxSchedule{&s}, time.Hour)
+
+		assert.Nil(t, err)
+	})
+
+	t.Run("when stopped while waiting for the window", func(t *testing.T) {
+		s.req.until = time.Time{}
+
+		lastPurchaseTime := time.Now().Add(-12 * time.Hour)
+		m.EXPECT().LastPurchaseTime("BTC", "USD", gomock.Any()).Return(&lastPurchaseTime, nil)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := runDaemon(ctx, []*gdaxSchedule{&s}, time.Hour)
+
+		assert.Nil(t, err)
+	})
+}
+
+func TestSyncStopsWaitingWhenCancelled(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockExchange(ctrl)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s := gdaxSchedule{}
+	s.logger = loggerStub(t).Sugar()
+	s.req = syncRequest{every: 24 * time.Hour, orderType: exchanges.Market, currency: "USD", usd: 100, slices: 2, sliceOver: 10 * time.Hour}
+	s.coins = map[string]orderDetails{"BTC": {symbol: "btcusd", amount: 100, minimum: 1}}
+	s.sleepFunc = s.sleep
+	s.ctx = ctx
+	s.exchange = m
+	s.statePath = filepath.Join(t.TempDir(), "coinbase-local-default.json")
+
+	m.EXPECT().LastPurchaseTime("BTC", "USD", gomock.Any()).Return(nil, nil)
+	m.EXPECT().GetFiatAccount("USD").Return(&exchanges.Account{Available: 100}, nil)
+	m.EXPECT().CreateOrder("btcusd", gomock.Any(), 50.0, exchanges.Market, gomock.Any()).DoAndReturn(
+		func(productId string, id string, amount float64, orderType exchanges.OrderTypeType, calc exchanges.CalcLimitOrder) (*exchanges.Order, error) {
+			//shutdown arrives while the first slice is placed
+			cancel()
+			return &exchanges.Order{OrderID: "1", Status: exchanges.OrderFilled}, nil
+		})
+
+	started := time.Now()
+	err := s.Sync()
+
+	assert.Equal(t, context.Canceled, err)
+	assert.Less(t, time.Since(started), time.Minute)
+
+	//a later run places the rest of the slices
+	state, _ := loadRunState(s.statePath)
+	assert.Equal(t, phaseOrdering, state.Phase)
+	assert.Equal(t, map[string][]string{"BTC": {"1"}}, state.Orders)
+}
@@ -0,0 +1,47 @@
+package exchanges
+
+import (
+	"errors"
+	"fmt"
+)
+
+// OrderBook holds the bids from the highest and the asks from the lowest price.
+type OrderBook struct {
+	Bids []BookLevel
+	Asks []BookLevel
+}
+
+type BookLevel struct {
+	Price float64
+	Size  float64 // in base currency
+}
+
+// Mid returns the price half way between the best bid and the best ask.
+func (b *OrderBook) Mid() (float64, error) {
+	if len(b.Bids) == 0 || len(b.Asks) == 0 {
+		return 0, errors.New("order book is empty")
+	}
+
+	return (b.Bids[0].Price + b.Asks[0].Price) / 2, nil
+}
+
+// BuyPrice walks the asks until they fill the quote amount.
+// It returns the price of the last level needed and the average price of the whole fill.
+func (b *OrderBook) BuyPrice(quoteAmount float64) (last float64, average float64, err error) {
+	remaining := quoteAmount
+	size := 0.0
+
+	for _, level := range b.Asks {
+		last = level.Price
+
+		if level.Price*level.Size >= remaining {
+			size += remaining / level.Price
+			return last, quoteAmount / size, nil
+		}
+
+		remaining -= level.Price * level.Size
+		size += level.Size
+	}
+
+	return 0, 0, fmt.Errorf("order book is too thin, asks fill %.2f of %.2f", quoteAmount-remaining, quoteAmount)
+}
@@ -0,0 +1,35 @@
+package exchanges
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrderBook(t *testing.T) {
+	book := OrderBook{
+		Bids: []BookLevel{{Price: 99, Size: 1}},
+		Asks: []BookLevel{{Price: 101, Size: 0.5}, {Price: 102, Size: 1}, {Price: 110, Size: 1}},
+	}
+
+	mid, err := book.Mid()
+	assert.Nil(t, err)
+	assert.Equal(t, 100.0, mid)
+
+	last, average, err := book.BuyPrice(50)
+	assert.Nil(t, err)
+	assert.Equal(t, 101.0, last)
+	assert.Equal(t, 101.0, average)
+
+	// 50.5 at 101 and 51 at 102
+	last, average, err = book.BuyPrice(101.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 102.0, last)
+	assert.Equal(t, 101.5, average)
+
+	_, _, err = book.BuyPrice(300)
+	assert.Equal(t, "order book is too thin, asks fill 262.50 of 300.00", err.Error())
+
+	_, err = (&OrderBook{}).Mid()
+	assert.Equal(t, "order book is empty", err.Error())
+}
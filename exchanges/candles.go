@@ -0,0 +1,103 @@
+package exchanges
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var candlesHeader = []string{"time", "open", "high", "low", "close", "volume"}
+
+// ReadCandlesCSV reads daily candles in time,open,high,low,close,volume format, the header line is optional.
+// Time is either a date like 2020-01-31, RFC3339 or unix seconds.
+func ReadCandlesCSV(r io.Reader) ([]Candle, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = len(candlesHeader)
+	reader.TrimLeadingSpace = true
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	candles := []Candle{}
+
+	for i, record := range records {
+		if i == 0 && record[0] == candlesHeader[0] {
+			continue
+		}
+
+		t, err := parseCandleTime(record[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+
+		values := make([]float64, len(record)-1)
+		for j, v := range record[1:] {
+			if values[j], err = strconv.ParseFloat(v, 64); err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+		}
+
+		candles = append(candles, Candle{
+			Time:   t,
+			Open:   values[0],
+			High:   values[1],
+			Low:    values[2],
+			Close:  values[3],
+			Volume: values[4],
+		})
+	}
+
+	sort.Slice(candles, func(i, j int) bool {
+		return candles[i].Time.Before(candles[j].Time)
+	})
+
+	return candles, nil
+}
+
+// WriteCandlesCSV writes candles in the format ReadCandlesCSV reads.
+func WriteCandlesCSV(w io.Writer, candles []Candle) error {
+	writer := csv.NewWriter(w)
+
+	if err := writer.Write(candlesHeader); err != nil {
+		return err
+	}
+
+	for _, c := range candles {
+		record := []string{
+			c.Time.UTC().Format(time.RFC3339),
+			strconv.FormatFloat(c.Open, 'f', -1, 64),
+			strconv.FormatFloat(c.High, 'f', -1, 64),
+			strconv.FormatFloat(c.Low, 'f', -1, 64),
+			strconv.FormatFloat(c.Close, 'f', -1, 64),
+			strconv.FormatFloat(c.Volume, 'f', -1, 64),
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func parseCandleTime(value string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unsupported candle time %s", value)
+	}
+
+	return time.Unix(seconds, 0).UTC(), nil
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"sort"
 	"strconv"
 	"time"
 
@@ -13,10 +14,14 @@ import (
 	"github.com/shopspring/decimal"
 )
 
+// DefaultStuckAfter is how long a deposit may stay pending before it is considered stuck.
+const DefaultStuckAfter = 24 * time.Hour
+
 type CoinbaseV3 struct {
-	client3  *coinbasev3.ApiClient
-	client   *exchange.Client
-	accounts map[string]*account
+	client3    *coinbasev3.ApiClient
+	client     *exchange.Client
+	accounts   map[string]*account
+	stuckAfter time.Duration
 }
 
 type account struct {
@@ -43,42 +48,75 @@ func NewCoinbaseV3() (*CoinbaseV3, error) {
 	client3 := coinbasev3.NewApiClient(key, secret)
 
 	return &CoinbaseV3{
-		accounts: map[string]*account{},
-		client3:  client3,
-		client:   client,
+		accounts:   map[string]*account{},
+		client3:    client3,
+		client:     client,
+		stuckAfter: DefaultStuckAfter,
 	}, nil
 }
 
-func (c *CoinbaseV3) CreateOrder(productId string, amount float64, orderType OrderTypeType, limitOrderFunc CalcLimitOrder) (*Order, error) {
+// SetBaseUrlV3 points the Advanced Trade api client at another server, e.g. a fake one in tests.
+func (c *CoinbaseV3) SetBaseUrlV3(url string) {
+	c.client3.SetBaseUrlV3(url)
+}
+
+// SetBaseUrlV2 points the v2 client making deposits at another server.
+func (c *CoinbaseV3) SetBaseUrlV2(url string) {
+	c.client.BaseURL = url
+}
+
+// SetStuckAfter sets how long a deposit may stay pending before GetPendingTransfers reports it stuck.
+func (c *CoinbaseV3) SetStuckAfter(d time.Duration) {
+	c.stuckAfter = d
+}
+
+func (c *CoinbaseV3) CreateOrder(productId string, clientOrderId string, amount float64, orderType OrderTypeType, limitOrderFunc CalcLimitOrder) (*Order, error) {
+	if clientOrderId == "" {
+		clientOrderId = uuid.NewString()
+	}
+
+	existing, err := c.findOrder(productId, clientOrderId)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return existing, nil
+	}
 
 	var orderReq coinbasev3.CreateOrderRequest
 
-	if orderType == Limit {
+	if orderType == Limit || orderType == Maker {
 		trades, err := c.client3.GetMarketTrades(productId, 10)
 		if err != nil {
 			return nil, err
 		}
 
-		bestAsk, err := decimal.NewFromString(trades.BestAsk)
+		best := trades.BestAsk
+		if orderType == Maker {
+			best = trades.BestBid
+		}
+
+		bestPrice, err := decimal.NewFromString(best)
 		if err != nil {
 			return nil, err
 		}
-		orderPrice, orderSize := limitOrderFunc(bestAsk, decimal.NewFromFloat(amount))
+		orderPrice, orderSize := limitOrderFunc(bestPrice, decimal.NewFromFloat(amount))
 
 		orderReq = coinbasev3.CreateOrderRequest{
-			ClientOrderID: uuid.NewString(),
+			ClientOrderID: clientOrderId,
 			ProductID:     productId,
 			Side:          coinbasev3.OrderSideBuy,
 			OrderConfiguration: coinbasev3.OrderConfiguration{
 				LimitLimitGtc: &coinbasev3.LimitLimitGtc{
-					BaseSize:   orderPrice.String(),
-					LimitPrice: orderSize.String(),
+					BaseSize:   orderSize.String(),
+					LimitPrice: orderPrice.String(),
+					PostOnly:   orderType == Maker,
 				},
 			},
 		}
 	} else {
 		orderReq = coinbasev3.CreateOrderRequest{
-			ClientOrderID: uuid.NewString(),
+			ClientOrderID: clientOrderId,
 			ProductID:     productId,
 			Side:          coinbasev3.OrderSideBuy,
 			OrderConfiguration: coinbasev3.OrderConfiguration{
@@ -96,15 +134,130 @@ func (c *CoinbaseV3) CreateOrder(productId string, amount float64, orderType Ord
 	}
 
 	if !order.Success {
+		if order.FailureReason == "INVALID_LIMIT_PRICE_POST_ONLY" {
+			return nil, fmt.Errorf("order failed with %s: %w", order.FailureReason, ErrPostOnlyRejected)
+		}
 		return nil, errors.New(fmt.Sprintf("order failed with %s, %s", order.FailureReason, order.ErrorResponse.Message))
 	}
 
 	return &Order{
 		Symbol:  order.SuccessResponse.ProductId,
 		OrderID: order.OrderId,
+		Status:  OrderOpen,
 	}, nil
 }
 
+// findOrder looks for the client order id among the product's latest orders, nil when it was never placed.
+func (c *CoinbaseV3) findOrder(productId string, clientOrderId string) (*Order, error) {
+	orders, err := c.client3.GetListOrders(coinbasev3.ListOrdersQuery{ProductId: productId, Limit: 100})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, order := range orders.Orders {
+		if order.ClientOrderId == clientOrderId {
+			return convertCoinbaseOrder(order)
+		}
+	}
+
+	return nil, nil
+}
+
+func (c *CoinbaseV3) GetOrder(productId string, orderId string) (*Order, error) {
+	order, err := c.client3.GetOrder(orderId)
+	if err != nil {
+		return nil, err
+	}
+
+	return convertCoinbaseOrder(order)
+}
+
+func convertCoinbaseOrder(order coinbasev3.Order) (*Order, error) {
+	var err error
+	result := &Order{
+		Symbol:  order.ProductId,
+		OrderID: order.OrderId,
+	}
+
+	switch order.Status {
+	case "FILLED":
+		result.Status = OrderFilled
+	case "CANCELLED", "EXPIRED":
+		result.Status = OrderCancelled
+	case "FAILED":
+		result.Status = OrderFailed
+	default:
+		result.Status = OrderOpen
+	}
+
+	//fields are empty until the first fill
+	for _, field := range []struct {
+		value  string
+		target *float64
+	}{
+		{order.FilledSize, &result.FilledSize},
+		{order.AverageFilledPrice, &result.AveragePrice},
+		{order.TotalFees, &result.Fee},
+	} {
+		if field.value == "" {
+			continue
+		}
+
+		if *field.target, err = strconv.ParseFloat(field.value, 64); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+func (c *CoinbaseV3) EditOrder(productId string, orderId string, price decimal.Decimal, size decimal.Decimal) (*Order, error) {
+	result, err := c.client3.EditOrder(coinbasev3.EditOrderRequest{
+		OrderId: orderId,
+		Price:   price.String(),
+		Size:    size.String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !result.Success {
+		return nil, fmt.Errorf("edit failed with %s%s", result.Errors.EditFailureReason, result.Errors.PreviewFailureReason)
+	}
+
+	return c.GetOrder(productId, orderId)
+}
+
+func (c *CoinbaseV3) CancelOrder(productId string, orderId string) (*Order, error) {
+	result, err := c.client3.CancelOrders([]string{orderId})
+	if err != nil {
+		return nil, err
+	}
+
+	order, err := c.GetOrder(productId, orderId)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result.Results) == 0 || !result.Results[0].Success {
+		//it filled or got cancelled in the meantime
+		if order.Done() {
+			return order, nil
+		}
+
+		reason := ""
+		if len(result.Results) > 0 {
+			reason = result.Results[0].FailureReason
+		}
+		return nil, fmt.Errorf("cancel failed with %s", reason)
+	}
+
+	//cancellation is asynchronous, the order may still show as open for a moment
+	order.Status = OrderCancelled
+
+	return order, nil
+}
+
 func (c *CoinbaseV3) GetTickerSymbol(baseCurrency string, quoteCurrency string) string {
 	return baseCurrency + "-" + quoteCurrency
 }
@@ -123,6 +276,44 @@ func (c *CoinbaseV3) GetTicker(productId string) (*Ticker, error) {
 	return &Ticker{Price: bestAsk}, nil
 }
 
+func (c *CoinbaseV3) GetOrderBook(productId string) (*OrderBook, error) {
+	book, err := c.client3.GetProductBook(productId, 100)
+	if err != nil {
+		return nil, err
+	}
+
+	bids, err := coinbaseBookLevels(book.PriceBook.Bids)
+	if err != nil {
+		return nil, err
+	}
+
+	asks, err := coinbaseBookLevels(book.PriceBook.Asks)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OrderBook{Bids: bids, Asks: asks}, nil
+}
+
+func coinbaseBookLevels(orders []coinbasev3.PriceBookOrder) ([]BookLevel, error) {
+	levels := []BookLevel{}
+	for _, o := range orders {
+		price, err := strconv.ParseFloat(o.Price, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		size, err := strconv.ParseFloat(o.Size, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		levels = append(levels, BookLevel{Price: price, Size: size})
+	}
+
+	return levels, nil
+}
+
 func (c *CoinbaseV3) GetProduct(productId string) (*Product, error) {
 	product, err := c.client3.GetProduct(productId)
 
@@ -142,34 +333,26 @@ func (c *CoinbaseV3) GetProduct(productId string) (*Product, error) {
 	}, nil
 }
 
-func (c *CoinbaseV3) Deposit(currency string, amount float64) (*time.Time, error) {
+func (c *CoinbaseV3) Deposit(currency string, amount float64, paymentMethod string) (*time.Time, error) {
 	account, err := c.accountFor(currency) //taking the first coins a marker, make sure to put your main coin first
 	if err != nil {
 		return nil, err
 	}
 
-	paymentMethods, err := c.client3.GetPaymentMethods()
-
+	methods, err := c.GetPaymentMethods()
 	if err != nil {
 		return nil, err
 	}
 
-	var bankAccount *coinbasev3.PaymentMethod = nil
-
-	for i := range paymentMethods.PaymentMethods {
-		if paymentMethods.PaymentMethods[i].Type == "ACH" {
-			bankAccount = &paymentMethods.PaymentMethods[i]
-		}
-	}
-
-	if bankAccount == nil {
-		return nil, errors.New("No ACH bank account found on this account")
+	bankAccount, err := SelectPaymentMethod(methods, currency, paymentMethod)
+	if err != nil {
+		return nil, err
 	}
 
 	depositResponse, err := c.client.Deposit(account.Id, exchange.DepositParams{
 		Amount:          amount,
 		Currency:        currency,
-		PaymentMethodID: bankAccount.ID,
+		PaymentMethodID: bankAccount.Id,
 	})
 
 	if err != nil {
@@ -180,6 +363,26 @@ func (c *CoinbaseV3) Deposit(currency string, amount float64) (*time.Time, error
 	return &payoutAt, nil
 }
 
+func (c *CoinbaseV3) GetPaymentMethods() ([]PaymentMethod, error) {
+	paymentMethods, err := c.client3.GetPaymentMethods()
+	if err != nil {
+		return nil, err
+	}
+
+	methods := []PaymentMethod{}
+	for _, m := range paymentMethods.PaymentMethods {
+		methods = append(methods, PaymentMethod{
+			Id:       m.ID,
+			Name:     m.Name,
+			Type:     m.Type,
+			Currency: m.Currency,
+			Deposit:  m.AllowDeposit,
+		})
+	}
+
+	return methods, nil
+}
+
 func (c *CoinbaseV3) LastPurchaseTime(coin string, currency string, since time.Time) (*time.Time, error) {
 
 	orders, err := c.client3.GetListOrders(coinbasev3.ListOrdersQuery{
@@ -199,43 +402,152 @@ func (c *CoinbaseV3) LastPurchaseTime(coin string, currency string, since time.T
 	return nil, nil
 }
 
+func (c *CoinbaseV3) GetFeeRates() (*FeeRates, error) {
+	summary, err := c.client3.GetTransactionSummary(coinbasev3.TransactionSummaryRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	maker, err := strconv.ParseFloat(summary.FeeTier.MakerFeeRate, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	taker, err := strconv.ParseFloat(summary.FeeTier.TakerFeeRate, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FeeRates{Maker: maker * 100, Taker: taker * 100}, nil
+}
+
 func (c *CoinbaseV3) GetFiatAccount(currency string) (*Account, error) {
+	// balance changes between runs when running as a daemon, don't serve it from the cache
+	delete(c.accounts, currency)
 
 	account, err := c.accountFor(currency)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Account{Available: account.Available}, nil
+	return &Account{Available: account.Available, Balance: account.Available + account.Hold}, nil
+}
+
+func (c *CoinbaseV3) GetCryptoAccount(coin string) (*Account, error) {
+	return c.GetFiatAccount(coin)
 }
 
+// GetPendingTransfers returns the deposits to the currency's account still in flight, pending or stuck.
+// Coinbase sometimes keeps a deposit pending for days and support is unable to resolve it, those are reported stuck.
 func (c *CoinbaseV3) GetPendingTransfers(currency string) ([]PendingTransfer, error) {
+	account, err := c.accountFor(currency)
+	if err != nil {
+		return nil, err
+	}
+
+	deposits, err := c.client.ListDeposits(account.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
 	pendingTransfers := []PendingTransfer{}
-	// // Dang, we don't have enough funds. Let's see if money is on the way.
-	// var transfers []exchange.Transfer
-	// cursor := c.client.ListAccountTransfers(account.Id)
-
-	// for cursor.HasMore {
-	// 	if err := cursor.NextPage(&transfers); err != nil {
-	// 		return pendingTransfers, err
-	// 	}
-
-	// 	for _, t := range transfers {
-	// 		unprocessed := (t.ProcessedAt.Time() == time.Time{})
-	// 		notCanceled := (t.CanceledAt.Time() == time.Time{})
-	// 		//if it's pending for more than 1 day consider it stuck
-	// 		//coinbase sometimes have those issues which support is unable to resolve
-	// 		stuck := t.CreatedAt.Time().Before(time.Now().AddDate(0, 0, -1))
-
-	// 		// This transfer is stil pending, so count it.
-	// 		if unprocessed && notCanceled && !stuck {
-	// 			pendingTransfers = append(pendingTransfers, PendingTransfer{Amount: t.Amount})
-	// 		}
-	// 	}
-	// }
+	for _, d := range deposits {
+		status := classifyDeposit(d, now, c.stuckAfter)
+		if status != TransferPending && status != TransferStuck {
+			continue
+		}
+
+		pendingTransfers = append(pendingTransfers, PendingTransfer{
+			Id:        d.ID,
+			Amount:    d.Amount.Amount,
+			Status:    status,
+			CreatedAt: d.CreatedAt,
+		})
+	}
+
 	return pendingTransfers, nil
 }
 
+// classifyDeposit maps the v2 deposit status, a deposit created longer than stuckAfter ago and not completed is stuck.
+func classifyDeposit(d exchange.Deposit, now time.Time, stuckAfter time.Duration) TransferStatus {
+	switch d.Status {
+	case "completed":
+		return TransferCompleted
+	case "canceled":
+		return TransferCanceled
+	}
+
+	if stuckAfter > 0 && now.Sub(d.CreatedAt) > stuckAfter {
+		return TransferStuck
+	}
+
+	return TransferPending
+}
+
+func (c *CoinbaseV3) GetCandles(productId string, start time.Time, end time.Time) ([]Candle, error) {
+	//api returns up to 350 candles per request
+	const maxDays = 300
+
+	result := []Candle{}
+
+	for from := start; from.Before(end); from = from.AddDate(0, 0, maxDays) {
+		to := from.AddDate(0, 0, maxDays)
+		if to.After(end) {
+			to = end
+		}
+
+		candles, err := c.client3.GetProductCandles(
+			productId,
+			strconv.FormatInt(from.Unix(), 10),
+			strconv.FormatInt(to.Unix(), 10),
+			coinbasev3.GranularityOneDay,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, candle := range candles {
+			converted, err := convertCoinbaseCandle(candle)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, converted)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Time.Before(result[j].Time)
+	})
+
+	return result, nil
+}
+
+func convertCoinbaseCandle(candle coinbasev3.ProductCandles) (Candle, error) {
+	values := make([]float64, 5)
+	for i, v := range []string{candle.Open, candle.High, candle.Low, candle.Close, candle.Volume} {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return Candle{}, err
+		}
+		values[i] = f
+	}
+
+	start, err := strconv.ParseInt(candle.Start, 10, 64)
+	if err != nil {
+		return Candle{}, err
+	}
+
+	return Candle{
+		Time:   time.Unix(start, 0),
+		Open:   values[0],
+		High:   values[1],
+		Low:    values[2],
+		Close:  values[3],
+		Volume: values[4],
+	}, nil
+}
+
 func (c *CoinbaseV3) accountFor(currencyCode string) (*account, error) {
 
 	// cache accounts
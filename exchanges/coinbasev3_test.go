@@ -0,0 +1,209 @@
+package exchanges
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sberserker/dcagdax/clients/coinbasefake"
+	"github.com/sberserker/dcagdax/clients/coinbasev3"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func newFakeCoinbase(t *testing.T) (*CoinbaseV3, *coinbasefake.Server) {
+	t.Setenv("COINBASE_KEY", "key")
+	t.Setenv("COINBASE_SECRET", "secret")
+
+	server := coinbasefake.NewServer()
+	t.Cleanup(server.Close)
+
+	c, err := NewCoinbaseV3()
+	assert.Nil(t, err)
+
+	c.SetBaseUrlV3(server.UrlV3())
+	c.SetBaseUrlV2(server.UrlV2())
+
+	return c, server
+}
+
+func TestCoinbaseV3DepositAndBuy(t *testing.T) {
+	c, server := newFakeCoinbase(t)
+	server.Fee = 0.01
+	server.SetAccount("USD", 10)
+	server.SetProduct("BTC-USD", 20000, 0.0001)
+	server.AddPaymentMethod(coinbasev3.PaymentMethod{ID: "bank", Type: "ACH", Currency: "USD", AllowDeposit: true})
+
+	product, err := c.GetProduct("BTC-USD")
+	assert.Nil(t, err)
+	assert.Equal(t, 0.0001, product.BaseMinSize)
+
+	ticker, err := c.GetTicker("BTC-USD")
+	assert.Nil(t, err)
+	assert.Equal(t, 20000.0, ticker.Price)
+
+	_, err = c.Deposit("USD", 90, "")
+	assert.Nil(t, err)
+
+	usd, err := c.GetFiatAccount("USD")
+	assert.Nil(t, err)
+	assert.Equal(t, 100.0, usd.Available)
+
+	since := time.Now().Add(-time.Hour)
+	last, err := c.LastPurchaseTime("BTC", "USD", since)
+	assert.Nil(t, err)
+	assert.Nil(t, last)
+
+	order, err := c.CreateOrder("BTC-USD", "first-window", 100, Market, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "BTC-USD", order.Symbol)
+
+	// placing the window's order again returns the one placed before
+	again, err := c.CreateOrder("BTC-USD", "first-window", 100, Market, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, order.OrderID, again.OrderID)
+	assert.Equal(t, OrderFilled, again.Status)
+
+	order, err = c.GetOrder("BTC-USD", order.OrderID)
+	assert.Nil(t, err)
+	assert.Equal(t, OrderFilled, order.Status)
+	assert.Equal(t, 0.00495, order.FilledSize)
+	assert.Equal(t, 20000.0, order.AveragePrice)
+	assert.Equal(t, 1.0, order.Fee)
+
+	btc, err := c.GetCryptoAccount("BTC")
+	assert.Nil(t, err)
+	assert.Equal(t, 0.00495, btc.Balance)
+
+	last, err = c.LastPurchaseTime("BTC", "USD", since)
+	assert.Nil(t, err)
+	assert.NotNil(t, last)
+
+	_, err = c.CreateOrder("BTC-USD", "second-window", 100, Market, nil)
+	assert.Equal(t, "order failed with INSUFFICIENT_FUND, Insufficient balance in source account", err.Error())
+}
+
+func TestCoinbaseV3PendingTransfers(t *testing.T) {
+	c, server := newFakeCoinbase(t)
+	server.HoldDeposits = true
+	// every deposit comes on its own page
+	server.DepositsPageSize = 1
+	server.SetAccount("USD", 0)
+	server.AddPaymentMethod(coinbasev3.PaymentMethod{ID: "bank", Type: "ACH", Currency: "USD", AllowDeposit: true})
+
+	now := time.Now()
+	server.Now = func() time.Time { return now.Add(-48 * time.Hour) }
+	_, err := c.Deposit("USD", 10, "")
+	assert.Nil(t, err)
+
+	server.Now = func() time.Time { return now.Add(-time.Hour) }
+	for _, amount := range []float64{20, 30} {
+		_, err = c.Deposit("USD", amount, "")
+		assert.Nil(t, err)
+	}
+	server.CancelDeposit(server.Deposits()[2].Id)
+
+	transfers, err := c.GetPendingTransfers("USD")
+	assert.Nil(t, err)
+	assert.Len(t, transfers, 2)
+	assert.Equal(t, 10.0, transfers[0].Amount)
+	assert.Equal(t, TransferStuck, transfers[0].Status)
+	assert.Equal(t, 20.0, transfers[1].Amount)
+	assert.Equal(t, TransferPending, transfers[1].Status)
+
+	c.SetStuckAfter(0)
+	server.SettleDeposits()
+
+	transfers, err = c.GetPendingTransfers("USD")
+	assert.Nil(t, err)
+	assert.Empty(t, transfers)
+}
+
+func TestCoinbaseV3PaymentMethods(t *testing.T) {
+	c, server := newFakeCoinbase(t)
+	server.SetAccount("USD", 0)
+	server.SetAccount("EUR", 0)
+	server.AddPaymentMethod(coinbasev3.PaymentMethod{ID: "checking", Name: "Checking", Type: "ACH", Currency: "USD", AllowDeposit: true})
+	server.AddPaymentMethod(coinbasev3.PaymentMethod{ID: "savings", Name: "Savings", Type: "ACH", Currency: "USD", AllowDeposit: true})
+	server.AddPaymentMethod(coinbasev3.PaymentMethod{ID: "sepa", Name: "Euro bank", Type: "SEPA", Currency: "EUR", AllowDeposit: true})
+	server.AddPaymentMethod(coinbasev3.PaymentMethod{ID: "card", Name: "Visa", Type: "CARD", Currency: "USD"})
+	server.AddPaymentMethod(coinbasev3.PaymentMethod{ID: "wire", Name: "Euro wire", Type: "WIRE", Currency: "EUR", AllowDeposit: true})
+
+	methods, err := c.GetPaymentMethods()
+	assert.Nil(t, err)
+	assert.Len(t, methods, 5)
+	assert.True(t, methods[2].Deposit)
+	assert.False(t, methods[3].Deposit)
+
+	// the first ACH bank account of several is used
+	_, err = c.Deposit("USD", 10, "")
+	assert.Nil(t, err)
+
+	_, err = c.Deposit("EUR", 10, "")
+	assert.Equal(t, "Several payment methods deposit EUR (Euro bank, Euro wire), choose one with --payment-method", err.Error())
+
+	_, err = c.Deposit("EUR", 10, "sepa")
+	assert.Nil(t, err)
+
+	_, err = c.Deposit("USD", 20, "savings")
+	assert.Nil(t, err)
+
+	_, err = c.Deposit("USD", 30, "visa")
+	assert.Equal(t, "Payment method visa cannot deposit USD", err.Error())
+
+	_, err = c.Deposit("USD", 30, "brokerage")
+	assert.Equal(t, "No payment method brokerage found on this account", err.Error())
+
+	deposits := server.Deposits()
+	assert.Len(t, deposits, 3)
+	assert.Equal(t, "checking", deposits[0].MethodId)
+	assert.Equal(t, "sepa", deposits[1].MethodId)
+	assert.Equal(t, "savings", deposits[2].MethodId)
+}
+
+func TestCoinbaseV3FeeRates(t *testing.T) {
+	c, server := newFakeCoinbase(t)
+	server.Fee = 0.006
+	server.MakerFee = 0.004
+
+	rates, err := c.GetFeeRates()
+
+	assert.Nil(t, err)
+	assert.InDelta(t, 0.4, rates.Maker, 0.000001)
+	assert.InDelta(t, 0.6, rates.Taker, 0.000001)
+}
+
+func TestCoinbaseV3OrderBook(t *testing.T) {
+	c, server := newFakeCoinbase(t)
+	server.SetProduct("BTC-USD", 20000, 0.0001)
+	server.SetBook("BTC-USD",
+		[]coinbasev3.PriceBookOrder{{Price: "19990", Size: "0.5"}},
+		[]coinbasev3.PriceBookOrder{{Price: "20010", Size: "0.1"}, {Price: "20020", Size: "2"}},
+	)
+
+	book, err := c.GetOrderBook("BTC-USD")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []BookLevel{{Price: 19990, Size: 0.5}}, book.Bids)
+	assert.Equal(t, []BookLevel{{Price: 20010, Size: 0.1}, {Price: 20020, Size: 2}}, book.Asks)
+}
+
+func TestCoinbaseV3PostOnlyRejected(t *testing.T) {
+	c, server := newFakeCoinbase(t)
+	server.SetAccount("USD", 10)
+	server.SetProduct("BTC-USD", 20000, 0.0001)
+
+	crossing := func(bid decimal.Decimal, amount decimal.Decimal) (decimal.Decimal, decimal.Decimal) {
+		return decimal.NewFromInt(20000), decimal.NewFromFloat(0.001)
+	}
+
+	_, err := c.CreateOrder("BTC-USD", "maker", 20, Maker, crossing)
+
+	assert.ErrorIs(t, err, ErrPostOnlyRejected)
+
+	// other failures, e.g. a short balance, are not taken for a post-only rejection
+	_, err = c.CreateOrder("BTC-USD", "taker", 20, Limit, crossing)
+
+	assert.NotNil(t, err)
+	assert.False(t, errors.Is(err, ErrPostOnlyRejected))
+}
@@ -3,11 +3,20 @@ package exchanges
 //go:generate mockgen -destination=../mocks/mock_exchange.go -package=mocks github.com/sberserker/dcagdax/exchanges Exchange
 
 import (
+	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/shopspring/decimal"
 )
 
+var ErrEditNotSupported = errors.New("exchange api does not support editing orders")
+
+// ErrPostOnlyRejected is returned for a maker order which the exchange rejects as it would take liquidity.
+var ErrPostOnlyRejected = errors.New("post only order would take liquidity")
+
+// CalcLimitOrder prices a limit order from the best ask, or the best bid for maker orders.
 type CalcLimitOrder func(askPrice decimal.Decimal, fiatAmount decimal.Decimal) (orderPrice decimal.Decimal, orderSize decimal.Decimal)
 
 type Exchange interface {
@@ -17,14 +26,39 @@ type Exchange interface {
 
 	GetProduct(productId string) (*Product, error)
 
-	Deposit(currency string, amount float64) (*time.Time, error)
+	// GetOrderBook returns the product's bids and asks closest to the spread
+	GetOrderBook(productId string) (*OrderBook, error)
+
+	// Deposit transfers the amount in from the payment method with the id or name, empty picks the only one which deposits the currency
+	Deposit(currency string, amount float64, paymentMethod string) (*time.Time, error)
+
+	// GetPaymentMethods returns the bank accounts and other methods linked to the account
+	GetPaymentMethods() ([]PaymentMethod, error)
+
+	// CreateOrder places a buy order, an order placed with the same client order id before is returned instead of placing another one
+	CreateOrder(productId string, clientOrderId string, amount float64, orderType OrderTypeType, limitOrderFunc CalcLimitOrder) (*Order, error)
 
-	CreateOrder(productId string, amount float64, orderType OrderTypeType, limitOrderFunc CalcLimitOrder) (*Order, error)
+	// GetOrder returns the order's current status and what has been executed so far
+	GetOrder(productId string, orderId string) (*Order, error)
+
+	// EditOrder changes price and total size of an open limit order, ErrEditNotSupported when the exchange can only cancel and replace
+	EditOrder(productId string, orderId string, price decimal.Decimal, size decimal.Decimal) (*Order, error)
+
+	// CancelOrder cancels an open order and returns its final state
+	CancelOrder(productId string, orderId string) (*Order, error)
+
+	// GetFeeRates returns the account's current maker and taker fee rates
+	GetFeeRates() (*FeeRates, error)
 
 	LastPurchaseTime(ticker string, currency string, since time.Time) (*time.Time, error)
 
 	GetFiatAccount(currency string) (*Account, error)
 
+	GetCryptoAccount(coin string) (*Account, error)
+
+	// GetCandles returns daily candles between start and end sorted from the oldest
+	GetCandles(productId string, start time.Time, end time.Time) ([]Candle, error)
+
 	GetPendingTransfers(currency string) ([]PendingTransfer, error)
 }
 
@@ -33,17 +67,57 @@ type OrderTypeType int32
 const (
 	Market OrderTypeType = 0
 	Limit  OrderTypeType = 1
+	// Maker is a post-only limit order priced from the best bid, the exchange rejects it rather than let it take liquidity
+	Maker OrderTypeType = 2
+)
+
+type OrderStatus string
+
+const (
+	OrderOpen      OrderStatus = "open"
+	OrderFilled    OrderStatus = "filled"
+	OrderCancelled OrderStatus = "cancelled"
+	OrderFailed    OrderStatus = "failed"
 )
 
 type Order struct {
-	Symbol  string
-	OrderID string
+	Symbol       string
+	OrderID      string
+	Status       OrderStatus
+	FilledSize   float64
+	AveragePrice float64
+	Fee          float64 // in quote currency
+}
+
+// Done tells if the order reached a terminal state and won't execute any further.
+func (o *Order) Done() bool {
+	return o.Status != OrderOpen && o.Status != ""
+}
+
+// FilledValue returns the quote amount executed excluding the fee.
+func (o *Order) FilledValue() float64 {
+	return o.FilledSize * o.AveragePrice
+}
+
+// FeeRates are percentages of the order value, like --fee.
+type FeeRates struct {
+	Maker float64
+	Taker float64
 }
 
 type Ticker struct {
 	Price float64
 }
 
+type Candle struct {
+	Time   time.Time
+	Open   float64
+	High   float64
+	Low    float64
+	Close  float64
+	Volume float64
+}
+
 type Product struct {
 	QuoteCurrency string
 	BaseCurrency  string
@@ -52,8 +126,97 @@ type Product struct {
 
 type Account struct {
 	Available float64
+	Balance   float64 // total including funds on hold
+}
+
+type accountNotFound string
+
+func (e accountNotFound) Error() string {
+	return fmt.Sprintf("Cannot find %s account", string(e))
+}
+
+// PaymentMethod is a bank account or other method linked to the exchange account.
+type PaymentMethod struct {
+	Id       string
+	Name     string
+	Type     string // ACH, SEPA, wire etc
+	Currency string
+	Deposit  bool // can fund the account
+}
+
+// SelectPaymentMethod returns the method deposits in the currency use.
+// wanted picks one by id or name, otherwise the only method which deposits in the currency is used,
+// or the first ACH bank account of several.
+func SelectPaymentMethod(methods []PaymentMethod, currency string, wanted string) (*PaymentMethod, error) {
+	if wanted != "" {
+		for i, m := range methods {
+			if m.Id != wanted && !strings.EqualFold(m.Name, wanted) {
+				continue
+			}
+
+			if !m.Deposit || m.Currency != currency {
+				return nil, fmt.Errorf("Payment method %s cannot deposit %s", wanted, currency)
+			}
+			return &methods[i], nil
+		}
+
+		return nil, fmt.Errorf("No payment method %s found on this account", wanted)
+	}
+
+	candidates := []*PaymentMethod{}
+	for i, m := range methods {
+		if m.Deposit && m.Currency == currency {
+			candidates = append(candidates, &methods[i])
+		}
+	}
+
+	switch len(candidates) {
+	case 0:
+		return nil, fmt.Errorf("No payment method which deposits %s found on this account", currency)
+	case 1:
+		return candidates[0], nil
+	}
+
+	for _, m := range candidates {
+		if strings.EqualFold(m.Type, "ACH") {
+			return m, nil
+		}
+	}
+
+	names := []string{}
+	for _, m := range candidates {
+		names = append(names, m.Name)
+	}
+	return nil, fmt.Errorf("Several payment methods deposit %s (%s), choose one with --payment-method", currency, strings.Join(names, ", "))
+}
+
+// TransferStatus tells where a deposit is, exchanges which don't report it leave transfers pending.
+type TransferStatus int
+
+const (
+	TransferPending TransferStatus = iota
+	TransferCompleted
+	TransferCanceled
+	// TransferStuck is pending for longer than the exchange usually takes, it is not waited for
+	TransferStuck
+)
+
+func (s TransferStatus) String() string {
+	switch s {
+	case TransferCompleted:
+		return "completed"
+	case TransferCanceled:
+		return "canceled"
+	case TransferStuck:
+		return "stuck"
+	default:
+		return "pending"
+	}
 }
 
 type PendingTransfer struct {
-	Amount float64
+	Id        string
+	Amount    float64
+	Status    TransferStatus
+	CreatedAt time.Time
 }
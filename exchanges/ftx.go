@@ -2,11 +2,11 @@ package exchanges
 
 import (
 	"errors"
-	"fmt"
 	"math"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -84,24 +84,46 @@ func (f *Ftx) GetProduct(productId string) (*Product, error) {
 	}, nil
 }
 
-func (f *Ftx) Deposit(currency string, amount float64) (*time.Time, error) {
+func (f *Ftx) Deposit(currency string, amount float64, paymentMethod string) (*time.Time, error) {
 	return nil, errors.New("ftx exchange bank deposit is not supported by exchange api")
 }
 
-func (f *Ftx) CreateOrder(productId string, amount float64, orderType OrderTypeType, limitOrderFunc CalcLimitOrder) (*Order, error) {
+func (f *Ftx) GetPaymentMethods() ([]PaymentMethod, error) {
+	return nil, errors.New("ftx exchange payment methods are not supported by exchange api")
+}
+
+func (f *Ftx) CreateOrder(productId string, clientOrderId string, amount float64, orderType OrderTypeType, limitOrderFunc CalcLimitOrder) (*Order, error) {
 
 	if orderType == Market {
 		return nil, errors.New("ftx market oder type is size based and is not supported use limit order type instead")
 	}
 
+	if clientOrderId == "" {
+		clientOrderId = uuid.New().String()
+	}
+
+	existing, err := f.client.Orders.GetOrderByClientID(clientOrderId)
+	if err == nil {
+		return f.GetOrder(productId, strconv.FormatInt(existing.ID, 10))
+	}
+	// ftx answers 404 for client order ids it hasn't seen
+	if !strings.Contains(err.Error(), "Status Code: 404") {
+		return nil, err
+	}
+
 	m, err := f.client.Markets.GetMarketByName(productId)
 
 	if err != nil {
 		return nil, err
 	}
 
-	orderPrice, orderSize := limitOrderFunc(m.Ask, decimal.NewFromFloat(amount))
-	clientOrderID := uuid.New().String()
+	best := m.Ask
+	if orderType == Maker {
+		best = m.Bid
+	}
+
+	orderPrice, orderSize := limitOrderFunc(best, decimal.NewFromFloat(amount))
+	postOnly := orderType == Maker
 
 	p := models.PlaceOrderPayload{
 		Market:   productId,
@@ -109,19 +131,94 @@ func (f *Ftx) CreateOrder(productId string, amount float64, orderType OrderTypeT
 		Side:     "buy",
 		Size:     orderSize,
 		Price:    orderPrice,
-		ClientID: &clientOrderID,
+		PostOnly: &postOnly,
+		ClientID: &clientOrderId,
 	}
 
 	order, err := f.client.PlaceOrder(&p)
 	if err != nil {
-		return nil, nil
+		return nil, err
 	}
 
 	return &Order{
+		Symbol:  productId,
 		OrderID: strconv.FormatInt(order.ID, 10),
+		Status:  OrderOpen,
 	}, nil
 }
 
+func (f *Ftx) GetOrder(productId string, orderId string) (*Order, error) {
+	id, err := strconv.ParseInt(orderId, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	order, err := f.client.Orders.GetOrder(id)
+	if err != nil {
+		return nil, err
+	}
+
+	filledSize, _ := order.FilledSize.Float64()
+	averagePrice, _ := order.AvgFillPrice.Float64()
+
+	result := &Order{
+		Symbol:       order.Market,
+		OrderID:      orderId,
+		FilledSize:   filledSize,
+		AveragePrice: averagePrice,
+		Status:       OrderOpen,
+	}
+
+	if order.Status == models.Closed {
+		result.Status = OrderFilled
+		if order.FilledSize.LessThan(order.Size) {
+			result.Status = OrderCancelled
+		}
+	}
+
+	if filledSize == 0 {
+		return result, nil
+	}
+
+	fills, err := f.client.GetFills(&models.GetFillsParams{OrderID: &id})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fill := range fills {
+		result.Fee += fill.Fee
+	}
+
+	return result, nil
+}
+
+func (f *Ftx) EditOrder(productId string, orderId string, price decimal.Decimal, size decimal.Decimal) (*Order, error) {
+	return nil, ErrEditNotSupported
+}
+
+func (f *Ftx) CancelOrder(productId string, orderId string) (*Order, error) {
+	id, err := strconv.ParseInt(orderId, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := f.client.Orders.CancelOrder(id); err != nil {
+		return nil, err
+	}
+
+	order, err := f.GetOrder(productId, orderId)
+	if err != nil {
+		return nil, err
+	}
+
+	//cancellation is asynchronous, the order may still show as open for a moment
+	if !order.Done() {
+		order.Status = OrderCancelled
+	}
+
+	return order, nil
+}
+
 func (f *Ftx) LastPurchaseTime(ticker string, currency string, since time.Time) (*time.Time, error) {
 	product := f.GetTickerSymbol(ticker, currency)
 	t := since.Unix()
@@ -146,6 +243,44 @@ func (f *Ftx) LastPurchaseTime(ticker string, currency string, since time.Time)
 	return nil, nil
 }
 
+func (f *Ftx) GetOrderBook(productId string) (*OrderBook, error) {
+	depth := 100
+	book, err := f.client.Markets.GetOrderBook(productId, &depth)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OrderBook{Bids: ftxBookLevels(book.Bids), Asks: ftxBookLevels(book.Asks)}, nil
+}
+
+// ftx levels are [price, size] pairs
+func ftxBookLevels(levels [][]decimal.Decimal) []BookLevel {
+	result := []BookLevel{}
+	for _, l := range levels {
+		if len(l) < 2 {
+			continue
+		}
+
+		price, _ := l[0].Float64()
+		size, _ := l[1].Float64()
+		result = append(result, BookLevel{Price: price, Size: size})
+	}
+
+	return result
+}
+
+func (f *Ftx) GetFeeRates() (*FeeRates, error) {
+	info, err := f.client.Account.GetAccountInformation()
+	if err != nil {
+		return nil, err
+	}
+
+	maker, _ := info.MakerFee.Mul(decimal.NewFromInt(100)).Float64()
+	taker, _ := info.TakerFee.Mul(decimal.NewFromInt(100)).Float64()
+
+	return &FeeRates{Maker: maker, Taker: taker}, nil
+}
+
 func (f *Ftx) GetFiatAccount(currency string) (*Account, error) {
 	balances, err := f.client.GetBalances()
 	if err != nil {
@@ -155,19 +290,68 @@ func (f *Ftx) GetFiatAccount(currency string) (*Account, error) {
 	for _, b := range balances {
 		if b.Coin == currency {
 			avaialbe, _ := b.Free.Float64()
+			total, _ := b.Total.Float64()
 			return &Account{
 				Available: avaialbe,
+				Balance:   total,
 			}, nil
 		}
 	}
 
-	return nil, fmt.Errorf("Cannot find %s account", currency)
+	return nil, accountNotFound(currency)
+}
+
+func (f *Ftx) GetCryptoAccount(coin string) (*Account, error) {
+	account, err := f.GetFiatAccount(coin)
+	if err != nil {
+		//ftx doesn't list coins with no balance
+		if _, ok := err.(accountNotFound); ok {
+			return &Account{}, nil
+		}
+		return nil, err
+	}
+
+	return account, nil
 }
 
 func (f *Ftx) GetPendingTransfers(currency string) ([]PendingTransfer, error) {
 	return []PendingTransfer{}, nil
 }
 
+func (f *Ftx) GetCandles(productId string, start time.Time, end time.Time) ([]Candle, error) {
+	startTime := int(start.Unix())
+	endTime := int(end.Unix())
+
+	prices, err := f.client.Markets.GetHistoricalPrices(productId, &models.GetHistoricalPricesParams{
+		Resolution: models.Day,
+		StartTime:  &startTime,
+		EndTime:    &endTime,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := []Candle{}
+	for _, p := range prices {
+		open, _ := p.Open.Float64()
+		high, _ := p.High.Float64()
+		low, _ := p.Low.Float64()
+		close, _ := p.Close.Float64()
+		volume, _ := p.Volume.Float64()
+
+		result = append(result, Candle{
+			Time:   p.StartTime,
+			Open:   open,
+			High:   high,
+			Low:    low,
+			Close:  close,
+			Volume: volume,
+		})
+	}
+
+	return result, nil
+}
+
 func (f *Ftx) MinimumPurchaseSize(productId string) (float64, error) {
 	m, err := f.client.Markets.GetMarketByName(productId)
 
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math"
 	"os"
+	"sort"
 	"time"
 
 	"github.com/google/uuid"
@@ -69,11 +70,15 @@ func (g *Gemini) GetProduct(productId string) (*Product, error) {
 	}, nil
 }
 
-func (g *Gemini) Deposit(currency string, amount float64) (*time.Time, error) {
+func (g *Gemini) Deposit(currency string, amount float64, paymentMethod string) (*time.Time, error) {
 	return nil, errors.New("gemini exchange bank deposit is not supported by exchange api")
 }
 
-func (g *Gemini) CreateOrder(productId string, amount float64, orderType OrderTypeType, limitOrderFunc CalcLimitOrder) (*Order, error) {
+func (g *Gemini) GetPaymentMethods() ([]PaymentMethod, error) {
+	return nil, errors.New("gemini exchange payment methods are not supported by exchange api")
+}
+
+func (g *Gemini) CreateOrder(productId string, clientOrderId string, amount float64, orderType OrderTypeType, limitOrderFunc CalcLimitOrder) (*Order, error) {
 	//gemini doesn't support market order type
 	//set limit order with high enough price to get filled
 
@@ -81,6 +86,18 @@ func (g *Gemini) CreateOrder(productId string, amount float64, orderType OrderTy
 		return nil, errors.New("gemini exchange api does not support marker order type")
 	}
 
+	if clientOrderId == "" {
+		clientOrderId = uuid.New().String()
+	}
+
+	existing, err := g.findOrder(productId, clientOrderId)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return existing, nil
+	}
+
 	symbol, err := g.client.SymbolDetails(productId)
 	if err != nil {
 		return nil, err
@@ -91,26 +108,125 @@ func (g *Gemini) CreateOrder(productId string, amount float64, orderType OrderTy
 		return nil, err
 	}
 
-	orderPrice, orderSize := limitOrderFunc(decimal.NewFromFloat(ticker.Ask), decimal.NewFromFloat(amount))
+	best := ticker.Ask
+	var options []string
+	if orderType == Maker {
+		best = ticker.Bid
+		options = []string{"maker-or-cancel"}
+	}
+
+	orderPrice, orderSize := limitOrderFunc(decimal.NewFromFloat(best), decimal.NewFromFloat(amount))
 
 	//symbol.TickSize apply precision to order size
 	orderSize = orderSize.Truncate(decimalPrecision(symbol.TickSize))
 	orderPricef, _ := orderPrice.Float64()
 	orderSizef, _ := orderSize.Float64()
 
-	clientOrderID := uuid.New().String()
-
-	_, err = g.client.NewOrder(productId, clientOrderID, orderSizef, orderPricef, "Buy", nil)
+	order, err := g.client.NewOrder(productId, clientOrderId, orderSizef, orderPricef, "Buy", options)
 	if err != nil {
 		return nil, err
 	}
 
+	//maker-or-cancel orders which would take liquidity are cancelled right away
+	if order.IsCancelled {
+		return nil, fmt.Errorf("order %s is cancelled by the exchange, %s: %w", order.OrderId, order.Reason, ErrPostOnlyRejected)
+	}
+
 	return &Order{
 		Symbol:  productId,
-		OrderID: clientOrderID,
+		OrderID: order.OrderId,
+		Status:  OrderOpen,
 	}, nil
 }
 
+// findOrder looks for the client order id among the live orders and the order history, nil when it was never placed.
+func (g *Gemini) findOrder(productId string, clientOrderId string) (*Order, error) {
+	active, err := g.client.ActiveOrders()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, order := range active {
+		if order.ClientOrderId == clientOrderId {
+			return g.convertOrder(productId, order)
+		}
+	}
+
+	orders, err := g.client.OrderStatusByClientId(clientOrderId)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, order := range orders {
+		if order.ClientOrderId == clientOrderId {
+			return g.convertOrder(productId, order)
+		}
+	}
+
+	return nil, nil
+}
+
+func (g *Gemini) GetOrder(productId string, orderId string) (*Order, error) {
+	order, err := g.client.OrderStatus(orderId)
+	if err != nil {
+		return nil, err
+	}
+
+	return g.convertOrder(productId, order)
+}
+
+func (g *Gemini) EditOrder(productId string, orderId string, price decimal.Decimal, size decimal.Decimal) (*Order, error) {
+	return nil, ErrEditNotSupported
+}
+
+func (g *Gemini) CancelOrder(productId string, orderId string) (*Order, error) {
+	order, err := g.client.CancelOrder(orderId)
+	if err != nil {
+		return nil, err
+	}
+
+	return g.convertOrder(productId, order)
+}
+
+func (g *Gemini) convertOrder(productId string, order gemini.Order) (*Order, error) {
+	result := &Order{
+		Symbol:       order.Symbol,
+		OrderID:      order.OrderId,
+		FilledSize:   order.ExecutedAmount,
+		AveragePrice: order.AvgExecutionPrice,
+	}
+
+	switch {
+	case order.IsLive:
+		result.Status = OrderOpen
+	case order.IsCancelled:
+		result.Status = OrderCancelled
+	default:
+		result.Status = OrderFilled
+	}
+
+	if order.ExecutedAmount == 0 {
+		return result, nil
+	}
+
+	//order status has no fees, sum them up from the order's trades
+	args := gemini.Args{}
+	args["timestamp"] = time.UnixMilli(order.Timestampms)
+
+	trades, err := g.client.PastTrades(productId, args)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range trades {
+		if t.OrderId == order.OrderId {
+			result.Fee += t.FeeAmount
+		}
+	}
+
+	return result, nil
+}
+
 func (g *Gemini) LastPurchaseTime(ticker string, currency string, since time.Time) (*time.Time, error) {
 	product := g.GetTickerSymbol(ticker, currency)
 	//past trades history for a given symbol
@@ -134,6 +250,33 @@ func (g *Gemini) LastPurchaseTime(ticker string, currency string, since time.Tim
 	return &lastTransactionTime, nil
 }
 
+func (g *Gemini) GetOrderBook(productId string) (*OrderBook, error) {
+	book, err := g.client.OrderBook(productId, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &OrderBook{}
+	for _, b := range book.Bids {
+		result.Bids = append(result.Bids, BookLevel{Price: b.Price, Size: b.Amount})
+	}
+	for _, a := range book.Asks {
+		result.Asks = append(result.Asks, BookLevel{Price: a.Price, Size: a.Amount})
+	}
+
+	return result, nil
+}
+
+func (g *Gemini) GetFeeRates() (*FeeRates, error) {
+	volume, err := g.client.NotionalVolume()
+	if err != nil {
+		return nil, err
+	}
+
+	//orders go through the api, basis points to percentage
+	return &FeeRates{Maker: volume.ApiMakerFeeBps / 100, Taker: volume.ApiTakerFeeBps / 100}, nil
+}
+
 func (g *Gemini) GetFiatAccount(currency string) (*Account, error) {
 	balances, err := g.client.Balances()
 	if err != nil {
@@ -150,10 +293,23 @@ func (g *Gemini) GetFiatAccount(currency string) (*Account, error) {
 	}
 
 	if fiatBalance == nil {
-		return nil, fmt.Errorf("Cannot find %s account", currency)
+		return nil, accountNotFound(currency)
+	}
+
+	return &Account{Available: fiatBalance.Available, Balance: fiatBalance.Amount}, nil
+}
+
+func (g *Gemini) GetCryptoAccount(coin string) (*Account, error) {
+	account, err := g.GetFiatAccount(coin)
+	if err != nil {
+		//gemini doesn't list currencies with no balance
+		if _, ok := err.(accountNotFound); ok {
+			return &Account{}, nil
+		}
+		return nil, err
 	}
 
-	return &Account{Available: fiatBalance.Available}, nil
+	return account, nil
 }
 
 //this is not something gemini can profide
@@ -161,6 +317,36 @@ func (g *Gemini) GetPendingTransfers(currency string) ([]PendingTransfer, error)
 	return []PendingTransfer{}, nil
 }
 
+func (g *Gemini) GetCandles(productId string, start time.Time, end time.Time) ([]Candle, error) {
+	//api returns candles of the last 2 years for the daily time frame
+	candles, err := g.client.Candles(productId, "1day")
+	if err != nil {
+		return nil, err
+	}
+
+	result := []Candle{}
+	for _, c := range candles {
+		if c.TimestampmsT.Before(start) || c.TimestampmsT.After(end) {
+			continue
+		}
+
+		result = append(result, Candle{
+			Time:   c.TimestampmsT,
+			Open:   c.Open,
+			High:   c.High,
+			Low:    c.Low,
+			Close:  c.Close,
+			Volume: c.Volume,
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Time.Before(result[j].Time)
+	})
+
+	return result, nil
+}
+
 func decimalPrecision(n float64) int32 {
 	if n > 1 {
 		return 0
@@ -0,0 +1,466 @@
+package exchanges
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+const candleDuration = 24 * time.Hour
+
+// Simulated is an exchange moving no real money, prices come from a PriceSource.
+// Deposits settle instantly, market orders fill at the open price of the current candle plus the fee.
+type Simulated struct {
+	Now func() time.Time
+
+	currency   string
+	fee        float64 // fraction of the order amount
+	prices     PriceSource
+	replayFrom time.Time // day the first run maps to, replays history in real time
+	statePath  string    // state is kept in memory only when empty
+	state      simulatedState
+}
+
+type simulatedState struct {
+	Started  time.Time          `json:"started"`
+	Fiat     float64            `json:"fiat"`
+	Deposits float64            `json:"deposits"`
+	Holdings map[string]float64 `json:"holdings"`
+	Fills    []SimulatedFill    `json:"fills"`
+}
+
+// SimulatedFill is an order filled by the simulated exchange.
+type SimulatedFill struct {
+	Time          time.Time `json:"time"`
+	OrderID       string    `json:"order_id"`
+	ClientOrderID string    `json:"client_order_id,omitempty"`
+	ProductId     string    `json:"product_id"`
+	Coin          string    `json:"coin"`
+	Amount        float64   `json:"amount"` // fiat spent including the fee
+	Size          float64   `json:"size"`
+	Price         float64   `json:"price"`
+	Fee           float64   `json:"fee"`
+}
+
+// NewSimulated creates a simulated exchange quoting in currency, candles are keyed by product id, e.g. BTC-USD.
+// fee is a percentage of the order amount, e.g. 0.5.
+func NewSimulated(currency string, fee float64, candles map[string][]Candle) *Simulated {
+	return &Simulated{
+		Now:      time.Now,
+		currency: currency,
+		fee:      fee / 100,
+		prices:   NewCandlePrices(candles),
+		state: simulatedState{
+			Holdings: map[string]float64{},
+		},
+	}
+}
+
+// NewPaper creates a paper trading exchange keeping balances and fills in the state file between runs.
+// When replayFrom is set prices are replayed from that day on, as if the first run happened on it.
+func NewPaper(statePath string, currency string, fee float64, prices PriceSource, replayFrom time.Time) (*Simulated, error) {
+	s := &Simulated{
+		Now:        time.Now,
+		currency:   currency,
+		fee:        fee / 100,
+		prices:     prices,
+		statePath:  statePath,
+		replayFrom: replayFrom,
+	}
+
+	data, err := os.ReadFile(statePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err == nil {
+		if err := json.Unmarshal(data, &s.state); err != nil {
+			return nil, fmt.Errorf("paper exchange state %s is corrupted: %w", statePath, err)
+		}
+	}
+
+	if s.state.Holdings == nil {
+		s.state.Holdings = map[string]float64{}
+	}
+
+	return s, nil
+}
+
+func (s *Simulated) GetTickerSymbol(baseCurrency string, quoteCurrency string) string {
+	return baseCurrency + "-" + quoteCurrency
+}
+
+func (s *Simulated) GetTicker(productId string) (*Ticker, error) {
+	candle, err := s.prices.Candle(productId, s.priceTime())
+	if err != nil {
+		return nil, err
+	}
+
+	return &Ticker{Price: candle.Open}, nil
+}
+
+func (s *Simulated) GetProduct(productId string) (*Product, error) {
+	return s.prices.GetProduct(productId)
+}
+
+// GetPaymentMethods returns no methods, deposits to the simulated exchange need none.
+func (s *Simulated) GetPaymentMethods() ([]PaymentMethod, error) {
+	return []PaymentMethod{}, nil
+}
+
+func (s *Simulated) Deposit(currency string, amount float64, paymentMethod string) (*time.Time, error) {
+	if currency != s.currency {
+		return nil, accountNotFound(currency)
+	}
+
+	s.state.Fiat += amount
+	s.state.Deposits += amount
+
+	if err := s.save(); err != nil {
+		return nil, err
+	}
+
+	now := s.Now()
+	return &now, nil
+}
+
+func (s *Simulated) CreateOrder(productId string, clientOrderId string, amount float64, orderType OrderTypeType, limitOrderFunc CalcLimitOrder) (*Order, error) {
+	for _, f := range s.state.Fills {
+		if clientOrderId != "" && f.ClientOrderID == clientOrderId {
+			return s.GetOrder(productId, f.OrderID)
+		}
+	}
+
+	if amount > s.state.Fiat {
+		return nil, fmt.Errorf("insufficient funds, available %.2f, order amount %.2f", s.state.Fiat, amount)
+	}
+
+	candle, err := s.prices.Candle(productId, s.priceTime())
+	if err != nil {
+		return nil, err
+	}
+
+	var price, size, fee decimal.Decimal
+
+	switch orderType {
+	case Market:
+		price = decimal.NewFromFloat(candle.Open)
+		fee = decimal.NewFromFloat(amount).Mul(decimal.NewFromFloat(s.fee))
+		size = decimal.NewFromFloat(amount).Sub(fee).Div(price).Truncate(8)
+	case Limit:
+		orderPrice, orderSize := limitOrderFunc(decimal.NewFromFloat(candle.Open), decimal.NewFromFloat(amount))
+		if orderPrice.LessThan(decimal.NewFromFloat(candle.Low)) {
+			return nil, fmt.Errorf("limit price %s is below the day's low %.2f, order would not fill", orderPrice, candle.Low)
+		}
+
+		//a limit above the market fills at the market price
+		price = decimal.Min(orderPrice, decimal.NewFromFloat(candle.Open))
+		size = orderSize
+		fee = size.Mul(price).Mul(decimal.NewFromFloat(s.fee))
+	case Maker:
+		//the open stands in for the best bid
+		orderPrice, orderSize := limitOrderFunc(decimal.NewFromFloat(candle.Open), decimal.NewFromFloat(amount))
+		if orderPrice.GreaterThan(decimal.NewFromFloat(candle.Open)) {
+			return nil, fmt.Errorf("post only price %s is above the market %.2f: %w", orderPrice, candle.Open, ErrPostOnlyRejected)
+		}
+		if orderPrice.LessThan(decimal.NewFromFloat(candle.Low)) {
+			return nil, fmt.Errorf("limit price %s is below the day's low %.2f, order would not fill", orderPrice, candle.Low)
+		}
+
+		price = orderPrice
+		size = orderSize
+		fee = size.Mul(price).Mul(decimal.NewFromFloat(s.fee))
+	default:
+		return nil, errors.New("unsupported order type")
+	}
+
+	cost, _ := size.Mul(price).Add(fee).Float64()
+	if cost > s.state.Fiat {
+		return nil, fmt.Errorf("insufficient funds, available %.2f, order cost %.2f", s.state.Fiat, cost)
+	}
+
+	base, _, _ := strings.Cut(productId, "-")
+	sizef, _ := size.Float64()
+	pricef, _ := price.Float64()
+	feef, _ := fee.Float64()
+	orderId := uuid.New().String()
+
+	s.state.Fiat -= cost
+	s.state.Holdings[base] += sizef
+	s.state.Fills = append(s.state.Fills, SimulatedFill{
+		Time:          s.Now(),
+		OrderID:       orderId,
+		ClientOrderID: clientOrderId,
+		ProductId:     productId,
+		Coin:          base,
+		Amount:        cost,
+		Size:          sizef,
+		Price:         pricef,
+		Fee:           feef,
+	})
+
+	if err := s.save(); err != nil {
+		return nil, err
+	}
+
+	return &Order{
+		Symbol:       productId,
+		OrderID:      orderId,
+		Status:       OrderFilled,
+		FilledSize:   sizef,
+		AveragePrice: pricef,
+		Fee:          feef,
+	}, nil
+}
+
+func (s *Simulated) GetOrder(productId string, orderId string) (*Order, error) {
+	for _, f := range s.state.Fills {
+		if f.OrderID == orderId {
+			return &Order{
+				Symbol:       f.ProductId,
+				OrderID:      f.OrderID,
+				Status:       OrderFilled,
+				FilledSize:   f.Size,
+				AveragePrice: f.Price,
+				Fee:          f.Fee,
+			}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("order %s not found", orderId)
+}
+
+// EditOrder never succeeds as simulated orders fill right away or not at all.
+func (s *Simulated) EditOrder(productId string, orderId string, price decimal.Decimal, size decimal.Decimal) (*Order, error) {
+	return nil, fmt.Errorf("order %s is not open", orderId)
+}
+
+// CancelOrder never succeeds as simulated orders fill right away or not at all.
+func (s *Simulated) CancelOrder(productId string, orderId string) (*Order, error) {
+	return nil, fmt.Errorf("order %s is not open", orderId)
+}
+
+func (s *Simulated) LastPurchaseTime(coin string, currency string, since time.Time) (*time.Time, error) {
+	productId := s.GetTickerSymbol(coin, currency)
+
+	for i := len(s.state.Fills) - 1; i >= 0; i-- {
+		f := s.state.Fills[i]
+		if f.ProductId == productId && f.Time.After(since) {
+			return &f.Time, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// GetOrderBook returns a book with the current price on both sides and unlimited depth.
+func (s *Simulated) GetOrderBook(productId string) (*OrderBook, error) {
+	ticker, err := s.GetTicker(productId)
+	if err != nil {
+		return nil, err
+	}
+
+	level := []BookLevel{{Price: ticker.Price, Size: math.Inf(1)}}
+
+	return &OrderBook{Bids: level, Asks: level}, nil
+}
+
+// GetFeeRates returns the fee every simulated order pays.
+func (s *Simulated) GetFeeRates() (*FeeRates, error) {
+	return &FeeRates{Maker: s.fee * 100, Taker: s.fee * 100}, nil
+}
+
+func (s *Simulated) GetFiatAccount(currency string) (*Account, error) {
+	if currency != s.currency {
+		return nil, accountNotFound(currency)
+	}
+
+	return &Account{Available: s.state.Fiat, Balance: s.state.Fiat}, nil
+}
+
+func (s *Simulated) GetCryptoAccount(coin string) (*Account, error) {
+	balance := s.state.Holdings[coin]
+
+	return &Account{Available: balance, Balance: balance}, nil
+}
+
+// GetCandles returns only candles closed by now, so strategies can't peek into the future.
+func (s *Simulated) GetCandles(productId string, start time.Time, end time.Time) ([]Candle, error) {
+	shift := s.shift()
+	return s.prices.GetCandles(productId, start.Add(shift), end.Add(shift), s.priceTime())
+}
+
+func (s *Simulated) GetPendingTransfers(currency string) ([]PendingTransfer, error) {
+	return []PendingTransfer{}, nil
+}
+
+// Fills returns all the orders filled so far, oldest first.
+func (s *Simulated) Fills() []SimulatedFill {
+	return s.state.Fills
+}
+
+// Deposited returns the total amount deposited so far.
+func (s *Simulated) Deposited() float64 {
+	return s.state.Deposits
+}
+
+func (s *Simulated) priceTime() time.Time {
+	return s.Now().Add(s.shift())
+}
+
+// shift returns how far replayed prices are from now, the first price asked for starts the replay.
+func (s *Simulated) shift() time.Duration {
+	if s.replayFrom.IsZero() {
+		return 0
+	}
+
+	if s.state.Started.IsZero() {
+		s.state.Started = s.Now()
+	}
+
+	return s.replayFrom.Sub(s.state.Started)
+}
+
+// save writes the state to a temporary file first so a crash never leaves it half written.
+func (s *Simulated) save() error {
+	if s.statePath == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.statePath), 0700); err != nil {
+		return err
+	}
+
+	tmp := s.statePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.statePath)
+}
+
+// PriceSource feeds a simulated exchange with prices, product ids are in BASE-QUOTE format.
+type PriceSource interface {
+	GetProduct(productId string) (*Product, error)
+
+	// Candle returns the candle of the day at falls into, its open is the current price
+	Candle(productId string, at time.Time) (*Candle, error)
+
+	// GetCandles returns daily candles between start and end closed by now sorted from the oldest
+	GetCandles(productId string, start time.Time, end time.Time, now time.Time) ([]Candle, error)
+}
+
+type candlePrices struct {
+	candles map[string][]Candle
+}
+
+// NewCandlePrices replays historical daily candles keyed by product id.
+func NewCandlePrices(candles map[string][]Candle) PriceSource {
+	sorted := map[string][]Candle{}
+	for productId, c := range candles {
+		c = append([]Candle{}, c...)
+		sort.Slice(c, func(i, j int) bool {
+			return c[i].Time.Before(c[j].Time)
+		})
+		sorted[productId] = c
+	}
+
+	return &candlePrices{candles: sorted}
+}
+
+func (p *candlePrices) GetProduct(productId string) (*Product, error) {
+	base, quote, found := strings.Cut(productId, "-")
+	if !found {
+		return nil, fmt.Errorf("unsupported product %s", productId)
+	}
+
+	if _, ok := p.candles[productId]; !ok {
+		return nil, fmt.Errorf("no price history for %s", productId)
+	}
+
+	return &Product{
+		BaseCurrency:  base,
+		QuoteCurrency: quote,
+	}, nil
+}
+
+func (p *candlePrices) Candle(productId string, at time.Time) (*Candle, error) {
+	candles, found := p.candles[productId]
+	if !found {
+		return nil, fmt.Errorf("no price history for %s", productId)
+	}
+
+	i := sort.Search(len(candles), func(i int) bool {
+		return candles[i].Time.After(at)
+	})
+
+	if i == 0 || at.Sub(candles[i-1].Time) >= candleDuration {
+		return nil, fmt.Errorf("no price for %s at %s", productId, at.Format("2006-01-02"))
+	}
+
+	return &candles[i-1], nil
+}
+
+func (p *candlePrices) GetCandles(productId string, start time.Time, end time.Time, now time.Time) ([]Candle, error) {
+	candles := []Candle{}
+
+	for _, c := range p.candles[productId] {
+		if c.Time.Before(start) || c.Time.After(end) || c.Time.Add(candleDuration).After(now) {
+			continue
+		}
+		candles = append(candles, c)
+	}
+
+	return candles, nil
+}
+
+type livePrices struct {
+	exchange Exchange
+}
+
+// NewLivePrices takes current prices from a real exchange.
+func NewLivePrices(exchange Exchange) PriceSource {
+	return &livePrices{exchange: exchange}
+}
+
+func (p *livePrices) symbol(productId string) string {
+	base, quote, _ := strings.Cut(productId, "-")
+	return p.exchange.GetTickerSymbol(base, quote)
+}
+
+func (p *livePrices) GetProduct(productId string) (*Product, error) {
+	return p.exchange.GetProduct(p.symbol(productId))
+}
+
+func (p *livePrices) Candle(productId string, at time.Time) (*Candle, error) {
+	ticker, err := p.exchange.GetTicker(p.symbol(productId))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Candle{
+		Time:  at,
+		Open:  ticker.Price,
+		High:  ticker.Price,
+		Low:   ticker.Price,
+		Close: ticker.Price,
+	}, nil
+}
+
+func (p *livePrices) GetCandles(productId string, start time.Time, end time.Time, now time.Time) ([]Candle, error) {
+	return p.exchange.GetCandles(p.symbol(productId), start, end)
+}
@@ -0,0 +1,148 @@
+package exchanges
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSimulated(t *testing.T) {
+	day := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := day.Add(12 * time.Hour)
+
+	s := NewSimulated("USD", 1, map[string][]Candle{
+		"BTC-USD": {
+			{Time: day.AddDate(0, 0, 1), Open: 110, High: 120, Low: 100, Close: 115},
+			{Time: day, Open: 100, High: 110, Low: 90, Close: 105},
+		},
+	})
+	s.Now = func() time.Time { return now }
+
+	ticker, err := s.GetTicker("BTC-USD")
+	assert.Nil(t, err)
+	assert.Equal(t, 100.0, ticker.Price)
+
+	_, err = s.CreateOrder("BTC-USD", "1", 50, Market, nil)
+	assert.Equal(t, "insufficient funds, available 0.00, order amount 50.00", err.Error())
+
+	_, err = s.Deposit("USD", 100, "")
+	assert.Nil(t, err)
+
+	first, err := s.CreateOrder("BTC-USD", "2", 50, Market, nil)
+	assert.Nil(t, err)
+
+	again, err := s.CreateOrder("BTC-USD", "2", 50, Market, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, first.OrderID, again.OrderID)
+
+	limit := func(askPrice decimal.Decimal, fiatAmount decimal.Decimal) (decimal.Decimal, decimal.Decimal) {
+		return decimal.NewFromFloat(80), decimal.NewFromFloat(0.1)
+	}
+	_, err = s.CreateOrder("BTC-USD", "3", 8, Limit, limit)
+	assert.NotNil(t, err)
+
+	maker := func(price float64) CalcLimitOrder {
+		return func(bidPrice decimal.Decimal, fiatAmount decimal.Decimal) (decimal.Decimal, decimal.Decimal) {
+			return decimal.NewFromFloat(price), decimal.NewFromFloat(0.1)
+		}
+	}
+	_, err = s.CreateOrder("BTC-USD", "4", 11, Maker, maker(101))
+	assert.ErrorIs(t, err, ErrPostOnlyRejected)
+
+	order, err := s.CreateOrder("BTC-USD", "5", 10, Maker, maker(95))
+	assert.Nil(t, err)
+	assert.Equal(t, 95.0, order.AveragePrice)
+
+	fiat, _ := s.GetFiatAccount("USD")
+	assert.Equal(t, 40.405, fiat.Available)
+
+	btc, _ := s.GetCryptoAccount("BTC")
+	assert.Equal(t, 0.595, btc.Balance)
+
+	last, _ := s.LastPurchaseTime("BTC", "USD", day)
+	assert.Equal(t, now, *last)
+
+	// today's candle is still open
+	candles, _ := s.GetCandles("BTC-USD", day.AddDate(0, 0, -10), now)
+	assert.Len(t, candles, 0)
+
+	now = day.AddDate(0, 0, 1).Add(time.Hour)
+	candles, _ = s.GetCandles("BTC-USD", day.AddDate(0, 0, -10), now)
+	assert.Len(t, candles, 1)
+
+	now = day.AddDate(0, 0, 2).Add(time.Hour)
+	_, err = s.GetTicker("BTC-USD")
+	assert.Equal(t, "no price for BTC-USD at 2020-01-03", err.Error())
+}
+
+func TestCandlesCSV(t *testing.T) {
+	candles, err := ReadCandlesCSV(strings.NewReader("2020-01-02,2,3,1,2.5,10\n1577836800,1,2,0.5,1.5,20\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, []Candle{
+		{Time: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), Open: 1, High: 2, Low: 0.5, Close: 1.5, Volume: 20},
+		{Time: time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC), Open: 2, High: 3, Low: 1, Close: 2.5, Volume: 10},
+	}, candles)
+
+	var buf bytes.Buffer
+	assert.Nil(t, WriteCandlesCSV(&buf, candles))
+
+	read, err := ReadCandlesCSV(&buf)
+	assert.Nil(t, err)
+	assert.Equal(t, candles, read)
+
+	_, err = ReadCandlesCSV(strings.NewReader("yesterday,1,2,3,4,5\n"))
+	assert.Equal(t, "line 1: unsupported candle time yesterday", err.Error())
+}
+
+func TestPaper(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "paper.json")
+	day := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	started := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+	now := started
+
+	prices := NewCandlePrices(map[string][]Candle{
+		"BTC-USD": {
+			{Time: day, Open: 100, High: 110, Low: 90, Close: 105},
+			{Time: day.AddDate(0, 0, 1), Open: 200, High: 210, Low: 190, Close: 205},
+		},
+	})
+
+	p, err := NewPaper(path, "USD", 0, prices, day)
+	assert.Nil(t, err)
+	p.Now = func() time.Time { return now }
+
+	ticker, err := p.GetTicker("BTC-USD")
+	assert.Nil(t, err)
+	assert.Equal(t, 100.0, ticker.Price)
+
+	_, err = p.Deposit("USD", 100, "")
+	assert.Nil(t, err)
+	_, err = p.CreateOrder("BTC-USD", "1", 50, Market, nil)
+	assert.Nil(t, err)
+
+	// next run a day later picks up the state and the next day of the replay
+	now = started.AddDate(0, 0, 1)
+	p, err = NewPaper(path, "USD", 0, prices, day)
+	assert.Nil(t, err)
+	p.Now = func() time.Time { return now }
+
+	ticker, err = p.GetTicker("BTC-USD")
+	assert.Nil(t, err)
+	assert.Equal(t, 200.0, ticker.Price)
+
+	fiat, _ := p.GetFiatAccount("USD")
+	assert.Equal(t, 50.0, fiat.Available)
+
+	btc, _ := p.GetCryptoAccount("BTC")
+	assert.Equal(t, 0.5, btc.Balance)
+
+	last, _ := p.LastPurchaseTime("BTC", "USD", started.Add(-time.Hour))
+	assert.True(t, started.Equal(*last))
+	assert.Len(t, p.Fills(), 1)
+	assert.Equal(t, 100.0, p.Deposited())
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/sberserker/dcagdax/exchanges"
+)
+
+// fundingPlan tells whether deposits follow --fund-every instead of topping up the shortfall at buy time.
+func (s *gdaxSchedule) fundingPlan() bool {
+	return s.req.fundEvery > 0
+}
+
+// nextFunding returns when the funding plan deposits next, the last deposit plus --fund-every.
+// A deposit which failed since is tried again --retry after the failure when the daemon runs the plan.
+// Without a ledger the last deposit is unknown and every run may deposit, limited by --fund-max-pending.
+func (s *gdaxSchedule) nextFunding(now time.Time) time.Time {
+	if s.ledger == nil {
+		return now
+	}
+
+	next := now
+	if last := s.ledger.lastDeposit(s.req.plan, s.req.exchange, s.req.currency); last != nil {
+		next = last.Add(s.req.fundEvery)
+	}
+
+	failed := s.ledger.lastDepositFailure(s.req.plan, s.req.exchange, s.req.currency)
+	if failed != nil && failed.Time.Add(s.fundRetry).After(next) {
+		next = failed.Time.Add(s.fundRetry)
+	}
+
+	return next
+}
+
+// fundingDeposit returns the deposit the funding plan makes now, zero when its window is not open,
+// too many deposits are outstanding or the balance already covers the buffer.
+func (s *gdaxSchedule) fundingDeposit(now time.Time) (float64, string, error) {
+	if next := s.nextFunding(now); next.After(now) {
+		return 0, "the funding plan deposits next at " + next.Local().Format(timeFormat), nil
+	}
+
+	if s.capsReached(now) {
+		return 0, "the spending cap is reached", nil
+	}
+
+	transfers, err := s.exchange.GetPendingTransfers(s.req.currency)
+	if err != nil {
+		return 0, "", err
+	}
+
+	outstanding := 0
+	pending := decimal.Zero
+	for _, t := range transfers {
+		if t.Status == exchanges.TransferStuck {
+			continue
+		}
+		outstanding++
+		pending = pending.Add(decimal.NewFromFloat(t.Amount))
+	}
+
+	if s.req.fundMaxPending > 0 && outstanding >= s.req.fundMaxPending {
+		return 0, "too many deposits are outstanding", nil
+	}
+
+	fiat, err := s.exchange.GetFiatAccount(s.req.currency)
+	if err != nil {
+		return 0, "", err
+	}
+
+	//the next --fund-buffer windows at the regular amounts
+	windows := decimal.Zero
+	for _, order := range s.coins {
+		windows = windows.Add(decimal.NewFromFloat(order.amount))
+	}
+	buffer := windows.Mul(decimal.NewFromInt(int64(s.req.fundBuffer)))
+	covered := decimal.NewFromFloat(fiat.Available).Add(pending)
+
+	if s.req.fundBuffer > 0 && covered.GreaterThanOrEqual(buffer) {
+		return 0, "the balance covers the buffer", nil
+	}
+
+	amount := decimal.NewFromFloat(s.req.fundUsd)
+	if amount.IsZero() {
+		amount = buffer.Sub(covered)
+	}
+
+	//the balance and pending deposits already pay for part of what the caps on all coins leave
+	if left, ok := s.capRemaining("", now); ok {
+		allowed := decimal.NewFromFloat(left).Sub(covered)
+		if !allowed.IsPositive() {
+			return 0, "the balance covers what the spending cap leaves", nil
+		}
+		amount = decimal.Min(amount, allowed)
+	}
+
+	a, _ := amount.Truncate(2).Float64()
+	return a, "", nil
+}
+
+// fundOnSchedule makes the funding plan's deposit when it is due, whether or not a purchase window is open.
+// A failure is recorded in the ledger so it shows in the status and the daemon doesn't try again before --retry.
+func (s *gdaxSchedule) fundOnSchedule(now time.Time) error {
+	amount, reason, err := s.fundingDeposit(now)
+	if err == nil && amount > 0 {
+		_, err = s.fund(amount)
+	}
+
+	if err != nil {
+		s.record(ledgerEntry{
+			Kind:   ledgerDepositFailed,
+			Amount: amount,
+			Reason: err.Error(),
+		})
+		return err
+	}
+
+	if amount <= 0 {
+		s.logger.Infow(
+			"No deposit of the funding plan",
+			"reason", reason,
+		)
+	}
+
+	return nil
+}
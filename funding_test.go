@@ -0,0 +1,252 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sberserker/dcagdax/exchanges"
+	"github.com/sberserker/dcagdax/mocks"
+)
+
+func TestFundingDeposit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockExchange(ctrl)
+
+	now := time.Now()
+	l, _ := openLedger("")
+
+	s := gdaxSchedule{}
+	s.logger = loggerStub(t).Sugar()
+	s.req = syncRequest{exchange: "coinbase", every: 7 * 24 * time.Hour, currency: "USD", usd: 100, fundEvery: 30 * 24 * time.Hour, fundBuffer: 4, fundMaxPending: 1}
+	s.coins = map[string]orderDetails{"BTC": {symbol: "BTC-USD", amount: 60}, "ETH": {symbol: "ETH-USD", amount: 40}}
+	s.exchange = m
+	s.ledger = l
+
+	t.Run("when the balance is short of the buffer", func(t *testing.T) {
+		m.EXPECT().GetPendingTransfers("USD").Return([]exchanges.PendingTransfer{{Amount: 50, Status: exchanges.TransferStuck}}, nil)
+		m.EXPECT().GetFiatAccount("USD").Return(&exchanges.Account{Available: 120.555}, nil)
+
+		amount, _, err := s.fundingDeposit(now)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 279.44, amount)
+	})
+
+	t.Run("when the balance and pending deposits cover the buffer", func(t *testing.T) {
+		s.req.fundMaxPending = 0
+		defer func() { s.req.fundMaxPending = 1 }()
+
+		m.EXPECT().GetPendingTransfers("USD").Return([]exchanges.PendingTransfer{{Amount: 300}}, nil)
+		m.EXPECT().GetFiatAccount("USD").Return(&exchanges.Account{Available: 100}, nil)
+
+		amount, reason, err := s.fundingDeposit(now)
+
+		assert.Nil(t, err)
+		assert.Zero(t, amount)
+		assert.Equal(t, "the balance covers the buffer", reason)
+	})
+
+	t.Run("when too many deposits are outstanding", func(t *testing.T) {
+		m.EXPECT().GetPendingTransfers("USD").Return([]exchanges.PendingTransfer{{Amount: 10}}, nil)
+
+		amount, reason, err := s.fundingDeposit(now)
+
+		assert.Nil(t, err)
+		assert.Zero(t, amount)
+		assert.Equal(t, "too many deposits are outstanding", reason)
+	})
+
+	t.Run("when the plan deposits a fixed amount", func(t *testing.T) {
+		s.req.fundUsd = 1000
+		s.req.fundBuffer = 0
+
+		m.EXPECT().GetPendingTransfers("USD").Return(nil, nil)
+		m.EXPECT().GetFiatAccount("USD").Return(&exchanges.Account{Available: 5000}, nil)
+
+		amount, _, err := s.fundingDeposit(now)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 1000.0, amount)
+	})
+
+	t.Run("when the spending cap leaves less than the deposit", func(t *testing.T) {
+		s.caps = []spendCap{{amount: 1500, period: capMonth}}
+		s.req.fundMaxPending = 0
+		defer func() {
+			s.caps = nil
+			s.req.fundMaxPending = 1
+		}()
+
+		m.EXPECT().GetPendingTransfers("USD").Return([]exchanges.PendingTransfer{{Amount: 200}}, nil)
+		m.EXPECT().GetFiatAccount("USD").Return(&exchanges.Account{Available: 600}, nil)
+
+		amount, _, err := s.fundingDeposit(now)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 700.0, amount)
+
+		m.EXPECT().GetPendingTransfers("USD").Return([]exchanges.PendingTransfer{{Amount: 200}}, nil)
+		m.EXPECT().GetFiatAccount("USD").Return(&exchanges.Account{Available: 1300}, nil)
+
+		amount, reason, err := s.fundingDeposit(now)
+
+		assert.Nil(t, err)
+		assert.Zero(t, amount)
+		assert.Equal(t, "the balance covers what the spending cap leaves", reason)
+	})
+
+	t.Run("when the plan deposited recently", func(t *testing.T) {
+		l.record(ledgerEntry{Time: now.Add(-10 * 24 * time.Hour), Kind: ledgerDeposit, Exchange: "coinbase", Currency: "USD", Amount: 1000})
+
+		amount, reason, err := s.fundingDeposit(now)
+
+		assert.Nil(t, err)
+		assert.Zero(t, amount)
+		assert.Equal(t, "the funding plan deposits next at "+now.Add(20*24*time.Hour).Local().Format(timeFormat), reason)
+	})
+}
+
+func TestSyncWithFundingPlan(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockExchange(ctrl)
+
+	now := time.Now()
+	l, _ := openLedger("")
+
+	s := gdaxSchedule{}
+	s.logger = loggerStub(t).Sugar()
+	s.req = syncRequest{exchange: "coinbase", every: 7 * 24 * time.Hour, orderType: exchanges.Market, currency: "USD", usd: 50, fundEvery: 28 * 24 * time.Hour, fundUsd: 200, fundMaxPending: 1}
+	s.coins = map[string]orderDetails{"BTC": {symbol: "btcusd", amount: 50}}
+	s.sleepFunc = func(d time.Duration) {}
+	s.nowFunc = func() time.Time { return now }
+	s.exchange = m
+	s.ledger = l
+
+	result := exchanges.Order{OrderID: "1"}
+
+	t.Run("when the funding window opens with the purchase window", func(t *testing.T) {
+		gomock.InOrder(
+			m.EXPECT().GetPendingTransfers("USD").Return(nil, nil),
+			m.EXPECT().GetFiatAccount("USD").Return(&exchanges.Account{Available: 0}, nil),
+			m.EXPECT().Deposit("USD", 200.0, "").Return(&now, nil),
+			m.EXPECT().GetFiatAccount("USD").Return(&exchanges.Account{Available: 0}, nil),
+			m.EXPECT().GetPendingTransfers("USD").Return([]exchanges.PendingTransfer{{Amount: 200}}, nil),
+			m.EXPECT().GetFiatAccount("USD").Return(&exchanges.Account{Available: 200}, nil),
+			m.EXPECT().CreateOrder("btcusd", gomock.Any(), 50.0, exchanges.Market, gomock.Any()).Return(&result, nil),
+		)
+
+		assert.Nil(t, s.Sync())
+	})
+
+	t.Run("when the next purchase is paid from the balance", func(t *testing.T) {
+		now = now.Add(7 * 24 * time.Hour)
+
+		m.EXPECT().GetFiatAccount("USD").Return(&exchanges.Account{Available: 150}, nil)
+		m.EXPECT().CreateOrder("btcusd", gomock.Any(), 50.0, exchanges.Market, gomock.Any()).Return(&result, nil)
+
+		assert.Nil(t, s.Sync())
+	})
+
+	t.Run("when the balance runs out before the next deposit", func(t *testing.T) {
+		now = now.Add(7 * 24 * time.Hour)
+
+		m.EXPECT().GetFiatAccount("USD").Return(&exchanges.Account{Available: 20}, nil)
+		m.EXPECT().GetPendingTransfers("USD").Return(nil, nil)
+
+		err := s.Sync()
+
+		assert.Equal(t, "No sufficient amount for trade, the funding plan deposits next at "+now.Add(14*24*time.Hour).Local().Format(timeFormat), err.Error())
+	})
+}
+
+func TestSyncWhenTheFundingDepositFails(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockExchange(ctrl)
+
+	l, _ := openLedger("")
+
+	s := gdaxSchedule{}
+	s.logger = loggerStub(t).Sugar()
+	s.req = syncRequest{exchange: "coinbase", every: 7 * 24 * time.Hour, orderType: exchanges.Market, currency: "USD", usd: 50, fundEvery: 28 * 24 * time.Hour, fundUsd: 200, fundMaxPending: 1}
+	s.coins = map[string]orderDetails{"BTC": {symbol: "btcusd", amount: 50}}
+	s.sleepFunc = func(d time.Duration) {}
+	s.exchange = m
+	s.ledger = l
+
+	result := exchanges.Order{OrderID: "1"}
+
+	//the balance pays for the window
+	gomock.InOrder(
+		m.EXPECT().GetPendingTransfers("USD").Return(nil, errors.New("service unavailable")),
+		m.EXPECT().GetFiatAccount("USD").Return(&exchanges.Account{Available: 100}, nil),
+		m.EXPECT().CreateOrder("btcusd", gomock.Any(), 50.0, exchanges.Market, gomock.Any()).Return(&result, nil),
+	)
+
+	assert.Nil(t, s.Sync())
+
+	failed := l.lastDepositFailure("", "coinbase", "USD")
+	if assert.NotNil(t, failed) {
+		assert.Equal(t, "service unavailable", failed.Reason)
+	}
+}
+
+func TestDaemonRunWithFundingPlan(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockExchange(ctrl)
+
+	now := time.Now()
+	l, _ := openLedger("")
+	l.record(ledgerEntry{Time: now.Add(-time.Hour), Kind: ledgerOrder, Exchange: "coinbase", Currency: "USD", Coin: "BTC", Amount: 50})
+
+	s := gdaxSchedule{}
+	s.logger = loggerStub(t).Sugar()
+	s.req = syncRequest{exchange: "coinbase", every: 7 * 24 * time.Hour, orderType: exchanges.Market, currency: "USD", usd: 50, fundEvery: 28 * 24 * time.Hour, fundUsd: 200, fundMaxPending: 1}
+	s.coins = map[string]orderDetails{"BTC": {symbol: "btcusd", amount: 50}}
+	s.sleepFunc = func(d time.Duration) {}
+	s.exchange = m
+	s.ledger = l
+
+	t.Run("when the funding plan deposits before the next window", func(t *testing.T) {
+		l.record(ledgerEntry{Time: now.Add(-27 * 24 * time.Hour), Kind: ledgerDeposit, Exchange: "coinbase", Currency: "USD", Amount: 200})
+
+		wait := s.daemonRun(time.Hour)
+
+		assert.InDelta(t, (24 * time.Hour).Seconds(), wait.Seconds(), 60)
+	})
+
+	t.Run("when the funding plan is due", func(t *testing.T) {
+		l.record(ledgerEntry{Time: now.Add(-29 * 24 * time.Hour), Kind: ledgerDeposit, Exchange: "coinbase", Currency: "USD", Amount: 200})
+
+		m.EXPECT().GetPendingTransfers("USD").Return(nil, nil)
+		m.EXPECT().GetFiatAccount("USD").Return(&exchanges.Account{Available: 0}, nil)
+		m.EXPECT().Deposit("USD", 200.0, "").Return(&now, nil)
+
+		wait := s.daemonRun(time.Hour)
+
+		assert.Equal(t, time.Hour, wait)
+	})
+
+	t.Run("when the deposit failed", func(t *testing.T) {
+		l.record(ledgerEntry{Time: now.Add(-29 * 24 * time.Hour), Kind: ledgerDeposit, Exchange: "coinbase", Currency: "USD", Amount: 200})
+		l.record(ledgerEntry{Time: now.Add(-10 * time.Minute), Kind: ledgerDepositFailed, Exchange: "coinbase", Currency: "USD", Amount: 200, Reason: "service unavailable"})
+		s.fundRetry = time.Hour
+		defer func() { s.fundRetry = 0 }()
+
+		//tried again an hour after the failure rather than on every wake-up
+		wait := s.daemonRun(time.Hour)
+
+		assert.InDelta(t, (50 * time.Minute).Seconds(), wait.Seconds(), 60)
+	})
+}
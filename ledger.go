@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type ledgerEntryKind string
+
+const (
+	ledgerDeposit ledgerEntryKind = "deposit"
+	ledgerOrder   ledgerEntryKind = "order"
+	ledgerFill    ledgerEntryKind = "fill"
+	ledgerSkip    ledgerEntryKind = "skip"
+	// ledgerPurchase sums up the fills of a purchase which took several orders
+	ledgerPurchase ledgerEntryKind = "purchase"
+	// ledgerDepositFailed is a deposit of the funding plan which failed, the daemon tries again after --retry
+	ledgerDepositFailed ledgerEntryKind = "deposit-failed"
+)
+
+// ledgerEntry is a single line of the ledger file.
+type ledgerEntry struct {
+	Time      time.Time       `json:"time"`
+	Kind      ledgerEntryKind `json:"kind"`
+	Plan      string          `json:"plan,omitempty"` // named plan of --config, empty for the flags' plan
+	Exchange  string          `json:"exchange"`
+	Currency  string          `json:"currency"`
+	Coin      string          `json:"coin,omitempty"`
+	ProductId string          `json:"product_id,omitempty"`
+	OrderId   string          `json:"order_id,omitempty"`
+	OrderIds  []string        `json:"order_ids,omitempty"` // orders of a purchase
+	Window    *time.Time      `json:"window,omitempty"`    // start of the purchase window of an order, the orders of a purchase share it
+	Amount    float64         `json:"amount,omitempty"`    // fiat amount of the deposit or order, spent including the fee for a fill or purchase
+	Size      float64         `json:"size,omitempty"`
+	Price     float64         `json:"price,omitempty"`
+	Fee       float64         `json:"fee,omitempty"`
+	Done      bool            `json:"done,omitempty"` // the order of a fill is final, nothing more executes
+	Reason    string          `json:"reason,omitempty"`
+}
+
+// ledger is a local append only record of everything Sync did, stored as one json document per line.
+// An empty path keeps the ledger in memory only.
+type ledger struct {
+	path    string
+	entries []ledgerEntry
+}
+
+func openLedger(path string) (*ledger, error) {
+	l := &ledger{path: path}
+
+	if path == "" {
+		return l, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	if err := l.reload(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// reload reads the entries again from the file, other processes may have recorded since it was opened.
+func (l *ledger) reload() error {
+	if l.path == "" {
+		return nil
+	}
+
+	file, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		l.entries = nil
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	entries := []ledgerEntry{}
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var entry ledgerEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("ledger %s is corrupted at line %d: %w", l.path, line, err)
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	l.entries = entries
+	return nil
+}
+
+func (l *ledger) record(entry ledgerEntry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	if l.path != "" {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+
+		file, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		if _, err := file.Write(append(data, '\n')); err != nil {
+			return err
+		}
+
+		if err := file.Sync(); err != nil {
+			return err
+		}
+	}
+
+	l.entries = append(l.entries, entry)
+	return nil
+}
+
+// hasOrder tells whether an entry of the kind records the order already.
+func (l *ledger) hasOrder(kind ledgerEntryKind, orderId string) bool {
+	for _, e := range l.entries {
+		if e.Kind != kind {
+			continue
+		}
+
+		if e.OrderId == orderId {
+			return true
+		}
+
+		for _, id := range e.OrderIds {
+			if id == orderId {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// executing tells whether the latest fill of the order was recorded before the order was done.
+func (l *ledger) executing(orderId string) bool {
+	for i := len(l.entries) - 1; i >= 0; i-- {
+		e := l.entries[i]
+		if e.Kind == ledgerFill && e.OrderId == orderId {
+			return !e.Done
+		}
+	}
+
+	return false
+}
+
+// lastDeposit returns the time of the latest deposit the plan made, nil when the ledger has none.
+func (l *ledger) lastDeposit(plan string, exchange string, currency string) *time.Time {
+	for i := len(l.entries) - 1; i >= 0; i-- {
+		e := l.entries[i]
+		if e.Kind == ledgerDeposit && e.Plan == plan && e.Exchange == exchange && e.Currency == currency {
+			return &e.Time
+		}
+	}
+
+	return nil
+}
+
+// lastDepositFailure returns the plan's latest failed deposit since its last deposit, nil when there is none.
+func (l *ledger) lastDepositFailure(plan string, exchange string, currency string) *ledgerEntry {
+	for i := len(l.entries) - 1; i >= 0; i-- {
+		e := l.entries[i]
+		if e.Plan != plan || e.Exchange != exchange || e.Currency != currency {
+			continue
+		}
+
+		switch e.Kind {
+		case ledgerDeposit:
+			return nil
+		case ledgerDepositFailed:
+			return &e
+		}
+	}
+
+	return nil
+}
+
+// lastPurchase returns when the plan's latest purchase of the coin started, the time of its first order, nil when the ledger has none.
+// The slices of a purchase share its window, so the ones placed later don't push the next window back.
+func (l *ledger) lastPurchase(plan string, exchange string, coin string, currency string) *time.Time {
+	var first *ledgerEntry
+
+	for i := len(l.entries) - 1; i >= 0; i-- {
+		e := l.entries[i]
+		if e.Kind != ledgerOrder || e.Plan != plan || e.Exchange != exchange || e.Coin != coin || e.Currency != currency {
+			continue
+		}
+
+		if first != nil && (e.Window == nil || first.Window == nil || !e.Window.Equal(*first.Window)) {
+			break
+		}
+
+		first = &e
+	}
+
+	if first == nil {
+		return nil
+	}
+
+	return &first.Time
+}
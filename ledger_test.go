@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLedger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "ledger.jsonl")
+
+	t.Run("when file does not exist", func(t *testing.T) {
+		l, err := openLedger(path)
+
+		assert.Nil(t, err)
+		assert.Empty(t, l.entries)
+		assert.Nil(t, l.lastPurchase("", "coinbase", "BTC", "USD"))
+	})
+
+	t.Run("when entries are recorded", func(t *testing.T) {
+		l, err := openLedger(path)
+		assert.Nil(t, err)
+
+		first := time.Now().Add(-48 * time.Hour).Round(0)
+		second := time.Now().Add(-24 * time.Hour).Round(0)
+
+		assert.Nil(t, l.record(ledgerEntry{Time: first, Kind: ledgerOrder, Exchange: "coinbase", Currency: "USD", Coin: "BTC", Amount: 50}))
+		assert.Nil(t, l.record(ledgerEntry{Time: second, Kind: ledgerOrder, Exchange: "coinbase", Currency: "USD", Coin: "BTC", Amount: 50}))
+		assert.Nil(t, l.record(ledgerEntry{Kind: ledgerSkip, Exchange: "coinbase", Currency: "USD", Coin: "BTC", Reason: "test"}))
+		assert.Nil(t, l.record(ledgerEntry{Kind: ledgerOrder, Exchange: "gemini", Currency: "USD", Coin: "BTC", Amount: 50}))
+
+		reopened, err := openLedger(path)
+
+		assert.Nil(t, err)
+		assert.Len(t, reopened.entries, 4)
+		assert.True(t, second.Equal(*reopened.lastPurchase("", "coinbase", "BTC", "USD")))
+		assert.Nil(t, reopened.lastPurchase("", "coinbase", "ETH", "USD"))
+	})
+
+	t.Run("when plans buy the same coin", func(t *testing.T) {
+		l, err := openLedger("")
+		assert.Nil(t, err)
+
+		weekly := time.Now().Add(-72 * time.Hour).Round(0)
+		daily := time.Now().Add(-12 * time.Hour).Round(0)
+
+		assert.Nil(t, l.record(ledgerEntry{Time: weekly, Kind: ledgerOrder, Plan: "weekly", Exchange: "coinbase", Currency: "USD", Coin: "BTC", Amount: 100}))
+		assert.Nil(t, l.record(ledgerEntry{Time: daily, Kind: ledgerOrder, Plan: "daily", Exchange: "coinbase", Currency: "USD", Coin: "BTC", Amount: 10}))
+
+		assert.True(t, weekly.Equal(*l.lastPurchase("weekly", "coinbase", "BTC", "USD")))
+		assert.True(t, daily.Equal(*l.lastPurchase("daily", "coinbase", "BTC", "USD")))
+		assert.Nil(t, l.lastPurchase("", "coinbase", "BTC", "USD"))
+	})
+
+	t.Run("when a purchase is sliced", func(t *testing.T) {
+		l, err := openLedger("")
+		assert.Nil(t, err)
+
+		window := time.Now().Add(-7 * 24 * time.Hour).Round(0)
+		first := window.Add(time.Minute)
+		last := window.Add(2 * time.Hour)
+
+		assert.Nil(t, l.record(ledgerEntry{Time: window.Add(-7 * 24 * time.Hour), Kind: ledgerOrder, Exchange: "coinbase", Currency: "USD", Coin: "BTC", Amount: 50}))
+		assert.Nil(t, l.record(ledgerEntry{Time: first, Kind: ledgerOrder, Exchange: "coinbase", Currency: "USD", Coin: "BTC", Amount: 25, Window: &window}))
+		assert.Nil(t, l.record(ledgerEntry{Time: first, Kind: ledgerOrder, Exchange: "coinbase", Currency: "USD", Coin: "ETH", Amount: 25, Window: &window}))
+		assert.Nil(t, l.record(ledgerEntry{Time: last, Kind: ledgerOrder, Exchange: "coinbase", Currency: "USD", Coin: "BTC", Amount: 25, Window: &window}))
+
+		//the next window counts from the first slice
+		assert.True(t, first.Equal(*l.lastPurchase("", "coinbase", "BTC", "USD")))
+	})
+
+	t.Run("when file is corrupted", func(t *testing.T) {
+		corrupted := filepath.Join(t.TempDir(), "ledger.jsonl")
+		assert.Nil(t, os.WriteFile(corrupted, []byte("{not json}\n"), 0600))
+
+		_, err := openLedger(corrupted)
+
+		assert.NotNil(t, err)
+	})
+}
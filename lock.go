@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// errLocked is returned by lockFile when another process holds the lock.
+var errLocked = errors.New("file is locked")
+
+// alreadyRunning tells that another process holds the run lock of the plan.
+type alreadyRunning struct {
+	holder string // pid and start of the holding run, empty when unknown
+}
+
+func (e alreadyRunning) Error() string {
+	if e.holder == "" {
+		return "Another run of the plan is already running, not taking any action"
+	}
+
+	return fmt.Sprintf("Another run of the plan is already running (%s), not taking any action", e.holder)
+}
+
+// runLock is an advisory lock on a file held for the whole run of a plan.
+// The operating system releases it when the process dies, a lock file left behind is stale and taken over.
+type runLock struct {
+	file *os.File
+}
+
+// acquireRunLock takes the lock or fails with alreadyRunning right away when another process holds it.
+// The file tells the pid and start of the holding run.
+func acquireRunLock(path string) (*runLock, bool, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, false, err
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err := lockFile(file); err != nil {
+		file.Close()
+
+		if errors.Is(err, errLocked) {
+			holder, _ := os.ReadFile(path)
+			return nil, false, alreadyRunning{holder: strings.TrimSpace(string(holder))}
+		}
+		return nil, false, err
+	}
+
+	// a previous holder died without cleaning up
+	previous, _ := os.ReadFile(path)
+	stale := len(strings.TrimSpace(string(previous))) > 0
+
+	holder := fmt.Sprintf("pid %d since %s\n", os.Getpid(), time.Now().Format(time.RFC3339))
+	if err := file.Truncate(0); err != nil {
+		file.Close()
+		return nil, false, err
+	}
+	if _, err := file.WriteAt([]byte(holder), 0); err != nil {
+		file.Close()
+		return nil, false, err
+	}
+
+	return &runLock{file: file}, stale, nil
+}
+
+// release clears the holder and unlocks the file.
+func (l *runLock) release() error {
+	defer l.file.Close()
+
+	if err := l.file.Truncate(0); err != nil {
+		return err
+	}
+
+	return unlockFile(l.file)
+}
+
+var unsafePlanChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// runLockPath returns the lock file of the plan on the exchange account in the locks directory.
+// Accounts are told apart by the api key, so plans on different accounts of an exchange run side by side.
+func runLockPath(dir string, req syncRequest) string {
+	return planFile(dir, req, "lock")
+}
+
+// planFile names a file of the plan on the exchange account with the extension.
+func planFile(dir string, req syncRequest, ext string) string {
+	plan := req.plan
+	if plan == "" {
+		plan = "default"
+	}
+
+	name := fmt.Sprintf("%s-%s-%s.%s", req.exchange, accountKey(req.exchange), plan, ext)
+	return filepath.Join(dir, unsafePlanChars.ReplaceAllString(name, "_"))
+}
+
+// accountKey is a short digest of the exchange's api key, paper trading is keyed by the plan's wallet.
+func accountKey(exchange string) string {
+	var key string
+	switch exchange {
+	case "coinbase":
+		key = os.Getenv("COINBASE_KEY")
+	case "gemini":
+		key = os.Getenv("GEMINI_KEY")
+	case "ftx", "ftxus":
+		key = os.Getenv("FTX_KEY")
+	default:
+		return "local"
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	return fmt.Sprintf("%x", sum[:4])
+}
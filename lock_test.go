@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "locks", "coinbase-local-default.lock")
+
+	t.Run("when another run holds the lock", func(t *testing.T) {
+		lock, stale, err := acquireRunLock(path)
+		assert.Nil(t, err)
+		assert.False(t, stale)
+
+		_, _, err = acquireRunLock(path)
+		assert.True(t, errors.As(err, &alreadyRunning{}))
+		assert.Contains(t, err.Error(), "already running (pid ")
+
+		assert.Nil(t, lock.release())
+
+		lock, stale, err = acquireRunLock(path)
+		assert.Nil(t, err)
+		assert.False(t, stale)
+		assert.Nil(t, lock.release())
+	})
+
+	t.Run("when the previous run died holding the lock", func(t *testing.T) {
+		assert.Nil(t, os.WriteFile(path, []byte("pid 1 since 2024-01-01T00:00:00Z\n"), 0600))
+
+		lock, stale, err := acquireRunLock(path)
+
+		assert.Nil(t, err)
+		assert.True(t, stale)
+		assert.Nil(t, lock.release())
+	})
+
+	t.Run("when sync runs while the lock is held", func(t *testing.T) {
+		lock, _, err := acquireRunLock(path)
+		assert.Nil(t, err)
+		defer lock.release()
+
+		s := gdaxSchedule{}
+		s.logger = loggerStub(t).Sugar()
+		s.lockPath = path
+
+		err = s.Sync()
+
+		assert.True(t, errors.As(err, &alreadyRunning{}))
+	})
+}
+
+func TestSyncSeesPurchasesOfOtherProcesses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.jsonl")
+
+	daemon, err := openLedger(path)
+	assert.Nil(t, err)
+
+	s := gdaxSchedule{}
+	s.logger = loggerStub(t).Sugar()
+	s.req = syncRequest{exchange: "coinbase", every: 24 * time.Hour, currency: "USD", usd: 50}
+	s.coins = map[string]orderDetails{"BTC": {symbol: "btcusd", amount: 50}}
+	s.ledger = daemon
+	s.lockPath = filepath.Join(t.TempDir(), "coinbase-local-default.lock")
+
+	//a cron run buys after the daemon opened the ledger
+	cron, err := openLedger(path)
+	assert.Nil(t, err)
+	assert.Nil(t, cron.record(ledgerEntry{Kind: ledgerOrder, Exchange: "coinbase", Currency: "USD", Coin: "BTC", Amount: 50}))
+
+	err = s.Sync()
+
+	assert.Equal(t, errNoWindow, err)
+	assert.Len(t, daemon.entries, 1)
+}
+
+func TestRunLockPath(t *testing.T) {
+	t.Setenv("COINBASE_KEY", "first")
+	first := runLockPath("locks", syncRequest{exchange: "coinbase"})
+
+	t.Setenv("COINBASE_KEY", "second")
+	second := runLockPath("locks", syncRequest{exchange: "coinbase"})
+
+	assert.NotEqual(t, first, second)
+	assert.NotEqual(t, second, runLockPath("locks", syncRequest{exchange: "coinbase", plan: "weekly"}))
+	assert.Equal(t, filepath.Join("locks", "paper-local-my_plan.lock"), runLockPath("locks", syncRequest{exchange: "paper", plan: "my plan"}))
+}
+
+func TestPaperWallet(t *testing.T) {
+	assert.Equal(t, filepath.Join("data", "paper.json"), paperWallet("data", ""))
+	assert.Equal(t, filepath.Join("data", "paper-weekly.json"), paperWallet("data", "weekly"))
+	assert.Equal(t, filepath.Join("data", "paper-.._etc_my_plan.json"), paperWallet("data", "../etc/my plan"))
+}
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"regexp"
 	"strconv"
+	"syscall"
 	"time"
 
 	"go.uber.org/zap"
@@ -15,20 +20,105 @@ import (
 )
 
 var (
+	runCmd = kingpin.Command(
+		"run",
+		"Check the purchase window once, trade if it is open and exit. Default command.",
+	).Default()
+
+	daemonCmd = kingpin.Command(
+		"daemon",
+		"Stay running and trade every time a purchase window opens.",
+	)
+
+	retry = registerGenerousDuration(daemonCmd.Flag(
+		"retry",
+		"How long the daemon waits after a run before checking the window again, e.g. 1h, 1d. Default: 1h",
+	).Default("1h"))
+
+	statusCmd = kingpin.Command(
+		"status",
+		"Show every coin's last purchase and next purchase window and the deposits in flight.",
+	)
+
+	balanceCmd = kingpin.Command(
+		"balance",
+		"Show the fiat and coin balances and their current value.",
+	)
+
+	historyCmd = kingpin.Command(
+		"history",
+		"List the latest filled orders from the ledger with their fill prices.",
+	)
+
+	historyLimit = historyCmd.Flag(
+		"limit",
+		"How many orders to list, 0 lists all. Default: 20",
+	).Default("20").Int()
+
+	planCmd = kingpin.Command(
+		"plan",
+		"Show what run would do right now, the orders with their limit price and size and the deposit, without placing anything.",
+	)
+
+	paymentMethodsCmd = kingpin.Command(
+		"payment-methods",
+		"List the payment methods linked to the exchange account and which one deposits in --currency use.",
+	)
+
+	backtestCmd = kingpin.Command(
+		"backtest",
+		"Replay the plan against historical daily candles on a simulated exchange and report the result.",
+	)
+
+	backtestFrom = registerDate(backtestCmd.Flag(
+		"from",
+		"First day of the backtest, e.g. 2020-01-01.",
+	).Required())
+
+	backtestTo = registerDate(backtestCmd.Flag(
+		"to",
+		"Last day of the backtest, e.g. 2021-12-31. Default: today",
+	))
+
+	candlesDir = backtestCmd.Flag(
+		"candles",
+		"Directory with daily candles in COIN-CURRENCY.csv files, e.g. BTC-USD.csv with time,open,high,low,close,volume lines. Default: fetch from --exchange once and cache in --data-dir",
+	).String()
+
+	configPath = kingpin.Flag(
+		"config",
+		"YAML file with named plans to evaluate together, each may set name, exchange, coins, usd, every, currency, after, until, type, spread, fee, autofund, payment-method, strategy, max-usd, fund-every, fund-usd, fund-buffer and fund-max-pending. Flags provide the settings a plan leaves out.",
+	).String()
+
 	exchangeType = kingpin.Flag(
 		"exchange",
-		"Exchange coinbase, gemini, ftx, ftxus. Default: coinbase",
+		"Exchange coinbase, gemini, ftx, ftxus or paper to trade with simulated money. Default: coinbase",
+	).Default("coinbase").String()
+
+	paperPrices = kingpin.Flag(
+		"paper-prices",
+		"Exchange paper trading takes prices from coinbase, gemini, ftx, ftxus. Default: coinbase",
 	).Default("coinbase").String()
 
+	paperCandles = kingpin.Flag(
+		"paper-candles",
+		"Directory with daily candles in COIN-CURRENCY.csv files paper trading replays instead of live prices.",
+	).String()
+
+	paperReplayFrom = registerDate(kingpin.Flag(
+		"paper-replay-from",
+		"Day paper trading replays prices from as if the first run happened on it, e.g. 2022-01-01. Candles come from --paper-candles or are fetched from --paper-prices once.",
+	))
+
 	coins = kingpin.Flag(
 		"coin",
-		"Which coin you want to buy: BTC, LTC, BCH.",
+		"Which coin you want to buy with percentage of --usd and optional own cadence, e.g. BTC:70 or ETH:30:4w.",
 	).Strings()
 
 	every = registerGenerousDuration(kingpin.Flag(
 		"every",
-		"How often to make purchases, e.g. 1h, 7d, 3w.",
-	).Required())
+		"How often to make purchases, e.g. 1h, 7d, 3w. Required without --config.",
+	))
 
 	usd = kingpin.Flag(
 		"usd",
@@ -57,9 +147,39 @@ var (
 
 	autoFund = kingpin.Flag(
 		"autofund",
-		"Automatically initiate ACH deposits.",
+		"Automatically initiate bank deposits, ACH, SEPA or wire in --currency.",
 	).Bool()
 
+	paymentMethod = kingpin.Flag(
+		"payment-method",
+		"Id or name of the payment method deposits use, see the payment-methods command. Default: the only one which deposits in --currency, the first ACH account of several",
+	).String()
+
+	caps = kingpin.Flag(
+		"cap",
+		"Most to spend in a calendar month or year across all plans, optionally on a single coin, e.g. --cap=1000/month --cap=BTC:5000/year. Totalled from the ledger's orders and fills, --force does not bypass it.",
+	).Strings()
+
+	fundEvery = registerGenerousDuration(kingpin.Flag(
+		"fund-every",
+		"Deposit on a funding plan of its own, e.g. 4w, 30d, and pay purchases from the balance instead of topping up the shortfall at buy time.",
+	))
+
+	fundUsd = kingpin.Flag(
+		"fund-usd",
+		"How much the funding plan deposits every --fund-every. Default: tops up the balance to --fund-buffer windows",
+	).Float()
+
+	fundBuffer = kingpin.Flag(
+		"fund-buffer",
+		"Upcoming purchase windows the balance and pending deposits should cover, the funding plan doesn't deposit while they do. Default: 0",
+	).Int()
+
+	fundMaxPending = kingpin.Flag(
+		"fund-max-pending",
+		"The most deposits outstanding before the funding plan deposits again, 0 does not limit. Default: 1",
+	).Default("1").Int()
+
 	force = kingpin.Flag(
 		"force",
 		"Execute trade regardless of the window. Use with caution every run will execute the trade",
@@ -67,7 +187,7 @@ var (
 
 	orderType = kingpin.Flag(
 		"type",
-		"Order type market, limit. Default: market",
+		"Order type market, limit or maker to bid post-only for lower fees and take the rest after --maker-timeout. Default: market",
 	).Default("market").String()
 
 	orderSpread = kingpin.Flag(
@@ -75,15 +195,120 @@ var (
 		"Percentage to add above ask price to get limit order executed. Default: 1.0",
 	).Default("1.0").Float()
 
-	fee = kingpin.Flag(
+	pricing = kingpin.Flag(
+		"pricing",
+		"How limit orders are priced: spread adds --spread to the best ask, book walks the order book to the price which fills the amount. Default: spread",
+	).Default("spread").Enum("spread", "book")
+
+	bookBuffer = kingpin.Flag(
+		"book-buffer",
+		"Percentage to add above the order book price which fills the amount with --pricing book. Default: 0.1",
+	).Default("0.1").Float()
+
+	maxSlippage = kingpin.Flag(
+		"max-slippage",
+		"With --pricing book do not trade when the average fill price is more than this percentage above the mid price, 0 does not check. Default: 1.0",
+	).Default("1.0").Float()
+
+	slices = kingpin.Flag(
+		"slices",
+		"Split every coin's purchase in this many orders spread over --slice-over, each at least the minimum purchase. Default: 1",
+	).Default("1").Int()
+
+	sliceOver = kingpin.Flag(
+		"slice-over",
+		"Period the slices of a purchase are spread over, e.g. 30m, 2h. Default: 1h",
+	).Default("1h").Duration()
+
+	sliceRandom = kingpin.Flag(
+		"slice-random",
+		"Place slices at random times of --slice-over instead of evenly.",
+	).Bool()
+
+	fee = registerOptionalFloat(kingpin.Flag(
 		"fee",
-		"Fee level to exclude from limit order amount. Default: 0.5",
-	).Default("0.5").Float()
+		"Fee percentage to exclude from limit order amount, overrides the account's maker and taker rates looked up from the exchange. Paper trading and backtests charge it. Default: 0.5 when the rates are unknown",
+	))
+
+	fillTimeout = kingpin.Flag(
+		"fill-timeout",
+		"How long to wait for an order to fill before reporting it as unfilled, e.g. 30s, 5m. 0 does not wait. Default: 2m",
+	).Default("2m").Duration()
+
+	depositWait = kingpin.Flag(
+		"deposit-wait",
+		"How long to wait for a deposit to be available before leaving the purchase to a later run, e.g. 30s, 15m. A deposit paid out later is not waited for. Default: 5m",
+	).Default("5m").Duration()
+
+	stuckAfter = kingpin.Flag(
+		"stuck-after",
+		"How long a Coinbase deposit may stay pending before it is considered stuck and no longer waited for, e.g. 36h, 72h. 0 waits for pending deposits forever. Default: 24h",
+	).Default("24h").Duration()
+
+	makerTimeout = kingpin.Flag(
+		"maker-timeout",
+		"How long a maker order waits at the bid before the rest is bought with a limit order at the ask, e.g. 10m, 1h. Default: 5m",
+	).Default("5m").Duration()
+
+	makerOffset = kingpin.Flag(
+		"maker-offset",
+		"Percentage below the best bid to place maker orders at. Default: 0",
+	).Default("0").Float()
+
+	reprices = kingpin.Flag(
+		"reprices",
+		"How many times to move a limit order still unfilled after --fill-timeout to the current ask. Default: 3",
+	).Default("3").Int()
+
+	unfilled = kingpin.Flag(
+		"unfilled",
+		"What to do with a limit order still unfilled after re-pricing: cancel and report the shortfall or buy the rest with a market order. Default: cancel",
+	).Default("cancel").Enum("cancel", "market")
+
+	strategyName = kingpin.Flag(
+		"strategy",
+		"How much to buy every window: fixed, value, dip. Default: fixed",
+	).Default("fixed").String()
+
+	maxUsd = kingpin.Flag(
+		"max-usd",
+		"The most value and dip strategies may spend in a single window, split between coins like --usd. Default: twice --usd",
+	).Float()
+
+	maPeriod = kingpin.Flag(
+		"ma",
+		"Moving average period in days for dip strategy. Default: 50",
+	).Default("50").Int()
+
+	maType = kingpin.Flag(
+		"ma-type",
+		"Moving average type for dip strategy sma, ema. Default: sma",
+	).Default("sma").String()
+
+	dipBands = kingpin.Flag(
+		"dip-band",
+		"Dip strategy band as percentage from the moving average and multiplier, e.g. --dip-band=-10:1.5 buys 1.5x when price is 10% or more below the average. Default: -20:2 -10:1.5 10:0.75 20:0.5",
+	).Default("-20:2", "-10:1.5", "10:0.75", "20:0.5").Strings()
+
+	dataDir = kingpin.Flag(
+		"data-dir",
+		"Directory to keep the ledger and other state in. Default: ~/.dcagdax",
+	).Default(defaultDataDir()).String()
+
+	useLedger = kingpin.Flag(
+		"ledger",
+		"Record deposits, orders and skipped windows in a local ledger and decide purchase windows from it. Use --no-ledger to rely on the exchange history only.",
+	).Default("true").Bool()
+
+	historyFallback = kingpin.Flag(
+		"exchange-history",
+		"Ask the exchange for the last purchase when the ledger has no record of the coin. Use --no-exchange-history to disable.",
+	).Default("true").Bool()
 )
 
 func main() {
 	kingpin.Version("0.1.1")
-	kingpin.Parse()
+	command := kingpin.Parse()
 
 	config := zap.NewProductionConfig()
 	config.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
@@ -91,58 +316,214 @@ func main() {
 	logger := l.Sugar()
 	defer logger.Sync()
 
-	exchange, err := initExchange(*exchangeType)
-	if err != nil {
-		logger.Error(err)
-		os.Exit(1)
+	if command == paymentMethodsCmd.FullCommand() {
+		exchange, err := initExchange(*exchangeType)
+		if err != nil {
+			logger.Error(err)
+			os.Exit(1)
+		}
+
+		if err := printPaymentMethods(os.Stdout, exchange, *currency, *paymentMethod); err != nil {
+			logger.Warn(err.Error())
+			os.Exit(1)
+		}
+		return
 	}
 
-	oType := exchanges.Market
-	switch *orderType {
-	case "market":
-		oType = exchanges.Market
-	case "limit":
-		oType = exchanges.Limit
-	default:
-		logger.Warn("unsupported order type " + *orderType)
+	oType, err := parseOrderType(*orderType)
+	if err != nil {
+		logger.Warn(err.Error())
 		os.Exit(1)
 	}
 
 	req := syncRequest{
-		autoFund:    *autoFund,
-		usd:         *usd,
-		orderType:   oType,
-		orderSpread: *orderSpread,
-		fee:         *fee,
-		every:       *every,
-		until:       *until,
-		after:       *after,
-		coins:       *coins,
-		force:       *force,
-		currency:    *currency,
-	}
-
-	schedule, err := newGdaxSchedule(
-		exchange,
-		logger,
-		!*makeTrades,
-		req,
-	)
+		exchange:        *exchangeType,
+		historyFallback: *historyFallback,
+		strategy:        *strategyName,
+		maxUsd:          *maxUsd,
+		maPeriod:        *maPeriod,
+		maType:          *maType,
+		dipBands:        *dipBands,
+		depositWait:     *depositWait,
+		fillTimeout:     *fillTimeout,
+		reprices:        *reprices,
+		unfilled:        *unfilled,
+		makerTimeout:    *makerTimeout,
+		makerOffset:     *makerOffset,
+		pricing:         *pricing,
+		bookBuffer:      *bookBuffer,
+		maxSlippage:     *maxSlippage,
+		slices:          *slices,
+		sliceOver:       *sliceOver,
+		sliceRandom:     *sliceRandom,
+		autoFund:        *autoFund,
+		paymentMethod:   *paymentMethod,
+		fundEvery:       *fundEvery,
+		fundUsd:         *fundUsd,
+		fundBuffer:      *fundBuffer,
+		fundMaxPending:  *fundMaxPending,
+		caps:            *caps,
+		usd:             *usd,
+		orderType:       oType,
+		orderSpread:     *orderSpread,
+		fee:             fee.valueOr(defaultFee),
+		lookupFees:      !fee.set,
+		every:           *every,
+		until:           *until,
+		after:           *after,
+		coins:           *coins,
+		force:           *force,
+		currency:        *currency,
+	}
 
-	if err != nil {
-		logger.Warn(err.Error())
+	requests := []syncRequest{req}
+	if *configPath != "" {
+		c, err := loadConfig(*configPath)
+		if err != nil {
+			logger.Warn(err.Error())
+			os.Exit(1)
+		}
+
+		if requests, err = c.requests(req); err != nil {
+			logger.Warn(err.Error())
+			os.Exit(1)
+		}
+	} else if *every == 0 {
+		logger.Warn("required flag --every not provided")
 		os.Exit(1)
 	}
 
-	if err := schedule.Sync(); err != nil {
-		logger.Warn(err.Error())
+	if command == backtestCmd.FullCommand() {
+		if *configPath != "" {
+			logger.Warn("backtest replays a single plan, use the flags instead of --config")
+			os.Exit(1)
+		}
+
+		if err := runBacktest(config, req); err != nil {
+			logger.Warn(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	var purchases *ledger
+	if *useLedger {
+		purchases, err = openLedger(filepath.Join(*dataDir, "ledger.jsonl"))
+		if err != nil {
+			logger.Warn(err.Error())
+			os.Exit(1)
+		}
+	}
+
+	// plans on the same exchange share its client
+	clients := map[string]exchanges.Exchange{}
+	schedules := []*gdaxSchedule{}
+
+	for _, r := range requests {
+		var exchange exchanges.Exchange
+		if r.exchange == "paper" {
+			exchange, err = initPaper(r)
+		} else if exchange = clients[r.exchange]; exchange == nil {
+			exchange, err = initExchange(r.exchange)
+			clients[r.exchange] = exchange
+		}
+		if err != nil {
+			logger.Error(err)
+			os.Exit(1)
+		}
+
+		planLogger := logger
+		if r.plan != "" {
+			planLogger = logger.With("plan", r.plan)
+		}
+
+		schedule, err := newGdaxSchedule(
+			exchange,
+			planLogger,
+			// paper trading moves no real money, so it always trades
+			!*makeTrades && r.exchange != "paper",
+			r,
+			purchases,
+		)
+
+		if err != nil {
+			planLogger.Warn(err.Error())
+			os.Exit(1)
+		}
+
+		schedule.lockPath = runLockPath(filepath.Join(*dataDir, "locks"), r)
+		schedule.statePath = runStatePath(filepath.Join(*dataDir, "runs"), r)
+		schedules = append(schedules, schedule)
+	}
+
+	// a run in progress stops at its next wait on shutdown instead of being killed in the middle of an order
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	switch command {
+	case daemonCmd.FullCommand():
+		if *force {
+			logger.Warn("--force cannot be used in daemon mode")
+			os.Exit(1)
+		}
+
+		if err := runDaemon(ctx, schedules, *retry); err != nil {
+			logger.Warn(err.Error())
+			os.Exit(1)
+		}
+	case runCmd.FullCommand():
+		running := false
+		for _, schedule := range schedules {
+			if ctx.Err() != nil {
+				break
+			}
+
+			schedule.ctx = ctx
+			if err := schedule.Sync(); err != nil {
+				schedule.logger.Warn(err.Error())
+				running = running || errors.As(err, &alreadyRunning{})
+			}
+		}
+
+		if running {
+			os.Exit(exitAlreadyRunning)
+		}
+	case statusCmd.FullCommand(), balanceCmd.FullCommand(), historyCmd.FullCommand(), planCmd.FullCommand():
+		for i, schedule := range schedules {
+			if i > 0 {
+				fmt.Println()
+			}
+
+			if err := printCommand(command, schedule); err != nil {
+				schedule.logger.Warn(err.Error())
+				os.Exit(1)
+			}
+		}
+	}
+}
+
+// printCommand runs one of the read only commands for the schedule.
+func printCommand(command string, schedule *gdaxSchedule) error {
+	switch command {
+	case statusCmd.FullCommand():
+		return schedule.status(os.Stdout)
+	case balanceCmd.FullCommand():
+		return schedule.balance(os.Stdout)
+	case historyCmd.FullCommand():
+		return schedule.history(os.Stdout, *historyLimit)
+	default:
+		return schedule.preview(os.Stdout)
 	}
 }
 
 func initExchange(exType string) (exchange exchanges.Exchange, err error) {
 	switch exType {
 	case "coinbase":
-		exchange, err = exchanges.NewCoinbaseV3()
+		var c *exchanges.CoinbaseV3
+		if c, err = exchanges.NewCoinbaseV3(); err == nil {
+			c.SetStuckAfter(*stuckAfter)
+			exchange = c
+		}
 	case "gemini":
 		exchange, err = exchanges.NewGemini()
 	case "ftxus":
@@ -155,6 +536,15 @@ func initExchange(exType string) (exchange exchanges.Exchange, err error) {
 	return exchange, err
 }
 
+func defaultDataDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".dcagdax"
+	}
+
+	return filepath.Join(home, ".dcagdax")
+}
+
 type generousDuration time.Duration
 
 func registerGenerousDuration(s kingpin.Settings) (target *time.Duration) {
@@ -193,6 +583,48 @@ func (d *generousDuration) String() string {
 	return (*time.Duration)(d).String()
 }
 
+// exitAlreadyRunning is the exit status of run when another process is running the plan.
+const exitAlreadyRunning = 3
+
+// defaultFee is the fee percentage when --fee is not given and the exchange doesn't tell the account's rates.
+const defaultFee = 0.5
+
+// optionalFloat is a float flag which tells whether it was given.
+type optionalFloat struct {
+	value float64
+	set   bool
+}
+
+func (f *optionalFloat) Set(value string) error {
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return err
+	}
+
+	f.value = v
+	f.set = true
+
+	return nil
+}
+
+func (f *optionalFloat) String() string {
+	return strconv.FormatFloat(f.value, 'f', -1, 64)
+}
+
+func (f *optionalFloat) valueOr(fallback float64) float64 {
+	if !f.set {
+		return fallback
+	}
+
+	return f.value
+}
+
+func registerOptionalFloat(s kingpin.Settings) (target *optionalFloat) {
+	target = &optionalFloat{}
+	s.SetValue(target)
+	return target
+}
+
 type date time.Time
 
 func (d *date) Set(value string) error {
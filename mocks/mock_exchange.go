@@ -10,6 +10,7 @@ import (
 
 	gomock "github.com/golang/mock/gomock"
 	exchanges "github.com/sberserker/dcagdax/exchanges"
+	decimal "github.com/shopspring/decimal"
 )
 
 // MockExchange is a mock of Exchange interface.
@@ -35,34 +36,109 @@ func (m *MockExchange) EXPECT() *MockExchangeMockRecorder {
 	return m.recorder
 }
 
+// CancelOrder mocks base method.
+func (m *MockExchange) CancelOrder(arg0, arg1 string) (*exchanges.Order, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CancelOrder", arg0, arg1)
+	ret0, _ := ret[0].(*exchanges.Order)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CancelOrder indicates an expected call of CancelOrder.
+func (mr *MockExchangeMockRecorder) CancelOrder(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CancelOrder", reflect.TypeOf((*MockExchange)(nil).CancelOrder), arg0, arg1)
+}
+
 // CreateOrder mocks base method.
-func (m *MockExchange) CreateOrder(arg0 string, arg1 float64, arg2 exchanges.OrderTypeType, arg3 exchanges.CalcLimitOrder) (*exchanges.Order, error) {
+func (m *MockExchange) CreateOrder(arg0, arg1 string, arg2 float64, arg3 exchanges.OrderTypeType, arg4 exchanges.CalcLimitOrder) (*exchanges.Order, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "CreateOrder", arg0, arg1, arg2, arg3)
+	ret := m.ctrl.Call(m, "CreateOrder", arg0, arg1, arg2, arg3, arg4)
 	ret0, _ := ret[0].(*exchanges.Order)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // CreateOrder indicates an expected call of CreateOrder.
-func (mr *MockExchangeMockRecorder) CreateOrder(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+func (mr *MockExchangeMockRecorder) CreateOrder(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateOrder", reflect.TypeOf((*MockExchange)(nil).CreateOrder), arg0, arg1, arg2, arg3)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateOrder", reflect.TypeOf((*MockExchange)(nil).CreateOrder), arg0, arg1, arg2, arg3, arg4)
 }
 
 // Deposit mocks base method.
-func (m *MockExchange) Deposit(arg0 string, arg1 float64) (*time.Time, error) {
+func (m *MockExchange) Deposit(arg0 string, arg1 float64, arg2 string) (*time.Time, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Deposit", arg0, arg1)
+	ret := m.ctrl.Call(m, "Deposit", arg0, arg1, arg2)
 	ret0, _ := ret[0].(*time.Time)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Deposit indicates an expected call of Deposit.
-func (mr *MockExchangeMockRecorder) Deposit(arg0, arg1 interface{}) *gomock.Call {
+func (mr *MockExchangeMockRecorder) Deposit(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Deposit", reflect.TypeOf((*MockExchange)(nil).Deposit), arg0, arg1, arg2)
+}
+
+// EditOrder mocks base method.
+func (m *MockExchange) EditOrder(arg0, arg1 string, arg2, arg3 decimal.Decimal) (*exchanges.Order, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "EditOrder", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(*exchanges.Order)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// EditOrder indicates an expected call of EditOrder.
+func (mr *MockExchangeMockRecorder) EditOrder(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EditOrder", reflect.TypeOf((*MockExchange)(nil).EditOrder), arg0, arg1, arg2, arg3)
+}
+
+// GetCandles mocks base method.
+func (m *MockExchange) GetCandles(arg0 string, arg1, arg2 time.Time) ([]exchanges.Candle, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetCandles", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]exchanges.Candle)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetCandles indicates an expected call of GetCandles.
+func (mr *MockExchangeMockRecorder) GetCandles(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCandles", reflect.TypeOf((*MockExchange)(nil).GetCandles), arg0, arg1, arg2)
+}
+
+// GetCryptoAccount mocks base method.
+func (m *MockExchange) GetCryptoAccount(arg0 string) (*exchanges.Account, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetCryptoAccount", arg0)
+	ret0, _ := ret[0].(*exchanges.Account)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetCryptoAccount indicates an expected call of GetCryptoAccount.
+func (mr *MockExchangeMockRecorder) GetCryptoAccount(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCryptoAccount", reflect.TypeOf((*MockExchange)(nil).GetCryptoAccount), arg0)
+}
+
+// GetFeeRates mocks base method.
+func (m *MockExchange) GetFeeRates() (*exchanges.FeeRates, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetFeeRates")
+	ret0, _ := ret[0].(*exchanges.FeeRates)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetFeeRates indicates an expected call of GetFeeRates.
+func (mr *MockExchangeMockRecorder) GetFeeRates() *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Deposit", reflect.TypeOf((*MockExchange)(nil).Deposit), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetFeeRates", reflect.TypeOf((*MockExchange)(nil).GetFeeRates))
 }
 
 // GetFiatAccount mocks base method.
@@ -80,6 +156,51 @@ func (mr *MockExchangeMockRecorder) GetFiatAccount(arg0 interface{}) *gomock.Cal
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetFiatAccount", reflect.TypeOf((*MockExchange)(nil).GetFiatAccount), arg0)
 }
 
+// GetOrder mocks base method.
+func (m *MockExchange) GetOrder(arg0, arg1 string) (*exchanges.Order, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetOrder", arg0, arg1)
+	ret0, _ := ret[0].(*exchanges.Order)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetOrder indicates an expected call of GetOrder.
+func (mr *MockExchangeMockRecorder) GetOrder(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrder", reflect.TypeOf((*MockExchange)(nil).GetOrder), arg0, arg1)
+}
+
+// GetOrderBook mocks base method.
+func (m *MockExchange) GetOrderBook(arg0 string) (*exchanges.OrderBook, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetOrderBook", arg0)
+	ret0, _ := ret[0].(*exchanges.OrderBook)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetOrderBook indicates an expected call of GetOrderBook.
+func (mr *MockExchangeMockRecorder) GetOrderBook(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrderBook", reflect.TypeOf((*MockExchange)(nil).GetOrderBook), arg0)
+}
+
+// GetPaymentMethods mocks base method.
+func (m *MockExchange) GetPaymentMethods() ([]exchanges.PaymentMethod, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetPaymentMethods")
+	ret0, _ := ret[0].([]exchanges.PaymentMethod)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetPaymentMethods indicates an expected call of GetPaymentMethods.
+func (mr *MockExchangeMockRecorder) GetPaymentMethods() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPaymentMethods", reflect.TypeOf((*MockExchange)(nil).GetPaymentMethods))
+}
+
 // GetPendingTransfers mocks base method.
 func (m *MockExchange) GetPendingTransfers(arg0 string) ([]exchanges.PendingTransfer, error) {
 	m.ctrl.T.Helper()
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/sberserker/dcagdax/exchanges"
+)
+
+// initPaper creates the paper trading exchange, its state is kept in the data directory between runs.
+func initPaper(req syncRequest) (exchanges.Exchange, error) {
+	if *paperPrices == "paper" {
+		return nil, errors.New("--paper-prices must be a real exchange")
+	}
+
+	var live exchanges.Exchange
+	fetch := func() (exchanges.Exchange, error) {
+		if live == nil {
+			var err error
+			if live, err = initExchange(*paperPrices); err != nil {
+				return nil, err
+			}
+		}
+		return live, nil
+	}
+
+	var prices exchanges.PriceSource
+
+	if *paperCandles == "" && paperReplayFrom.IsZero() {
+		exchange, err := fetch()
+		if err != nil {
+			return nil, err
+		}
+		prices = exchanges.NewLivePrices(exchange)
+	} else {
+		source := candleSource{
+			dir:      *paperCandles,
+			cacheDir: filepath.Join(*dataDir, "candles", *paperPrices),
+			currency: req.currency,
+			fetch:    fetch,
+		}
+
+		start := time.Now()
+		if !paperReplayFrom.IsZero() {
+			start = *paperReplayFrom
+		}
+
+		candles := map[string][]exchanges.Candle{}
+		for _, c := range req.coins {
+			coin := planCoin(c)
+			coinCandles, err := source.load(coin, candleHistoryStart(req, start), time.Now())
+			if err != nil {
+				return nil, fmt.Errorf("cannot load %s candles: %w", coin, err)
+			}
+			candles[coin+"-"+req.currency] = coinCandles
+		}
+		prices = exchanges.NewCandlePrices(candles)
+	}
+
+	return exchanges.NewPaper(paperWallet(*dataDir, req.plan), req.currency, req.fee, prices, *paperReplayFrom)
+}
+
+// paperWallet names the file of the plan's simulated balance, every named plan trades its own.
+func paperWallet(dir string, plan string) string {
+	if plan == "" {
+		return filepath.Join(dir, "paper.json")
+	}
+
+	return filepath.Join(dir, "paper-"+unsafePlanChars.ReplaceAllString(plan, "_")+".json")
+}
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// runPhase is the step of a Sync run, the run moves through them in order and may stop in any of them.
+type runPhase string
+
+const (
+	phaseWindowOpen       runPhase = "window-open"       // due coins and amounts are decided
+	phaseDepositInitiated runPhase = "deposit-initiated" // a deposit is about to be made
+	phaseAwaitingFunds    runPhase = "awaiting-funds"    // the deposit is made, waiting for the money to be available
+	phaseOrdering         runPhase = "ordering"          // placing the orders of the window
+	phaseReconciling      runPhase = "reconciling"       // orders are placed, summing up what they executed
+	phaseDone             runPhase = "done"
+)
+
+// depositPollInterval is how often the balance is checked while waiting for a deposit.
+const depositPollInterval = 30 * time.Second
+
+// errAwaitingFunds tells that the run stopped to wait for its deposit, the state keeps the decisions it made.
+var errAwaitingFunds = errors.New("Waiting for the deposit to settle, a later run continues the purchase")
+
+// runState is the progress of a Sync run, persisted after every step.
+// A later run continues an unfinished one with the same windows and amounts instead of deciding them again.
+type runState struct {
+	Phase    runPhase             `json:"phase"`
+	Started  time.Time            `json:"started"`
+	Updated  time.Time            `json:"updated"`
+	Due      []string             `json:"due"`
+	Windows  map[string]time.Time `json:"windows"`
+	Amounts  map[string]float64   `json:"amounts"`
+	Deposit  float64              `json:"deposit,omitempty"`   // amount deposited for the window
+	PayoutAt *time.Time           `json:"payout_at,omitempty"` // when the exchange expects the deposit to be available
+	Orders   map[string][]string  `json:"orders,omitempty"`    // order ids of every coin once ordering is over
+
+	resumed   bool       // continued from a previous run
+	purchases []purchase // what ordering executed in this run
+}
+
+// total is the amount the window spends on all coins.
+func (r *runState) total() float64 {
+	total := decimal.Zero
+	for _, amount := range r.Amounts {
+		total = total.Add(decimal.NewFromFloat(amount))
+	}
+
+	t, _ := total.Float64()
+	return t
+}
+
+// runStatePath returns the state file of the plan on the exchange account in the runs directory.
+func runStatePath(dir string, req syncRequest) string {
+	return planFile(dir, req, "json")
+}
+
+// loadRunState reads the state of the last run, nil when there was none.
+func loadRunState(path string) (*runState, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var state runState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("run state %s is corrupted: %w", path, err)
+	}
+
+	return &state, nil
+}
+
+// saveState persists the state of the run, dry runs and schedules without a state path keep it in memory only.
+func (s *gdaxSchedule) saveState(state *runState) error {
+	if s.statePath == "" || s.debug {
+		return nil
+	}
+
+	state.Updated = s.now()
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.statePath), 0700); err != nil {
+		return err
+	}
+
+	tmp := s.statePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.statePath)
+}
+
+// resumeState returns the unfinished run to continue, nil when a new run decides from scratch.
+// A run unfinished for longer than the cadence of its coins is abandoned as their next window has opened.
+func (s *gdaxSchedule) resumeState(now time.Time) (*runState, error) {
+	if s.statePath == "" || s.debug {
+		return nil, nil
+	}
+
+	state, err := loadRunState(s.statePath)
+	if err != nil || state == nil || state.Phase == phaseDone {
+		return nil, err
+	}
+
+	every := s.req.every
+	for _, coin := range state.Due {
+		if coinEvery := s.everyFor(coin); coinEvery < every {
+			every = coinEvery
+		}
+	}
+
+	if now.Sub(state.Started) >= every {
+		s.logger.Warnw(
+			"Abandoning an unfinished run, its window has passed",
+			"phase", state.Phase,
+			"started", state.Started,
+		)
+		return nil, nil
+	}
+
+	s.logger.Infow(
+		"Resuming an unfinished run",
+		"phase", state.Phase,
+		"started", state.Started,
+	)
+
+	state.resumed = true
+	s.windows = state.Windows
+
+	return state, nil
+}
+
+// openWindow decides the coins due and the amounts to buy, the first step of a new run.
+func (s *gdaxSchedule) openWindow(now time.Time) (*runState, error) {
+	due := []string{}
+
+	if s.req.force != true {
+		var err error
+		if due, err = s.dueCoins(now); err != nil {
+			return nil, err
+		}
+
+		if len(due) == 0 {
+			return nil, errNoWindow
+		}
+	} else {
+		c := s.confirmFunc("Force method is used proceed?")
+		if !c {
+			return nil, errors.New("User rejected the trade")
+		}
+
+		due = s.coinNames()
+
+		//a forced purchase is a window of its own
+		s.windows = map[string]time.Time{}
+		for _, coin := range due {
+			s.windows[coin] = now
+		}
+	}
+
+	amounts, err := s.purchaseAmounts(due, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(amounts) == 0 {
+		return nil, errors.New("Strategy decided not to buy anything this window")
+	}
+
+	//caps hold for forced purchases too
+	if amounts = s.applyCaps(due, amounts, now); len(amounts) == 0 {
+		return nil, errors.New("Spending caps leave nothing to spend this window")
+	}
+
+	return &runState{
+		Phase:   phaseWindowOpen,
+		Started: now,
+		Due:     due,
+		Windows: s.windows,
+		Amounts: amounts,
+	}, nil
+}
+
+// advance moves the run through its phases until it is done or has to stop, the state is saved after every step.
+func (s *gdaxSchedule) advance(state *runState) error {
+	for state.Phase != phaseDone {
+		var err error
+
+		switch state.Phase {
+		case phaseWindowOpen:
+			err = s.checkFunds(state)
+		case phaseDepositInitiated:
+			err = s.initiateDeposit(state)
+		case phaseAwaitingFunds:
+			err = s.awaitFunds(state)
+		case phaseOrdering:
+			err = s.order(state)
+		case phaseReconciling:
+			err = s.reconcile(state)
+		default:
+			return fmt.Errorf("run state has an unknown phase %s", state.Phase)
+		}
+
+		if saveErr := s.saveState(state); saveErr != nil {
+			s.logger.Warnw("Failed to save the run state", "path", s.statePath, "error", saveErr.Error())
+			if err == nil {
+				return saveErr
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkFunds tells whether the window can be bought with the available balance or needs a deposit.
+func (s *gdaxSchedule) checkFunds(state *runState) error {
+	needed, err := s.additionalUsdNeeded(state.total())
+	if err != nil {
+		return err
+	}
+
+	if needed <= 0 {
+		state.Phase = phaseOrdering
+		return nil
+	}
+
+	//check if there are pending transfers
+	//typically pending transfers means something is stuck, need to wait to settle or resolve the issue
+	pending, err := s.pendingTransfers()
+	if err != nil {
+		return err
+	}
+
+	if s.fundingPlan() {
+		//the funding plan's deposits pay for the windows, nothing is topped up at buy time
+		if pending >= needed {
+			s.logger.Infow(
+				"Waiting for the funding plan's deposit",
+				"needed", needed,
+				"pending", pending,
+			)
+			state.Phase = phaseAwaitingFunds
+			return nil
+		}
+
+		state.Phase = phaseDone
+		return s.skip(fmt.Sprintf(
+			"No sufficient amount for trade, the funding plan deposits next at %s",
+			s.nextFunding(s.now()).Local().Format(timeFormat),
+		))
+	}
+
+	if pending > 0 {
+		state.Phase = phaseDone
+		return s.skip("Wait for transfers to settle")
+	}
+
+	s.logger.Infow(
+		"Insufficient funds",
+		"needed", needed,
+	)
+
+	if !s.req.autoFund {
+		state.Phase = phaseDone
+		return s.skip("No sufficient amount for trade and autofund is disabled. Deposit money to proceed")
+	}
+
+	//saved before the deposit is made so a crash in between doesn't deposit twice
+	state.Deposit = needed
+	state.Phase = phaseDepositInitiated
+	return nil
+}
+
+// initiateDeposit deposits the missing amount.
+// A resumed run cannot tell whether the previous one got to deposit, it waits for the funds rather than risk depositing twice.
+func (s *gdaxSchedule) initiateDeposit(state *runState) error {
+	if state.resumed {
+		s.logger.Warnw(
+			"The previous run stopped while depositing, waiting for its deposit instead of depositing again",
+			"amount", state.Deposit,
+		)
+		state.Phase = phaseAwaitingFunds
+		return nil
+	}
+
+	payoutAt, err := s.fund(state.Deposit)
+	if err != nil {
+		//nothing was deposited, a later run checks the funds again
+		state.Phase = phaseWindowOpen
+		return err
+	}
+
+	state.PayoutAt = payoutAt
+	state.Phase = phaseAwaitingFunds
+	return nil
+}
+
+// awaitFunds polls the balance and the deposits until the deposit is available or --deposit-wait passes.
+// A deposit the exchange pays out after the deadline is not waited for, the run exits and a later run continues the purchase.
+func (s *gdaxSchedule) awaitFunds(state *runState) error {
+	if s.debug {
+		//nothing was deposited for debug
+		state.Phase = phaseOrdering
+		return nil
+	}
+
+	wait := s.req.depositWait
+	if state.PayoutAt != nil && state.PayoutAt.After(s.now().Add(wait)) {
+		wait = 0
+	}
+
+	total := state.total()
+	for waited := time.Duration(0); ; waited += depositPollInterval {
+		needed, err := s.additionalUsdNeeded(total)
+		if err != nil {
+			return err
+		}
+
+		if needed <= 0 {
+			state.Phase = phaseOrdering
+			return nil
+		}
+
+		if waited >= wait {
+			s.logger.Infow(
+				"Deposit is not available yet. Exiting now",
+				"needed", needed,
+				"payout", state.PayoutAt,
+			)
+			return errAwaitingFunds
+		}
+
+		pending, err := s.pendingTransfers()
+		if err != nil {
+			return err
+		}
+
+		if pending == 0 {
+			//the deposit may have completed since the balance was checked
+			if needed, err = s.additionalUsdNeeded(total); err != nil {
+				return err
+			}
+
+			if needed <= 0 {
+				state.Phase = phaseOrdering
+				return nil
+			}
+
+			state.Phase = phaseDone
+			return s.skip("Deposit is no longer pending and the funds are still short, check the deposit on the exchange")
+		}
+
+		s.logger.Infow(
+			"Waiting for the deposit",
+			"needed", needed,
+			"pending", pending,
+			"payout", state.PayoutAt,
+		)
+		if err := s.wait(depositPollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// order places the orders of the window.
+// Client order ids derive from the persisted windows, so a run resumed while ordering gets back the orders already placed.
+// A run cancelled between slices stays in ordering and a later run places the rest.
+func (s *gdaxSchedule) order(state *runState) error {
+	s.windows = state.Windows
+	purchases, err := s.purchase(state.Due, state.Amounts)
+	state.purchases = purchases
+
+	state.Orders = map[string][]string{}
+	for _, p := range state.purchases {
+		state.Orders[p.coin] = p.orderIds
+	}
+
+	if err != nil {
+		return err
+	}
+
+	state.Phase = phaseReconciling
+	return nil
+}
+
+// reconcile sums up what the orders executed, a resumed run looks the orders up on the exchange and records their fills.
+func (s *gdaxSchedule) reconcile(state *runState) error {
+	purchases := state.purchases
+	if state.resumed && purchases == nil {
+		for _, coin := range state.Due {
+			ids := state.Orders[coin]
+			if len(ids) == 0 {
+				continue
+			}
+
+			e := &execution{coin: coin, productId: s.coins[coin].symbol, amount: state.Amounts[coin]}
+			for _, id := range ids {
+				order, err := s.exchange.GetOrder(e.productId, id)
+				if err != nil {
+					return err
+				}
+				s.recordFill(coin, order)
+				e.orders = append(e.orders, order)
+			}
+
+			purchases = append(purchases, purchase{coin: coin, amount: e.amount, order: e.result(), orderIds: ids})
+		}
+	}
+
+	s.summarize(purchases)
+
+	state.Phase = phaseDone
+	return nil
+}
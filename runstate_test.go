@@ -0,0 +1,350 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sberserker/dcagdax/exchanges"
+	"github.com/sberserker/dcagdax/mocks"
+)
+
+func TestSyncResumesUnfinishedRun(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockExchange(ctrl)
+
+	s := gdaxSchedule{}
+	s.logger = loggerStub(t).Sugar()
+	s.req = syncRequest{every: 24 * time.Hour, orderType: exchanges.Market, autoFund: true, currency: "USD", usd: 50}
+	s.coins = map[string]orderDetails{"BTC": {symbol: "btcusd", amount: 50}}
+	s.sleepFunc = func(d time.Duration) {}
+	s.exchange = m
+	s.statePath = filepath.Join(t.TempDir(), "runs", "coinbase-local-default.json")
+
+	now := time.Now()
+	var clientOrderId string
+
+	t.Run("when the deposit is not available yet", func(t *testing.T) {
+		m.EXPECT().LastPurchaseTime("BTC", "USD", gomock.Any()).Return(nil, nil)
+		m.EXPECT().GetFiatAccount("USD").Return(&exchanges.Account{Available: 25}, nil)
+		m.EXPECT().GetPendingTransfers("USD").Return([]exchanges.PendingTransfer{}, nil)
+		m.EXPECT().Deposit("USD", 25.0, "").Return(&now, nil)
+		m.EXPECT().GetFiatAccount("USD").Return(&exchanges.Account{Available: 25}, nil)
+
+		err := s.Sync()
+
+		assert.Equal(t, errAwaitingFunds, err)
+
+		state, err := loadRunState(s.statePath)
+		assert.Nil(t, err)
+		assert.Equal(t, phaseAwaitingFunds, state.Phase)
+		assert.Equal(t, 25.0, state.Deposit)
+		assert.Equal(t, map[string]float64{"BTC": 50}, state.Amounts)
+	})
+
+	t.Run("when a later run finds the funds", func(t *testing.T) {
+		result := exchanges.Order{OrderID: "1"}
+
+		m.EXPECT().GetFiatAccount("USD").Return(&exchanges.Account{Available: 50}, nil)
+		m.EXPECT().CreateOrder("btcusd", gomock.Any(), 50.0, exchanges.Market, gomock.Any()).DoAndReturn(
+			func(productId string, id string, amount float64, orderType exchanges.OrderTypeType, calc exchanges.CalcLimitOrder) (*exchanges.Order, error) {
+				clientOrderId = id
+				return &result, nil
+			})
+
+		err := s.Sync()
+
+		assert.Nil(t, err)
+
+		state, err := loadRunState(s.statePath)
+		assert.Nil(t, err)
+		assert.Equal(t, phaseDone, state.Phase)
+		assert.Equal(t, map[string][]string{"BTC": {"1"}}, state.Orders)
+	})
+
+	t.Run("when the run stopped while ordering", func(t *testing.T) {
+		state, _ := loadRunState(s.statePath)
+		state.Phase = phaseOrdering
+		assert.Nil(t, s.saveState(state))
+
+		result := exchanges.Order{OrderID: "1"}
+		m.EXPECT().CreateOrder("btcusd", gomock.Any(), 50.0, exchanges.Market, gomock.Any()).DoAndReturn(
+			func(productId string, id string, amount float64, orderType exchanges.OrderTypeType, calc exchanges.CalcLimitOrder) (*exchanges.Order, error) {
+				//the same window gets back the order already placed
+				assert.Equal(t, clientOrderId, id)
+				return &result, nil
+			})
+
+		assert.Nil(t, s.Sync())
+	})
+
+	t.Run("when the run stopped while reconciling", func(t *testing.T) {
+		state, _ := loadRunState(s.statePath)
+		state.Phase = phaseReconciling
+		assert.Nil(t, s.saveState(state))
+
+		m.EXPECT().GetOrder("btcusd", "1").Return(&exchanges.Order{OrderID: "1", FilledSize: 0.5, AveragePrice: 100}, nil)
+
+		assert.Nil(t, s.Sync())
+
+		state, _ = loadRunState(s.statePath)
+		assert.Equal(t, phaseDone, state.Phase)
+	})
+
+	t.Run("when the unfinished run's window has passed", func(t *testing.T) {
+		state, _ := loadRunState(s.statePath)
+		state.Phase = phaseAwaitingFunds
+		state.Started = now.Add(-25 * time.Hour)
+		assert.Nil(t, s.saveState(state))
+
+		m.EXPECT().LastPurchaseTime("BTC", "USD", gomock.Any()).Return(nil, nil)
+		m.EXPECT().GetFiatAccount("USD").Return(&exchanges.Account{Available: 25}, nil)
+		m.EXPECT().GetPendingTransfers("USD").Return([]exchanges.PendingTransfer{{Amount: 25}}, nil)
+
+		err := s.Sync()
+
+		assert.Equal(t, "Wait for transfers to settle", err.Error())
+
+		state, _ = loadRunState(s.statePath)
+		assert.Equal(t, phaseDone, state.Phase)
+	})
+}
+
+func TestSyncDoesNotDepositTwiceWhenResumed(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockExchange(ctrl)
+
+	s := gdaxSchedule{}
+	s.logger = loggerStub(t).Sugar()
+	s.req = syncRequest{every: 24 * time.Hour, orderType: exchanges.Market, autoFund: true, currency: "USD", usd: 50}
+	s.coins = map[string]orderDetails{"BTC": {symbol: "btcusd", amount: 50}}
+	s.sleepFunc = func(d time.Duration) {}
+	s.exchange = m
+	s.statePath = filepath.Join(t.TempDir(), "coinbase-local-default.json")
+
+	state := &runState{
+		Phase:   phaseDepositInitiated,
+		Started: time.Now().Add(-time.Hour),
+		Due:     []string{"BTC"},
+		Windows: map[string]time.Time{"BTC": time.Now().Add(-time.Hour)},
+		Amounts: map[string]float64{"BTC": 50},
+		Deposit: 25,
+	}
+	assert.Nil(t, s.saveState(state))
+
+	m.EXPECT().GetFiatAccount("USD").Return(&exchanges.Account{Available: 25}, nil)
+
+	err := s.Sync()
+
+	assert.Equal(t, errAwaitingFunds, err)
+
+	state, _ = loadRunState(s.statePath)
+	assert.Equal(t, phaseAwaitingFunds, state.Phase)
+}
+
+func TestSyncPollsForTheDeposit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockExchange(ctrl)
+
+	var slept time.Duration
+
+	s := gdaxSchedule{}
+	s.logger = loggerStub(t).Sugar()
+	s.req = syncRequest{every: 24 * time.Hour, orderType: exchanges.Market, autoFund: true, currency: "USD", usd: 50, depositWait: time.Minute, force: true}
+	s.coins = map[string]orderDetails{"BTC": {symbol: "btcusd", amount: 50}}
+	s.sleepFunc = func(d time.Duration) { slept += d }
+	s.confirmFunc = func(string) bool { return true }
+	s.exchange = m
+
+	inFlight := []exchanges.PendingTransfer{{Amount: 25}}
+
+	t.Run("when the deposit becomes available", func(t *testing.T) {
+		slept = 0
+		now := time.Now()
+		result := exchanges.Order{OrderID: "1"}
+
+		gomock.InOrder(
+			m.EXPECT().GetFiatAccount("USD").Return(&exchanges.Account{Available: 25}, nil),
+			m.EXPECT().GetPendingTransfers("USD").Return(nil, nil),
+			m.EXPECT().Deposit("USD", 25.0, "").Return(&now, nil),
+			m.EXPECT().GetFiatAccount("USD").Return(&exchanges.Account{Available: 25}, nil),
+			m.EXPECT().GetPendingTransfers("USD").Return(inFlight, nil),
+			m.EXPECT().GetFiatAccount("USD").Return(&exchanges.Account{Available: 50}, nil),
+			m.EXPECT().CreateOrder("btcusd", gomock.Any(), 50.0, exchanges.Market, gomock.Any()).Return(&result, nil),
+		)
+
+		assert.Nil(t, s.Sync())
+		assert.Equal(t, depositPollInterval, slept)
+	})
+
+	t.Run("when the deadline passes", func(t *testing.T) {
+		slept = 0
+		now := time.Now()
+
+		m.EXPECT().GetFiatAccount("USD").Return(&exchanges.Account{Available: 25}, nil).Times(4)
+		m.EXPECT().GetPendingTransfers("USD").Return(nil, nil)
+		m.EXPECT().Deposit("USD", 25.0, "").Return(&now, nil)
+		m.EXPECT().GetPendingTransfers("USD").Return(inFlight, nil).Times(2)
+
+		assert.Equal(t, errAwaitingFunds, s.Sync())
+		assert.Equal(t, time.Minute, slept)
+	})
+
+	t.Run("when the deposit is paid out after the deadline", func(t *testing.T) {
+		slept = 0
+		payoutAt := time.Now().Add(72 * time.Hour)
+
+		m.EXPECT().GetFiatAccount("USD").Return(&exchanges.Account{Available: 25}, nil).Times(2)
+		m.EXPECT().GetPendingTransfers("USD").Return(nil, nil)
+		m.EXPECT().Deposit("USD", 25.0, "").Return(&payoutAt, nil)
+
+		assert.Equal(t, errAwaitingFunds, s.Sync())
+		assert.Zero(t, slept)
+	})
+
+	t.Run("when the deposit is canceled", func(t *testing.T) {
+		now := time.Now()
+
+		m.EXPECT().GetFiatAccount("USD").Return(&exchanges.Account{Available: 25}, nil).Times(3)
+		m.EXPECT().GetPendingTransfers("USD").Return(nil, nil).Times(2)
+		m.EXPECT().Deposit("USD", 25.0, "").Return(&now, nil)
+
+		err := s.Sync()
+
+		assert.Equal(t, "Deposit is no longer pending and the funds are still short, check the deposit on the exchange", err.Error())
+	})
+}
+
+func TestSyncRecordsResumedOrdersOnce(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockExchange(ctrl)
+
+	window := time.Now().Add(-time.Hour)
+	l, _ := openLedger("")
+
+	s := gdaxSchedule{}
+	s.logger = loggerStub(t).Sugar()
+	s.req = syncRequest{exchange: "coinbase", every: 24 * time.Hour, orderType: exchanges.Market, currency: "USD", usd: 50}
+	s.coins = map[string]orderDetails{"BTC": {symbol: "btcusd", amount: 50}}
+	s.sleepFunc = func(d time.Duration) {}
+	s.exchange = m
+	s.ledger = l
+	s.statePath = filepath.Join(t.TempDir(), "coinbase-local-default.json")
+
+	filled := &exchanges.Order{Symbol: "btcusd", OrderID: "1", Status: exchanges.OrderFilled, FilledSize: 0.001, AveragePrice: 50000}
+
+	kinds := func() []ledgerEntryKind {
+		kinds := []ledgerEntryKind{}
+		for _, e := range l.entries {
+			kinds = append(kinds, e.Kind)
+		}
+		return kinds
+	}
+
+	t.Run("when the run stopped while ordering", func(t *testing.T) {
+		s.record(ledgerEntry{Kind: ledgerOrder, Coin: "BTC", ProductId: "btcusd", OrderId: "1", Amount: 50, Window: &window})
+		s.record(ledgerEntry{Kind: ledgerFill, Coin: "BTC", ProductId: "btcusd", OrderId: "1", Amount: 50, Size: 0.001, Price: 50000, Done: true})
+
+		assert.Nil(t, s.saveState(&runState{
+			Phase:   phaseOrdering,
+			Started: window,
+			Due:     []string{"BTC"},
+			Windows: map[string]time.Time{"BTC": window},
+			Amounts: map[string]float64{"BTC": 50},
+		}))
+
+		//the exchange returns the order placed before
+		m.EXPECT().CreateOrder("btcusd", gomock.Any(), 50.0, exchanges.Market, gomock.Any()).Return(filled, nil)
+
+		assert.Nil(t, s.Sync())
+		assert.Equal(t, []ledgerEntryKind{ledgerOrder, ledgerFill}, kinds())
+	})
+
+	t.Run("when the run stopped while reconciling", func(t *testing.T) {
+		l.entries = l.entries[:1]
+
+		assert.Nil(t, s.saveState(&runState{
+			Phase:   phaseReconciling,
+			Started: window,
+			Due:     []string{"BTC"},
+			Windows: map[string]time.Time{"BTC": window},
+			Amounts: map[string]float64{"BTC": 50},
+			Orders:  map[string][]string{"BTC": {"1"}},
+		}))
+
+		m.EXPECT().GetOrder("btcusd", "1").Return(filled, nil)
+
+		assert.Nil(t, s.Sync())
+		assert.Equal(t, []ledgerEntryKind{ledgerOrder, ledgerFill}, kinds())
+		assert.Equal(t, 0.001, l.entries[1].Size)
+	})
+}
+
+func TestSyncResumesSlicesAfterAReplacedOrder(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockExchange(ctrl)
+
+	window := time.Now().Add(-time.Hour)
+	l, _ := openLedger("")
+
+	s := gdaxSchedule{}
+	s.logger = loggerStub(t).Sugar()
+	s.req = syncRequest{exchange: "coinbase", every: 24 * time.Hour, orderType: exchanges.Limit, currency: "USD", usd: 100, slices: 2}
+	s.coins = map[string]orderDetails{"BTC": {symbol: "btcusd", amount: 100}}
+	s.sleepFunc = func(d time.Duration) {}
+	s.exchange = m
+	s.ledger = l
+	s.statePath = filepath.Join(t.TempDir(), "coinbase-local-default.json")
+	s.windows = map[string]time.Time{"BTC": window}
+
+	//the previous run cancelled the first order of the first slice and replaced it, then stopped before the second slice
+	placed := map[string]*exchanges.Order{
+		s.clientOrderId("BTC", 0, 0): {Symbol: "btcusd", OrderID: "1", Status: exchanges.OrderCancelled, FilledSize: 0.0005, AveragePrice: 50000},
+		s.clientOrderId("BTC", 0, 1): {Symbol: "btcusd", OrderID: "2", Status: exchanges.OrderFilled, FilledSize: 0.0005, AveragePrice: 50000},
+	}
+
+	assert.Nil(t, s.saveState(&runState{
+		Phase:   phaseOrdering,
+		Started: window,
+		Due:     []string{"BTC"},
+		Windows: map[string]time.Time{"BTC": window},
+		Amounts: map[string]float64{"BTC": 100},
+	}))
+
+	ids := []string{}
+	m.EXPECT().CreateOrder("btcusd", gomock.Any(), 50.0, exchanges.Limit, gomock.Any()).DoAndReturn(
+		func(productId string, clientOrderId string, amount float64, orderType exchanges.OrderTypeType, calc exchanges.CalcLimitOrder) (*exchanges.Order, error) {
+			ids = append(ids, clientOrderId)
+			if order, found := placed[clientOrderId]; found {
+				return order, nil
+			}
+			return &exchanges.Order{Symbol: "btcusd", OrderID: "3", Status: exchanges.OrderFilled, FilledSize: 0.001, AveragePrice: 50000}, nil
+		},
+	).Times(2)
+
+	assert.Nil(t, s.Sync())
+
+	assert.Equal(t, []string{s.clientOrderId("BTC", 0, 0), s.clientOrderId("BTC", 1, 0)}, ids)
+
+	//the second slice is bought rather than given the replacement order of the first one
+	orders := []string{}
+	for _, e := range l.entries {
+		if e.Kind == ledgerOrder {
+			orders = append(orders, e.OrderId)
+		}
+	}
+	assert.Equal(t, []string{"1", "3"}, orders)
+}
@@ -2,15 +2,19 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"math"
+	"math/rand"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"go.uber.org/zap"
 
 	"github.com/sberserker/dcagdax/exchanges"
@@ -19,23 +23,71 @@ import (
 
 var skippedForDebug = errors.New("Skipping because trades are not enabled")
 
+var errNoWindow = errors.New("Detected a recent purchase, waiting for next purchase window")
+
+// clientOrderNamespace scopes the client order ids derived from purchase windows.
+var clientOrderNamespace = uuid.NewSHA1(uuid.NameSpaceURL, []byte("https://github.com/sberserker/dcagdax"))
+
+// fillPollInterval is how often a placed order is checked until it fills.
+const fillPollInterval = 5 * time.Second
+
+const (
+	unfilledCancel = "cancel"
+	unfilledMarket = "market"
+)
+
+const (
+	pricingSpread = "spread"
+	pricingBook   = "book"
+)
+
 type syncRequest struct {
-	usd         float64
-	orderSpread float64
-	orderType   exchanges.OrderTypeType
-	fee         float64
-	every       time.Duration
-	until       time.Time
-	after       time.Time
-	autoFund    bool
-	force       bool
-	coins       []string
-	currency    string
+	plan            string // name of the --config plan, empty for the plan of the flags
+	exchange        string
+	usd             float64
+	orderSpread     float64
+	orderType       exchanges.OrderTypeType
+	fee             float64 // percentage limit orders reserve for the fee
+	lookupFees      bool    // use the account's maker and taker rates instead of fee
+	every           time.Duration
+	until           time.Time
+	after           time.Time
+	autoFund        bool
+	paymentMethod   string        // id or name of the payment method deposits use, empty picks the only one for the currency
+	fundEvery       time.Duration // cadence of the funding plan's deposits, zero tops up the shortfall at buy time
+	fundUsd         float64       // amount of every funding plan deposit, zero tops up to fundBuffer
+	fundBuffer      int           // upcoming windows the balance and pending deposits should cover
+	fundMaxPending  int           // the most deposits outstanding before the funding plan deposits again, zero doesn't limit
+	caps            []string      // spending caps of all plans in [COIN:]AMOUNT/PERIOD format
+	force           bool
+	coins           []string
+	currency        string
+	historyFallback bool    // ask the exchange for the last purchase when the ledger has none
+	strategy        string  // name of the strategy deciding amounts, fixed when empty
+	maxUsd          float64 // the most dynamic strategies may spend in a single window, split like usd
+	maPeriod        int     // days in the moving average of dip strategy
+	maType          string  // sma or ema
+	dipBands        []string
+	depositWait     time.Duration // how long a run waits for its deposit to be available, zero doesn't wait
+	fillTimeout     time.Duration // how long to wait for an order to fill, zero doesn't wait
+	reprices        int           // how many times an unfilled limit order is moved to the current ask
+	unfilled        string        // what happens to a limit order unfilled after re-pricing, cancel or market
+	makerTimeout    time.Duration // how long a maker order waits at the bid before the rest is taken
+	makerOffset     float64       // percentage below the best bid maker orders are placed at
+	pricing         string        // how limit orders are priced, spread above the ask or book depth
+	bookBuffer      float64       // percentage above the order book level which fills the amount
+	maxSlippage     float64       // the most the average book price may be above the mid price in percent, zero doesn't check
+	slices          int           // how many orders a coin's purchase is split in, one or less doesn't split
+	sliceOver       time.Duration // period the slices are spread over
+	sliceRandom     bool          // place slices at random points of the period instead of evenly
 }
 
 type orderDetails struct {
-	symbol string
-	amount float64
+	symbol  string
+	amount  float64       // regular amount, strategies use it as the baseline
+	minimum float64       // minimum amount exchange accepts for the product
+	max     float64       // the most dynamic strategies may spend on the coin in a single window
+	every   time.Duration // coin's own cadence, zero means the plan's cadence
 }
 
 type gdaxSchedule struct {
@@ -43,10 +95,19 @@ type gdaxSchedule struct {
 	exchange    exchanges.Exchange
 	debug       bool
 	req         syncRequest
-	markerCoin  string // first coin which will be used as a marker if purchase was made recently
 	coins       map[string]orderDetails
+	ledger      *ledger // nil when ledger is disabled, last purchase comes from the exchange then
+	strategy    Strategy
 	sleepFunc   func(time.Duration)
 	confirmFunc func(string) bool
+	nowFunc     func() time.Time
+	fees        *exchanges.FeeRates  // the account's rates, nil uses the --fee percentage
+	windows     map[string]time.Time // start of the open purchase window of every due coin
+	lockPath    string               // run lock held during Sync, empty runs without one
+	statePath   string               // persisted state of the run, empty keeps it in memory
+	caps        []spendCap
+	ctx         context.Context // cancelled on shutdown, the waits of a run in progress stop then
+	fundRetry   time.Duration   // how long after a failed deposit the funding plan tries again, zero tries on every run
 }
 
 func newGdaxSchedule(
@@ -54,31 +115,71 @@ func newGdaxSchedule(
 	l *zap.SugaredLogger,
 	debug bool,
 	syncRequest syncRequest,
+	ledger *ledger,
 ) (*gdaxSchedule, error) {
 	schedule := gdaxSchedule{
 		logger:   l,
 		exchange: exchange,
 		debug:    debug,
+		ledger:   ledger,
 
 		req:         syncRequest,
 		coins:       map[string]orderDetails{},
-		sleepFunc:   sleep,
 		confirmFunc: askForConfirmation,
+		nowFunc:     time.Now,
+	}
+	schedule.sleepFunc = schedule.sleep
+
+	switch syncRequest.unfilled {
+	case "", unfilledCancel, unfilledMarket:
+	default:
+		return nil, fmt.Errorf("unsupported --unfilled %s, expected cancel or market", syncRequest.unfilled)
+	}
+
+	switch syncRequest.pricing {
+	case "", pricingSpread, pricingBook:
+	default:
+		return nil, fmt.Errorf("unsupported --pricing %s, expected spread or book", syncRequest.pricing)
+	}
+
+	if syncRequest.fundEvery > 0 && syncRequest.fundUsd <= 0 && syncRequest.fundBuffer <= 0 {
+		return nil, errors.New("--fund-every needs --fund-usd or --fund-buffer to know how much to deposit")
+	}
+
+	for _, value := range syncRequest.caps {
+		c, err := parseCap(value)
+		if err != nil {
+			return nil, err
+		}
+		schedule.caps = append(schedule.caps, c)
+	}
+
+	if len(schedule.caps) > 0 && ledger == nil {
+		return nil, errCapsNeedLedger
 	}
 
 	total := 0
 
 	for _, c := range syncRequest.coins {
 		arr := strings.Split(c, ":")
-		coin := arr[0]
-		if schedule.markerCoin == "" {
-			schedule.markerCoin = coin
+		if len(arr) < 2 || len(arr) > 3 {
+			return nil, fmt.Errorf("--coin %s misformatted, expected COIN:PERCENTAGE[:EVERY]", c)
 		}
+
+		//tickers, caps and the ledger all use upper case coins
+		coin := strings.ToUpper(arr[0])
 		percentage, err := strconv.Atoi(arr[1])
 		if err != nil {
 			return &schedule, err
 		}
 
+		var coinEvery generousDuration
+		if len(arr) == 3 {
+			if err := coinEvery.Set(arr[2]); err != nil {
+				return nil, fmt.Errorf("--coin %s cadence misformatted, e.g. 1h, 7d, 3w", c)
+			}
+		}
+
 		total += int(percentage)
 
 		symbol := exchange.GetTickerSymbol(coin, schedule.req.currency)
@@ -95,9 +196,18 @@ func newGdaxSchedule(
 		//schedule.usd * percentage / 100
 		scheduledForCoin, _ := decimal.NewFromFloat(schedule.req.usd).Mul(decimal.NewFromFloat(float64(percentage))).Div(decimal.NewFromFloat(100)).Truncate(2).Float64()
 
+		maxUsd := schedule.req.maxUsd
+		if maxUsd == 0 {
+			maxUsd = schedule.req.usd * 2
+		}
+		maxForCoin, _ := decimal.NewFromFloat(maxUsd).Mul(decimal.NewFromFloat(float64(percentage))).Div(decimal.NewFromFloat(100)).Truncate(2).Float64()
+
 		order := orderDetails{
-			symbol: symbol,
-			amount: scheduledForCoin,
+			symbol:  symbol,
+			amount:  scheduledForCoin,
+			minimum: minimum,
+			max:     maxForCoin,
+			every:   time.Duration(coinEvery),
 		}
 
 		schedule.coins[coin] = order
@@ -114,17 +224,97 @@ func newGdaxSchedule(
 		return nil, fmt.Errorf("Total percentages must be exactly 100, provided %d", total)
 	}
 
+	strategy, err := newStrategy(&schedule)
+	if err != nil {
+		return nil, err
+	}
+	schedule.strategy = strategy
+
+	if schedule.req.lookupFees {
+		rates, err := exchange.GetFeeRates()
+		if err != nil {
+			schedule.logger.Warnw(
+				"Cannot look up the account's fee rates, using --fee",
+				"fee", schedule.req.fee,
+				"error", err.Error(),
+			)
+		} else {
+			schedule.logger.Infow(
+				"Account fee rates",
+				"maker", rates.Maker,
+				"taker", rates.Taker,
+			)
+			schedule.fees = rates
+		}
+	}
+
 	return &schedule, nil
 }
 
 // Sync initiates trades & funding with a DCA strategy.
 func (s *gdaxSchedule) Sync() error {
 
-	now := time.Now()
+	if s.lockPath != "" {
+		lock, stale, err := acquireRunLock(s.lockPath)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := lock.release(); err != nil {
+				s.logger.Warnw("Failed to release the run lock", "lock", s.lockPath, "error", err.Error())
+			}
+		}()
+
+		if stale {
+			s.logger.Warnw(
+				"Took over a stale run lock, the previous run did not finish",
+				"lock", s.lockPath,
+			)
+		}
+	}
+
+	//a cron job or a manual run may have bought since the process started
+	if s.ledger != nil {
+		if err := s.ledger.reload(); err != nil {
+			return err
+		}
+	}
+
+	now := s.now()
+
+	if err := s.checkDates(now); err != nil {
+		return err
+	}
+
+	if s.fundingPlan() {
+		//the balance may still pay for the window
+		if err := s.fundOnSchedule(now); err != nil {
+			s.logger.Warnw(
+				"Funding plan failed to deposit",
+				"error", err.Error(),
+			)
+		}
+	}
 
+	state, err := s.resumeState(now)
+	if err != nil {
+		return err
+	}
+
+	if state == nil {
+		if state, err = s.openWindow(now); err != nil {
+			return err
+		}
+	}
+
+	return s.advance(state)
+}
+
+// checkDates tells whether the plan is active between its after and until dates.
+func (s *gdaxSchedule) checkDates(now time.Time) error {
 	until := s.req.until
 	if until.IsZero() {
-		until = time.Now()
+		until = now
 	}
 
 	if now.After(until) {
@@ -137,86 +327,228 @@ func (s *gdaxSchedule) Sync() error {
 
 	s.logger.Infow("Dollar cost averaging",
 		s.req.currency, s.req.usd,
-		"every", every,
+		"every", s.req.every.String(),
 		"until", until.String(),
 	)
 
-	if s.req.force != true {
-		since := now.Add(-*every)
-		if time, err := s.timeToPurchase(since); err != nil {
-			return err
-		} else if !time {
-			return errors.New("Detected a recent purchase, waiting for next purchase window")
-		}
-	} else {
-		c := s.confirmFunc("Force method is used proceed?")
-		if !c {
-			return errors.New("User rejected the trade")
-		}
-	}
+	return nil
+}
 
-	needed, err := s.additionalUsdNeeded()
-	if err != nil {
-		return err
-	}
+// dueCoins returns the coins whose purchase window is open.
+func (s *gdaxSchedule) dueCoins(now time.Time) ([]string, error) {
+	due := []string{}
+	s.windows = map[string]time.Time{}
 
-	//check if there are pending transfers
-	//typically pending transfers means something is stuck, need to wait to settle or resolve the issue
-	if needed > 0 {
-		pending, err := s.pendingTransfers()
+	for _, coin := range s.coinNames() {
+		since := now.Add(-s.everyFor(coin))
+		start, err := s.windowStart(coin, since)
 		if err != nil {
-			return err
+			return nil, err
+		}
+
+		if start != nil {
+			due = append(due, coin)
+			s.windows[coin] = *start
 		}
+	}
+
+	return due, nil
+}
 
-		if pending > 0 {
-			return errors.New("Wait for transfers to settle")
+// purchase buys the coins' amounts, each split in slices spread over --slice-over when slicing is configured.
+// Slices of all coins are placed in rounds so a long purchase of one coin doesn't hold up the others.
+// A run cancelled while waiting for the next slice returns the purchases so far with the context's error.
+func (s *gdaxSchedule) purchase(due []string, amounts map[string]float64) ([]purchase, error) {
+	executions := []*execution{}
+	slices := map[string][]float64{}
+	rounds := 0
+
+	for _, coin := range due {
+		amount, found := amounts[coin]
+		if !found {
+			continue
 		}
 
+		order := s.coins[coin]
 		s.logger.Infow(
-			"Insufficient funds",
-			"needed", needed,
+			"Placing an order",
+			"productId", order.symbol,
+			"amount", amount,
 		)
 
-		if !s.req.autoFund {
-			return errors.New("No sufficient amount for trade and autofund is disabled. Deposit money to proceed")
+		executions = append(executions, &execution{coin: coin, productId: order.symbol, amount: amount})
+		slices[coin] = s.sliceAmounts(amount, order.minimum)
+		if len(slices[coin]) > rounds {
+			rounds = len(slices[coin])
 		}
+	}
 
-		_, err = s.fund(needed)
-		if err != nil {
-			return err
-		}
+	delays := sliceDelays(rounds, s.req.sliceOver, s.req.sliceRandom, rand.Int63n)
+	stopped := map[string]bool{}
+	var cancelled error
 
-		//calculate when money will available to buy and sleep
-		//waitTime := payoutAt.Add(1 * time.Minute).Sub(time.Now())
-		waitTime := 1 * time.Minute
-		if waitTime < 2*time.Minute {
+	for i := 0; i < rounds; i++ {
+		if i > 0 {
+			wait := delays[i] - delays[i-1]
 			s.logger.Infow(
-				"Sleeping for",
-				"minutes", waitTime.Minutes(),
+				"Waiting for the next slice",
+				"slice", i+1,
+				"slices", rounds,
+				"wait", wait.String(),
 			)
-			s.sleepFunc(waitTime)
-		} else {
-			s.logger.Infow(
-				"Deposit money will be available in. Exiting now",
-				"minutes", waitTime.Minutes(),
-			)
-			return nil
+			if cancelled = s.wait(wait); cancelled != nil {
+				s.logger.Warnw(
+					"Run is cancelled, the rest of the slices are left to a later run",
+					"slice", i+1,
+					"slices", rounds,
+				)
+				break
+			}
+		}
+
+		for _, e := range executions {
+			if stopped[e.coin] || i >= len(slices[e.coin]) {
+				continue
+			}
+
+			child, err := s.executePurchase(e.coin, e.productId, i, slices[e.coin][i])
+			if err != nil {
+				//the rest of the slices would likely fail the same way
+				rest := 0.0
+				for _, amount := range slices[e.coin][i:] {
+					rest += amount
+				}
+
+				s.logger.Warn(err)
+				s.skipCoin(e.coin, rest, err.Error())
+				stopped[e.coin] = true
+				continue
+			}
+
+			e.orders = append(e.orders, child.orders...)
 		}
 	}
 
-	for _, order := range s.coins {
-		s.logger.Infow(
-			"Placing an order",
-			"productId", order.symbol,
-			"amount", order.amount,
-		)
+	purchases := []purchase{}
+	for _, e := range executions {
+		if len(e.orders) == 0 {
+			continue
+		}
 
-		if err := s.makePurchase(order.symbol, order.amount); err != nil {
-			s.logger.Warn(err)
+		if len(e.orders) > 1 {
+			s.recordPurchase(e)
 		}
+
+		ids := []string{}
+		for _, o := range e.orders {
+			ids = append(ids, o.OrderID)
+		}
+
+		purchases = append(purchases, purchase{coin: e.coin, amount: e.amount, order: e.result(), orderIds: ids})
 	}
 
-	return nil
+	return purchases, cancelled
+}
+
+// sliceAmounts splits the amount in --slices equal parts, fewer when the parts would be below the minimum purchase.
+// The last part takes the cents the split leaves.
+func (s *gdaxSchedule) sliceAmounts(amount float64, minimum float64) []float64 {
+	n := s.req.slices
+	if minimum > 0 && n > int(amount/minimum) {
+		n = int(amount / minimum)
+	}
+	if n <= 1 {
+		return []float64{amount}
+	}
+
+	total := decimal.NewFromFloat(amount)
+	part := total.Div(decimal.NewFromInt(int64(n))).Truncate(2)
+	partf, _ := part.Float64()
+
+	amounts := make([]float64, n)
+	for i := 0; i < n-1; i++ {
+		amounts[i] = partf
+	}
+	amounts[n-1], _ = total.Sub(part.Mul(decimal.NewFromInt(int64(n - 1)))).Float64()
+
+	return amounts
+}
+
+// sliceDelays returns when each of n slices starts from the first one, evenly spaced over the period or at random points of it.
+func sliceDelays(n int, period time.Duration, random bool, int63n func(int64) int64) []time.Duration {
+	delays := make([]time.Duration, n)
+	if n <= 1 || period <= 0 {
+		return delays
+	}
+
+	step := period / time.Duration(n)
+	for i := 1; i < n; i++ {
+		delays[i] = step * time.Duration(i)
+		if random {
+			delays[i] = time.Duration(int63n(int64(period)))
+		}
+	}
+
+	sort.Slice(delays, func(i, j int) bool {
+		return delays[i] < delays[j]
+	})
+
+	return delays
+}
+
+// recordPurchase records the fills of a purchase which took several orders as one.
+func (s *gdaxSchedule) recordPurchase(e *execution) {
+	result := e.result()
+	if result.FilledSize == 0 {
+		return
+	}
+
+	ids := []string{}
+	for _, o := range e.orders {
+		ids = append(ids, o.OrderID)
+	}
+
+	s.record(ledgerEntry{
+		Kind:      ledgerPurchase,
+		Coin:      e.coin,
+		ProductId: e.productId,
+		OrderIds:  ids,
+		Amount:    result.FilledValue() + result.Fee,
+		Size:      result.FilledSize,
+		Price:     result.AveragePrice,
+		Fee:       result.Fee,
+	})
+}
+
+// purchase is a coin's purchase during the run, it may take several orders.
+type purchase struct {
+	coin     string
+	amount   float64
+	order    *exchanges.Order
+	orderIds []string
+}
+
+// summarize logs what every order of the run executed.
+func (s *gdaxSchedule) summarize(purchases []purchase) {
+	for _, p := range purchases {
+		feeRate := 0.0
+		if value := p.order.FilledValue(); value > 0 {
+			feeRate = p.order.Fee / value * 100
+		}
+
+		s.logger.Infow(
+			"Run summary",
+			"coin", p.coin,
+			"orderId", p.order.OrderID,
+			"status", p.order.Status,
+			"amount", p.amount,
+			"size", p.order.FilledSize,
+			"averagePrice", p.order.AveragePrice,
+			"fee", p.order.Fee,
+			"feeRate", feeRate,
+			"spent", p.order.FilledValue()+p.order.Fee,
+		)
+	}
 }
 
 func (s *gdaxSchedule) fund(needed float64) (*time.Time, error) {
@@ -227,7 +559,7 @@ func (s *gdaxSchedule) fund(needed float64) (*time.Time, error) {
 
 	if s.debug {
 		s.logger.Infow("Deposit skipped for debug")
-		now := time.Now()
+		now := s.now()
 		return &now, nil
 	}
 
@@ -254,37 +586,168 @@ func (s *gdaxSchedule) minimumUSDPurchase(productId string) (float64, error) {
 	return math.Max(product.BaseMinSize*ticker.Price, 1.0), nil
 }
 
-func (s *gdaxSchedule) timeToPurchase(since time.Time) (bool, error) {
-	timeSinceLastPurchase, err := s.timeSinceLastPurchase(since)
+// purchaseAmounts asks the strategy how much to spend on every due coin, coins the strategy skips are left out.
+func (s *gdaxSchedule) purchaseAmounts(due []string, now time.Time) (map[string]float64, error) {
+	strategy := s.strategy
+	if strategy == nil {
+		strategy = fixedStrategy{}
+	}
+
+	amounts := map[string]float64{}
+
+	for _, coin := range due {
+		order := s.coins[coin]
+
+		amount, err := strategy.Amount(purchaseWindow{
+			coin:  coin,
+			order: order,
+			every: s.everyFor(coin),
+			time:  now,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		//cut fractions of cents, exchanges don't accept them
+		amount, _ = decimal.NewFromFloat(amount).Truncate(2).Float64()
+
+		if amount <= 0 {
+			s.logger.Infow(
+				"Strategy decided not to buy",
+				"coin", coin,
+			)
+			s.skipCoin(coin, 0, "Strategy decided not to buy")
+			continue
+		}
+
+		if amount < order.minimum {
+			reason := fmt.Sprintf("Strategy amount $%.02f is below %s minimum trade amount $%.02f", amount, coin, order.minimum)
+			s.logger.Infow(reason)
+			s.skipCoin(coin, amount, reason)
+			continue
+		}
+
+		amounts[coin] = amount
+	}
+
+	return amounts, nil
+}
+
+// coinNames returns the plan's coins in a stable order.
+func (s *gdaxSchedule) coinNames() []string {
+	names := make([]string, 0, len(s.coins))
+	for coin := range s.coins {
+		names = append(names, coin)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// everyFor returns the coin's cadence.
+func (s *gdaxSchedule) everyFor(coin string) time.Duration {
+	if every := s.coins[coin].every; every > 0 {
+		return every
+	}
+
+	return s.req.every
+}
+
+func (s *gdaxSchedule) timeToPurchase(coin string, since time.Time) (bool, error) {
+	start, err := s.windowStart(coin, since)
+	return start != nil, err
+}
+
+// windowStart returns when the coin's open purchase window started, nil while the window is not open.
+// The window opens a cadence after the last purchase, without one it's the current cadence period so retries land in the same window.
+func (s *gdaxSchedule) windowStart(coin string, since time.Time) (*time.Time, error) {
+	every := s.everyFor(coin)
 
+	lastPurchaseTime, err := s.lastPurchaseTime(coin, since)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	if timeSinceLastPurchase == nil {
-		return true, nil
+	if lastPurchaseTime == nil {
+		s.logger.Infow(
+			"No transactions found since",
+			"coin", coin,
+			"since", since.Local(),
+		)
+
+		start := s.now().Truncate(every)
+		return &start, nil
 	}
 
+	timeSinceLastPurchase := s.now().Sub(*lastPurchaseTime)
+
 	s.logger.Infow(
 		"Time since last purchase hours",
+		"coin", coin,
 		"hours", timeSinceLastPurchase.Hours(),
 	)
 
-	if timeSinceLastPurchase.Seconds() < s.req.every.Seconds() {
+	if timeSinceLastPurchase.Seconds() < every.Seconds() {
 		// We purchased something recently, so hang tight.
-		return false, nil
+		return nil, nil
+	}
+
+	start := lastPurchaseTime.Add(every)
+	return &start, nil
+}
+
+// nextPurchaseTime returns when the next purchase window opens for any of the coins, it's in the past when a window is already open.
+func (s *gdaxSchedule) nextPurchaseTime() (time.Time, error) {
+	var next time.Time
+
+	for _, coin := range s.coinNames() {
+		coinNext, err := s.coinNextPurchaseTime(coin)
+		if err != nil {
+			return s.now(), err
+		}
+
+		if next.IsZero() || coinNext.Before(next) {
+			next = coinNext
+		}
+	}
+
+	if next.IsZero() {
+		next = s.now()
+	}
+
+	return next, nil
+}
+
+// coinNextPurchaseTime returns when the next purchase window opens for the coin.
+func (s *gdaxSchedule) coinNextPurchaseTime(coin string) (time.Time, error) {
+	now := s.now()
+	every := s.everyFor(coin)
+
+	timeSinceLastPurchase, err := s.timeSinceLastPurchase(coin, now.Add(-every))
+	if err != nil {
+		return now, err
+	}
+
+	next := now
+	if timeSinceLastPurchase != nil {
+		next = now.Add(every - *timeSinceLastPurchase)
 	}
 
-	return true, nil
+	if !s.req.after.IsZero() && next.Before(s.req.after) {
+		next = s.req.after
+	}
+
+	return next, nil
 }
 
-func (s *gdaxSchedule) additionalUsdNeeded() (float64, error) {
+// additionalUsdNeeded returns how much needs to be deposited to spend the amount.
+func (s *gdaxSchedule) additionalUsdNeeded(amount float64) (float64, error) {
 	usdAccount, err := s.exchange.GetFiatAccount(s.req.currency)
 	if err != nil {
 		return 0, err
 	}
 
-	if usdAccount.Available >= s.req.usd {
+	if usdAccount.Available >= amount {
 		return 0, nil
 	}
 
@@ -296,8 +759,8 @@ func (s *gdaxSchedule) additionalUsdNeeded() (float64, error) {
 	)
 
 	//account may have some fraction of cents from previous trading so cut everything after 0.01
-	//s.usd - availableBalance
-	dollarsNeeded, _ := decimal.NewFromFloat(s.req.usd).Sub(availableBalance).Truncate(2).Float64()
+	//amount - availableBalance
+	dollarsNeeded, _ := decimal.NewFromFloat(amount).Sub(availableBalance).Truncate(2).Float64()
 
 	return dollarsNeeded, nil
 }
@@ -314,6 +777,16 @@ func (s *gdaxSchedule) pendingTransfers() (float64, error) {
 	dollarsInbound := 0.0
 
 	for _, t := range transfers {
+		if t.Status == exchanges.TransferStuck {
+			s.logger.Warnw(
+				"Deposit looks stuck, not waiting for it",
+				"id", t.Id,
+				"amount", t.Amount,
+				"created", t.CreatedAt,
+			)
+			continue
+		}
+
 		s.logger.Infow(
 			"Deposit is in progress",
 			"amount", t.Amount,
@@ -324,8 +797,8 @@ func (s *gdaxSchedule) pendingTransfers() (float64, error) {
 	return dollarsInbound, nil
 }
 
-func (s *gdaxSchedule) timeSinceLastPurchase(since time.Time) (*time.Duration, error) {
-	lastPurchaseTime, err := s.exchange.LastPurchaseTime(s.markerCoin, s.req.currency, since) //taking the first coins a marker, make sure to put your main coin first
+func (s *gdaxSchedule) timeSinceLastPurchase(coin string, since time.Time) (*time.Duration, error) {
+	lastPurchaseTime, err := s.lastPurchaseTime(coin, since)
 
 	if err != nil {
 		return nil, err
@@ -334,6 +807,7 @@ func (s *gdaxSchedule) timeSinceLastPurchase(since time.Time) (*time.Duration, e
 	if lastPurchaseTime == nil {
 		s.logger.Infow(
 			"No transactions found since",
+			"coin", coin,
 			"since", since.Local(),
 		)
 		return nil, nil
@@ -341,19 +815,233 @@ func (s *gdaxSchedule) timeSinceLastPurchase(since time.Time) (*time.Duration, e
 
 	s.logger.Infow(
 		"Last transaction time",
+		"coin", coin,
 		"time", lastPurchaseTime.Local(),
 	)
 
-	timeSinceLastPurchase := time.Now().Sub(*lastPurchaseTime)
+	timeSinceLastPurchase := s.now().Sub(*lastPurchaseTime)
 	return &timeSinceLastPurchase, nil
 }
 
-func (s *gdaxSchedule) makePurchase(productId string, amount float64) error {
+// lastPurchaseTime looks up the last purchase in the ledger and falls back to the exchange history when allowed.
+func (s *gdaxSchedule) lastPurchaseTime(coin string, since time.Time) (*time.Time, error) {
+	if s.ledger != nil {
+		if lastPurchaseTime := s.ledger.lastPurchase(s.req.plan, s.req.exchange, coin, s.req.currency); lastPurchaseTime != nil {
+			return lastPurchaseTime, nil
+		}
+
+		if !s.req.historyFallback {
+			return nil, nil
+		}
+	}
+
+	return s.exchange.LastPurchaseTime(coin, s.req.currency, since)
+}
+
+func (s *gdaxSchedule) makePurchase(coin string, productId string, amount float64) (*exchanges.Order, error) {
+	e, err := s.executePurchase(coin, productId, 0, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.result(), nil
+}
+
+// executePurchase places and works the orders buying the amount of the slice and records their fills.
+// It fails only when no order could be placed.
+func (s *gdaxSchedule) executePurchase(coin string, productId string, slice int, amount float64) (*execution, error) {
 	if s.debug {
-		return skippedForDebug
+		return nil, skippedForDebug
+	}
+
+	e := &execution{coin: coin, productId: productId, slice: slice, amount: amount}
+
+	err := s.execute(e)
+	if len(e.orders) == 0 {
+		return nil, err
+	}
+
+	if err != nil {
+		//orders are placed already, only what happens to the rest is unknown
+		s.logger.Warnw(
+			"Purchase is not complete",
+			"orderId", e.current().OrderID,
+			"error", err.Error(),
+		)
+	}
+
+	for _, order := range e.orders {
+		s.recordFill(coin, order)
+	}
+
+	return e, nil
+}
+
+// execute places the orders of the purchase and works them until they fill or the options run out.
+func (s *gdaxSchedule) execute(e *execution) error {
+	orderType := s.req.orderType
+	amount := e.amount
+
+	if orderType == exchanges.Maker {
+		filled, err := s.executeMaker(e)
+		if err != nil || filled {
+			return err
+		}
+
+		//take the rest at the ask
+		orderType = exchanges.Limit
+		if len(e.orders) > 0 {
+			amount = e.remaining()
+		}
+	}
+
+	if err := s.placeOrder(e, orderType, amount); err != nil {
+		return err
+	}
+
+	for reprices := 0; ; reprices++ {
+		if err := s.waitForFill(e, s.req.fillTimeout); err != nil {
+			return fmt.Errorf("cannot confirm the order: %w", err)
+		}
+
+		if e.current().Done() || s.req.fillTimeout == 0 {
+			return nil
+		}
+
+		s.logger.Warnw(
+			"Order is not filled within the fill timeout",
+			"orderId", e.current().OrderID,
+			"timeout", s.req.fillTimeout.String(),
+		)
+
+		//market orders are left to the exchange
+		if orderType != exchanges.Limit {
+			return nil
+		}
+
+		if reprices == s.req.reprices {
+			if err := s.settleUnfilled(e); err != nil {
+				return fmt.Errorf("cannot settle the unfilled order: %w", err)
+			}
+			return nil
+		}
+
+		if err := s.reprice(e); err != nil {
+			return fmt.Errorf("cannot re-price the order: %w", err)
+		}
+	}
+}
+
+// executeMaker places a post-only order at the bid and cancels it when it doesn't fill within the maker timeout.
+// It tells if the order filled, otherwise the rest is left to a taker order.
+func (s *gdaxSchedule) executeMaker(e *execution) (bool, error) {
+	if err := s.placeOrder(e, exchanges.Maker, e.amount); err != nil {
+		//post-only orders are rejected when they would take liquidity
+		if !errors.Is(err, exchanges.ErrPostOnlyRejected) {
+			return false, err
+		}
+
+		s.logger.Infow(
+			"Maker order is rejected, taking the order",
+			"error", err.Error(),
+		)
+		return false, nil
+	}
+
+	if err := s.waitForFill(e, s.req.makerTimeout); err != nil {
+		return false, fmt.Errorf("cannot confirm the order: %w", err)
+	}
+
+	order := e.current()
+	if !order.Done() {
+		cancelled, err := s.exchange.CancelOrder(e.productId, order.OrderID)
+		if err != nil {
+			return false, fmt.Errorf("cannot cancel the maker order: %w", err)
+		}
+		e.orders[len(e.orders)-1] = cancelled
+	}
+
+	if e.current().Status == exchanges.OrderFilled {
+		return true, nil
+	}
+
+	s.logger.Infow(
+		"Maker order is not filled, taking the rest",
+		"orderId", order.OrderID,
+		"timeout", s.req.makerTimeout.String(),
+		"amount", e.remaining(),
+	)
+
+	return false, nil
+}
+
+// execution is a purchase carried out by one or more orders, e.g. when an unfilled limit order is replaced.
+type execution struct {
+	coin      string
+	productId string
+	slice     int // index of the slice of the coin's purchase
+	amount    float64
+	orders    []*exchanges.Order // the last one is the order being worked
+	attempts  int                // orders tried to place, rejected ones included
+}
+
+func (e *execution) current() *exchanges.Order {
+	return e.orders[len(e.orders)-1]
+}
+
+// remaining returns the fiat amount the orders have not spent yet.
+func (e *execution) remaining() float64 {
+	remaining := decimal.NewFromFloat(e.amount)
+	for _, o := range e.orders {
+		value := decimal.NewFromFloat(o.FilledSize).Mul(decimal.NewFromFloat(o.AveragePrice))
+		remaining = remaining.Sub(value).Sub(decimal.NewFromFloat(o.Fee))
 	}
 
-	order, err := s.exchange.CreateOrder(productId, amount, s.req.orderType, s.calcLimitOrder)
+	r, _ := remaining.Truncate(2).Float64()
+	return r
+}
+
+// result sums up the orders as a single order with the status of the last one.
+func (e *execution) result() *exchanges.Order {
+	current := e.current()
+	result := &exchanges.Order{
+		Symbol:  current.Symbol,
+		OrderID: current.OrderID,
+		Status:  current.Status,
+	}
+
+	var value float64
+	for _, o := range e.orders {
+		result.FilledSize += o.FilledSize
+		result.Fee += o.Fee
+		value += o.FilledValue()
+	}
+
+	if result.FilledSize > 0 {
+		result.AveragePrice = value / result.FilledSize
+	}
+
+	return result
+}
+
+// placeOrder places a new order of the execution for the amount and records it.
+func (s *gdaxSchedule) placeOrder(e *execution, orderType exchanges.OrderTypeType, amount float64) error {
+	calc := s.calcLimitOrder
+	switch {
+	case orderType == exchanges.Maker:
+		calc = s.calcMakerOrder
+	case orderType == exchanges.Limit && s.req.pricing == pricingBook:
+		var err error
+		if calc, err = s.bookLimitOrder(e.productId, amount); err != nil {
+			return err
+		}
+	}
+
+	//every attempt takes the next id, a rejected order may still be known to the exchange
+	clientOrderId := s.clientOrderId(e.coin, e.slice, e.attempts)
+	e.attempts++
+
+	order, err := s.exchange.CreateOrder(e.productId, clientOrderId, amount, orderType, calc)
 
 	if err != nil {
 		return err
@@ -364,12 +1052,185 @@ func (s *gdaxSchedule) makePurchase(productId string, amount float64) error {
 		"orderId", order.OrderID,
 	)
 
+	var window *time.Time
+	if start, found := s.windows[e.coin]; found {
+		window = &start
+	}
+
+	s.record(ledgerEntry{
+		Kind:      ledgerOrder,
+		Coin:      e.coin,
+		ProductId: e.productId,
+		OrderId:   order.OrderID,
+		Amount:    amount,
+		Window:    window,
+	})
+
+	e.orders = append(e.orders, order)
+
+	return nil
+}
+
+// clientOrderId derives the id of an order from the plan, the purchase window, the slice and the attempt within the slice,
+// a run retried in the same window places orders with the same ids so the exchange returns them instead of buying twice.
+// The slice is part of the id so the slices after a replaced order don't take the ids of its replacements.
+func (s *gdaxSchedule) clientOrderId(coin string, slice int, attempt int) string {
+	key := fmt.Sprintf("%s|%s|%s|%d|%d", s.req.plan, coin, s.windows[coin].UTC().Format(time.RFC3339), slice, attempt)
+	return uuid.NewSHA1(clientOrderNamespace, []byte(key)).String()
+}
+
+// waitForFill polls the current order until it reaches a terminal state or the timeout passes.
+func (s *gdaxSchedule) waitForFill(e *execution, timeout time.Duration) error {
+	polls := int(timeout / fillPollInterval)
+	if timeout > 0 && polls == 0 {
+		polls = 1
+	}
+
+	for i := 0; i < polls && !e.current().Done(); i++ {
+		if err := s.wait(fillPollInterval); err != nil {
+			return err
+		}
+
+		current, err := s.exchange.GetOrder(e.productId, e.current().OrderID)
+		if err != nil {
+			return err
+		}
+		e.orders[len(e.orders)-1] = current
+	}
+
+	return nil
+}
+
+// reprice moves the open limit order to the current ask, exchanges which cannot edit orders get it cancelled and replaced.
+func (s *gdaxSchedule) reprice(e *execution) error {
+	order := e.current()
+	remaining := e.remaining()
+
+	var price, size decimal.Decimal
+	if s.req.pricing == pricingBook {
+		calc, err := s.bookLimitOrder(e.productId, remaining)
+		if err != nil {
+			return err
+		}
+		price, size = calc(decimal.Zero, decimal.NewFromFloat(remaining))
+	} else {
+		ticker, err := s.exchange.GetTicker(e.productId)
+		if err != nil {
+			return err
+		}
+		price, size = s.calcLimitOrder(decimal.NewFromFloat(ticker.Price), decimal.NewFromFloat(remaining))
+	}
+
+	//the size of an edited order includes what it has filled already
+	edited, err := s.exchange.EditOrder(e.productId, order.OrderID, price, size.Add(decimal.NewFromFloat(order.FilledSize)))
+	if err == nil {
+		s.logger.Infow(
+			"Re-priced the order",
+			"orderId", order.OrderID,
+			"price", price.String(),
+		)
+		e.orders[len(e.orders)-1] = edited
+		return nil
+	}
+
+	if !errors.Is(err, exchanges.ErrEditNotSupported) {
+		return err
+	}
+
+	cancelled, err := s.exchange.CancelOrder(e.productId, order.OrderID)
+	if err != nil {
+		return err
+	}
+	e.orders[len(e.orders)-1] = cancelled
+
+	//filled while being cancelled
+	if cancelled.Status == exchanges.OrderFilled {
+		return nil
+	}
+
+	s.logger.Infow(
+		"Replacing the order",
+		"orderId", order.OrderID,
+		"amount", e.remaining(),
+	)
+
+	return s.placeOrder(e, exchanges.Limit, e.remaining())
+}
+
+// settleUnfilled cancels the order which is still unfilled after re-pricing,
+// then buys the rest with a market order or records the shortfall.
+func (s *gdaxSchedule) settleUnfilled(e *execution) error {
+	order := e.current()
+
+	cancelled, err := s.exchange.CancelOrder(e.productId, order.OrderID)
+	if err != nil {
+		return err
+	}
+	e.orders[len(e.orders)-1] = cancelled
+
+	if cancelled.Status == exchanges.OrderFilled {
+		return nil
+	}
+
+	remaining := e.remaining()
+
+	if s.req.unfilled == unfilledMarket {
+		s.logger.Infow(
+			"Buying the rest with a market order",
+			"orderId", order.OrderID,
+			"amount", remaining,
+		)
+
+		if err := s.placeOrder(e, exchanges.Market, remaining); err != nil {
+			return err
+		}
+
+		return s.waitForFill(e, s.req.fillTimeout)
+	}
+
+	s.logger.Warnw(
+		"Purchase shortfall",
+		"orderId", order.OrderID,
+		"amount", e.amount,
+		"shortfall", remaining,
+	)
+	s.skipCoin(e.coin, remaining, fmt.Sprintf("Limit order %s is not filled after %d re-prices, cancelled", order.OrderID, s.req.reprices))
+
 	return nil
 }
 
+// recordFill records what the order executed, orders that executed nothing are recorded only once they are done
+// so spending caps stop counting the amount they were placed for.
+func (s *gdaxSchedule) recordFill(coin string, order *exchanges.Order) {
+	if order.FilledSize == 0 && !order.Done() {
+		return
+	}
+
+	s.logger.Infow(
+		"Order executed",
+		"orderId", order.OrderID,
+		"status", order.Status,
+		"size", order.FilledSize,
+		"averagePrice", order.AveragePrice,
+		"fee", order.Fee,
+	)
+
+	s.record(ledgerEntry{
+		Kind:      ledgerFill,
+		Coin:      coin,
+		ProductId: order.Symbol,
+		OrderId:   order.OrderID,
+		Amount:    order.FilledValue() + order.Fee,
+		Size:      order.FilledSize,
+		Price:     order.AveragePrice,
+		Fee:       order.Fee,
+		Done:      order.Done(),
+	})
+}
+
 func (s *gdaxSchedule) makeDeposit(amount float64) (*time.Time, error) {
 
-	payoutAt, err := s.exchange.Deposit(s.req.currency, amount)
+	payoutAt, err := s.exchange.Deposit(s.req.currency, amount, s.req.paymentMethod)
 
 	if err != nil {
 		return nil, err
@@ -380,9 +1241,67 @@ func (s *gdaxSchedule) makeDeposit(amount float64) (*time.Time, error) {
 		"payout", payoutAt,
 	)
 
+	s.record(ledgerEntry{
+		Kind:   ledgerDeposit,
+		Amount: amount,
+	})
+
 	return payoutAt, nil
 }
 
+// record appends the entry to the ledger, failures are logged only as the action already happened on the exchange.
+// Dry runs leave the ledger alone.
+func (s *gdaxSchedule) record(entry ledgerEntry) {
+	if s.ledger == nil || s.debug {
+		return
+	}
+
+	//a resumed run gets back the orders placed before, they are recorded once
+	orderId := entry.OrderId
+	if orderId == "" && len(entry.OrderIds) > 0 {
+		orderId = entry.OrderIds[0]
+	}
+	if orderId != "" && s.ledger.hasOrder(entry.Kind, orderId) && (entry.Kind != ledgerFill || !s.ledger.executing(orderId)) {
+		return
+	}
+
+	entry.Plan = s.req.plan
+	entry.Exchange = s.req.exchange
+	entry.Currency = s.req.currency
+	if entry.Time.IsZero() {
+		entry.Time = s.now()
+	}
+
+	if err := s.ledger.record(entry); err != nil {
+		s.logger.Errorw(
+			"Failed to write to the ledger",
+			"kind", entry.Kind,
+			"error", err.Error(),
+		)
+	}
+}
+
+// skipCoin records the coin's purchase window as skipped.
+func (s *gdaxSchedule) skipCoin(coin string, amount float64, reason string) {
+	s.record(ledgerEntry{
+		Kind:      ledgerSkip,
+		Coin:      coin,
+		ProductId: s.coins[coin].symbol,
+		Amount:    amount,
+		Reason:    reason,
+	})
+}
+
+// skip records the purchase window as skipped and returns the reason as an error.
+func (s *gdaxSchedule) skip(reason string) error {
+	s.record(ledgerEntry{
+		Kind:   ledgerSkip,
+		Reason: reason,
+	})
+
+	return errors.New(reason)
+}
+
 func askForConfirmation(s string) bool {
 	reader := bufio.NewReader(os.Stdin)
 
@@ -408,7 +1327,7 @@ func (s *gdaxSchedule) calcLimitOrder(askPrice decimal.Decimal, fiatAmount decim
 
 	//reduce fiat Amount to include fees %
 	//(1-fee)/100 * fiatAmount
-	fiatAmount = decimal.NewFromFloat((100 - s.req.fee) / 100).Mul(fiatAmount)
+	fiatAmount = decimal.NewFromFloat((100 - s.takerFee()) / 100).Mul(fiatAmount)
 
 	spread := decimal.NewFromFloat(s.req.orderSpread)
 
@@ -429,6 +1348,123 @@ func (s *gdaxSchedule) calcLimitOrder(askPrice decimal.Decimal, fiatAmount decim
 	return orderPrice, orderSize
 }
 
-func sleep(waitTime time.Duration) {
-	time.Sleep(waitTime)
+// bookLimitOrder prices a limit order at the ask level of the order book which fills the amount plus the book buffer.
+// It refuses to trade when the average price of the fill slips from the mid price more than allowed.
+func (s *gdaxSchedule) bookLimitOrder(productId string, amount float64) (exchanges.CalcLimitOrder, error) {
+	book, err := s.exchange.GetOrderBook(productId)
+	if err != nil {
+		return nil, err
+	}
+
+	mid, err := book.Mid()
+	if err != nil {
+		return nil, err
+	}
+
+	last, average, err := book.BuyPrice(amount)
+	if err != nil {
+		return nil, err
+	}
+
+	slippage := (average - mid) / mid * 100
+
+	s.logger.Infow(
+		"Order book",
+		"mid", mid,
+		"lastLevel", last,
+		"averagePrice", average,
+		"slippage", slippage,
+	)
+
+	if s.req.maxSlippage > 0 && slippage > s.req.maxSlippage {
+		return nil, fmt.Errorf("slippage %.2f%% versus mid price %.2f is above --max-slippage %.2f%%", slippage, mid, s.req.maxSlippage)
+	}
+
+	return func(_ decimal.Decimal, fiatAmount decimal.Decimal) (orderPrice decimal.Decimal, orderSize decimal.Decimal) {
+		fiatAmount = decimal.NewFromFloat((100 - s.takerFee()) / 100).Mul(fiatAmount)
+
+		level := decimal.NewFromFloat(last)
+		buffer := decimal.NewFromFloat(s.req.bookBuffer)
+
+		//level * buffer / 100 + level
+		orderPrice = level.Mul(buffer).Div(decimal.NewFromInt32(100)).Add(level).Truncate(2)
+		orderSize = fiatAmount.Div(orderPrice).Truncate(8)
+
+		s.logger.Infow(
+			"Limit order",
+			"size", orderSize.String(),
+			"price", orderPrice.String(),
+		)
+
+		return orderPrice, orderSize
+	}, nil
+}
+
+// calcMakerOrder prices a post-only order at the best bid less the maker offset.
+func (s *gdaxSchedule) calcMakerOrder(bidPrice decimal.Decimal, fiatAmount decimal.Decimal) (orderPrice decimal.Decimal, orderSize decimal.Decimal) {
+	fiatAmount = decimal.NewFromFloat((100 - s.makerFee()) / 100).Mul(fiatAmount)
+
+	offset := decimal.NewFromFloat(s.req.makerOffset)
+
+	//bid - bid * offset / 100
+	orderPrice = bidPrice.Sub(bidPrice.Mul(offset).Div(decimal.NewFromInt32(100))).Truncate(2)
+	orderSize = fiatAmount.Div(orderPrice).Truncate(8)
+
+	s.logger.Infow(
+		"Maker order",
+		"size", orderSize.String(),
+		"price", orderPrice.String(),
+	)
+
+	return orderPrice, orderSize
+}
+
+// takerFee returns the fee percentage of orders taking liquidity.
+func (s *gdaxSchedule) takerFee() float64 {
+	if s.fees == nil {
+		return s.req.fee
+	}
+
+	return s.fees.Taker
+}
+
+// makerFee returns the fee percentage of post-only orders.
+func (s *gdaxSchedule) makerFee() float64 {
+	if s.fees == nil {
+		return s.req.fee
+	}
+
+	return s.fees.Maker
+}
+
+// now returns the schedule's clock, backtests replace it with simulated time.
+func (s *gdaxSchedule) now() time.Time {
+	if s.nowFunc == nil {
+		return time.Now()
+	}
+
+	return s.nowFunc()
+}
+
+// runContext returns the context of the run, runs without one are never cancelled.
+func (s *gdaxSchedule) runContext() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+
+	return s.ctx
+}
+
+// sleep waits for the duration or until the run is cancelled.
+func (s *gdaxSchedule) sleep(waitTime time.Duration) {
+	select {
+	case <-s.runContext().Done():
+	case <-time.After(waitTime):
+	}
+}
+
+// wait sleeps between the steps of a run, it returns the context's error once the run is cancelled.
+func (s *gdaxSchedule) wait(waitTime time.Duration) error {
+	s.sleepFunc(waitTime)
+	return s.runContext().Err()
 }
@@ -3,10 +3,13 @@ package main
 import (
 	"errors"
 	"fmt"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/golang/mock/gomock"
+	"github.com/sberserker/dcagdax/clients/coinbasefake"
+	"github.com/sberserker/dcagdax/clients/coinbasev3"
 	"github.com/sberserker/dcagdax/exchanges"
 	"github.com/sberserker/dcagdax/mocks"
 	"github.com/shopspring/decimal"
@@ -87,7 +90,7 @@ func TestWhenRecentPurchase(t *testing.T) {
 	s := gdaxSchedule{}
 	s.logger = loggerStub(t).Sugar()
 	s.req = syncRequest{every: 24 * time.Hour, currency: "USD"} // setup run every 24 hrs
-	s.markerCoin = "BTC"
+	s.coins = map[string]orderDetails{"BTC": {symbol: "btcusd", amount: 50}}
 	s.exchange = m
 
 	t.Run("when recent purchase", func(t *testing.T) {
@@ -119,14 +122,14 @@ func TestTimeToPurchase(t *testing.T) {
 	s := gdaxSchedule{}
 	s.logger = loggerStub(t).Sugar()
 	s.req = syncRequest{every: 24 * time.Hour, currency: "USD"} // setup run every 24 hrs
-	s.markerCoin = "BTC"
+	s.coins = map[string]orderDetails{"BTC": {symbol: "btcusd", amount: 50}}
 	s.exchange = m
 
 	t.Run("when recent purchase", func(t *testing.T) {
 		lastPurchaseTime := time.Now().Add(-12 * time.Hour) //last purchase time 12 hrs ago
 		m.EXPECT().LastPurchaseTime("BTC", "USD", gomock.Any()).Return(&lastPurchaseTime, nil)
 
-		result, err := s.timeToPurchase(time.Now().Add(-24 * time.Hour))
+		result, err := s.timeToPurchase("BTC", time.Now().Add(-24*time.Hour))
 
 		assert.False(t, result)
 		assert.Nil(t, err)
@@ -136,7 +139,7 @@ func TestTimeToPurchase(t *testing.T) {
 		lastPurchaseTime := time.Now().Add(-48 * time.Hour) //last purchase time 2 days ago
 		m.EXPECT().LastPurchaseTime("BTC", "USD", gomock.Any()).Return(&lastPurchaseTime, nil)
 
-		result, err := s.timeToPurchase(time.Now().Add(-24 * time.Hour))
+		result, err := s.timeToPurchase("BTC", time.Now().Add(-24*time.Hour))
 
 		assert.True(t, result)
 		assert.Nil(t, err)
@@ -160,7 +163,7 @@ func TestNewSchedule(t *testing.T) {
 		m.EXPECT().GetProduct("ETH:USD").Return(&exchanges.Product{BaseMinSize: 0.5}, nil)
 		m.EXPECT().GetTicker("ETH:USD").Return(&exchanges.Ticker{Price: 10}, nil)
 
-		s, err := newGdaxSchedule(m, loggerStub(t).Sugar(), false, req)
+		s, err := newGdaxSchedule(m, loggerStub(t).Sugar(), false, req, nil)
 
 		assert.Nil(t, err)
 		assert.NotNil(t, s)
@@ -181,7 +184,7 @@ func TestNewSchedule(t *testing.T) {
 		m.EXPECT().GetProduct("ETH:USD").Return(&exchanges.Product{BaseMinSize: 0.5}, nil)
 		m.EXPECT().GetTicker("ETH:USD").Return(&exchanges.Ticker{Price: 10}, nil)
 
-		s, err := newGdaxSchedule(m, loggerStub(t).Sugar(), false, req)
+		s, err := newGdaxSchedule(m, loggerStub(t).Sugar(), false, req, nil)
 
 		assert.NotNil(t, err)
 		assert.Nil(t, s)
@@ -200,7 +203,7 @@ func TestNewScheduleWhenBelowMinSize(t *testing.T) {
 	m.EXPECT().GetProduct("BTC:USD").Return(&exchanges.Product{BaseMinSize: 0.01}, nil)
 	m.EXPECT().GetTicker("BTC:USD").Return(&exchanges.Ticker{Price: 10000}, nil)
 
-	s, err := newGdaxSchedule(m, loggerStub(t).Sugar(), false, req)
+	s, err := newGdaxSchedule(m, loggerStub(t).Sugar(), false, req, nil)
 
 	assert.Nil(t, s)
 	assert.NotNil(t, err)
@@ -217,7 +220,6 @@ func TestSyncWhenSuccessful(t *testing.T) {
 	s.logger = loggerStub(t).Sugar()
 	s.req = syncRequest{every: 24 * time.Hour, orderType: exchanges.Market, autoFund: true, currency: "USD", usd: 50} // setup run every 24 hrs
 	s.coins = map[string]orderDetails{"BTC": {symbol: "btcusd", amount: 50}}
-	s.markerCoin = "BTC"
 	s.sleepFunc = func(d time.Duration) {}
 	s.exchange = m
 
@@ -227,8 +229,37 @@ func TestSyncWhenSuccessful(t *testing.T) {
 	m.EXPECT().LastPurchaseTime("BTC", "USD", gomock.Any()).Return(nil, nil)
 	m.EXPECT().GetFiatAccount("USD").Return(&exchanges.Account{Available: 25}, nil)
 	m.EXPECT().GetPendingTransfers("USD").Return([]exchanges.PendingTransfer{}, nil)
-	m.EXPECT().Deposit("USD", 25.0).Return(&now, nil)
-	m.EXPECT().CreateOrder("btcusd", 50.0, exchanges.Market, gomock.Any()).Return(&result, nil)
+	m.EXPECT().Deposit("USD", 25.0, "").Return(&now, nil)
+	m.EXPECT().GetFiatAccount("USD").Return(&exchanges.Account{Available: 50}, nil)
+	m.EXPECT().CreateOrder("btcusd", gomock.Any(), 50.0, exchanges.Market, gomock.Any()).Return(&result, nil)
+
+	err := s.Sync()
+
+	assert.Nil(t, err)
+}
+
+func TestSyncDepositsWithThePlansPaymentMethod(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockExchange(ctrl)
+
+	s := gdaxSchedule{}
+	s.logger = loggerStub(t).Sugar()
+	s.req = syncRequest{plan: "weekly-eur", every: 24 * time.Hour, orderType: exchanges.Market, autoFund: true, currency: "EUR", usd: 50, paymentMethod: "Euro bank"}
+	s.coins = map[string]orderDetails{"BTC": {symbol: "btceur", amount: 50}}
+	s.sleepFunc = func(d time.Duration) {}
+	s.exchange = m
+
+	now := time.Now()
+	result := exchanges.Order{OrderID: "1"}
+
+	m.EXPECT().LastPurchaseTime("BTC", "EUR", gomock.Any()).Return(nil, nil)
+	m.EXPECT().GetFiatAccount("EUR").Return(&exchanges.Account{Available: 25}, nil)
+	m.EXPECT().GetPendingTransfers("EUR").Return([]exchanges.PendingTransfer{}, nil)
+	m.EXPECT().Deposit("EUR", 25.0, "Euro bank").Return(&now, nil)
+	m.EXPECT().GetFiatAccount("EUR").Return(&exchanges.Account{Available: 50}, nil)
+	m.EXPECT().CreateOrder("btceur", gomock.Any(), 50.0, exchanges.Market, gomock.Any()).Return(&result, nil)
 
 	err := s.Sync()
 
@@ -245,7 +276,6 @@ func TestSyncWhenNotSufficientBalanceAndAutoFundIsOff(t *testing.T) {
 	s.logger = loggerStub(t).Sugar()
 	s.req = syncRequest{every: 24 * time.Hour, orderType: exchanges.Market, autoFund: false, currency: "USD", usd: 50} // setup run every 24 hrs
 	s.coins = map[string]orderDetails{"BTC": {symbol: "btcusd", amount: 50}}
-	s.markerCoin = "BTC"
 	s.sleepFunc = func(d time.Duration) {}
 	s.exchange = m
 
@@ -291,7 +321,7 @@ func TestSyncShouldAskForConfirmationWhenForceIsOn(t *testing.T) {
 		result := exchanges.Order{OrderID: "1"}
 
 		m.EXPECT().GetFiatAccount("USD").Return(&exchanges.Account{Available: 50}, nil)
-		m.EXPECT().CreateOrder("btcusd", 50.0, exchanges.Market, gomock.Any()).Return(&result, nil)
+		m.EXPECT().CreateOrder("btcusd", gomock.Any(), 50.0, exchanges.Market, gomock.Any()).Return(&result, nil)
 
 		err := s.Sync()
 
@@ -299,6 +329,35 @@ func TestSyncShouldAskForConfirmationWhenForceIsOn(t *testing.T) {
 	})
 }
 
+func TestSyncDoesNotWaitForStuckDeposits(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockExchange(ctrl)
+
+	s := gdaxSchedule{}
+	s.logger = loggerStub(t).Sugar()
+	s.req = syncRequest{every: 24 * time.Hour, orderType: exchanges.Market, autoFund: true, currency: "USD", usd: 50} // setup run every 24 hrs
+	s.coins = map[string]orderDetails{"BTC": {symbol: "btcusd", amount: 50}}
+	s.sleepFunc = func(d time.Duration) {}
+	s.exchange = m
+
+	now := time.Now()
+	result := exchanges.Order{OrderID: "1"}
+	stuck := exchanges.PendingTransfer{Id: "old", Amount: 25, Status: exchanges.TransferStuck, CreatedAt: now.Add(-72 * time.Hour)}
+
+	m.EXPECT().LastPurchaseTime("BTC", "USD", gomock.Any()).Return(nil, nil)
+	m.EXPECT().GetFiatAccount("USD").Return(&exchanges.Account{Available: 25}, nil)
+	m.EXPECT().GetPendingTransfers("USD").Return([]exchanges.PendingTransfer{stuck}, nil)
+	m.EXPECT().Deposit("USD", 25.0, "").Return(&now, nil)
+	m.EXPECT().GetFiatAccount("USD").Return(&exchanges.Account{Available: 50}, nil)
+	m.EXPECT().CreateOrder("btcusd", gomock.Any(), 50.0, exchanges.Market, gomock.Any()).Return(&result, nil)
+
+	err := s.Sync()
+
+	assert.Nil(t, err)
+}
+
 func TestSyncWhenDebugIsOn(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -309,11 +368,14 @@ func TestSyncWhenDebugIsOn(t *testing.T) {
 	s.logger = loggerStub(t).Sugar()
 	s.req = syncRequest{every: 24 * time.Hour, orderType: exchanges.Market, autoFund: true, currency: "USD", usd: 50} // setup run every 24 hrs
 	s.debug = true
-	s.markerCoin = "BTC"
 	s.coins = map[string]orderDetails{"BTC": {symbol: "btcusd", amount: 50}}
 	s.sleepFunc = func(d time.Duration) {}
 	s.exchange = m
 
+	path := filepath.Join(t.TempDir(), "ledger.jsonl")
+	s.ledger, _ = openLedger(path)
+	s.req.historyFallback = true
+
 	m.EXPECT().LastPurchaseTime("BTC", "USD", gomock.Any()).Return(nil, nil)
 	m.EXPECT().GetFiatAccount("USD").Return(&exchanges.Account{Available: 25}, nil)
 	m.EXPECT().GetPendingTransfers("USD").Return([]exchanges.PendingTransfer{}, nil)
@@ -321,4 +383,863 @@ func TestSyncWhenDebugIsOn(t *testing.T) {
 	err := s.Sync()
 
 	assert.Nil(t, err)
+
+	//the skipped trades of a dry run are not recorded
+	assert.Empty(t, s.ledger.entries)
+	assert.NoFileExists(t, path)
+}
+
+func TestNextPurchaseTime(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockExchange(ctrl)
+
+	s := gdaxSchedule{}
+	s.logger = loggerStub(t).Sugar()
+	s.req = syncRequest{every: 24 * time.Hour, currency: "USD"} // setup run every 24 hrs
+	s.coins = map[string]orderDetails{"BTC": {symbol: "btcusd", amount: 50}}
+	s.exchange = m
+
+	t.Run("when recent purchase", func(t *testing.T) {
+		lastPurchaseTime := time.Now().Add(-12 * time.Hour) //last purchase time 12 hrs ago
+		m.EXPECT().LastPurchaseTime("BTC", "USD", gomock.Any()).Return(&lastPurchaseTime, nil)
+
+		next, err := s.nextPurchaseTime()
+
+		assert.Nil(t, err)
+		assert.WithinDuration(t, lastPurchaseTime.Add(24*time.Hour), next, time.Second)
+	})
+
+	t.Run("when no recent purchase", func(t *testing.T) {
+		m.EXPECT().LastPurchaseTime("BTC", "USD", gomock.Any()).Return(nil, nil)
+
+		next, err := s.nextPurchaseTime()
+
+		assert.Nil(t, err)
+		assert.WithinDuration(t, time.Now(), next, time.Second)
+	})
+
+	t.Run("when configured to start later", func(t *testing.T) {
+		s.req.after = time.Now().AddDate(0, 0, 3)
+		m.EXPECT().LastPurchaseTime("BTC", "USD", gomock.Any()).Return(nil, nil)
+
+		next, err := s.nextPurchaseTime()
+
+		assert.Nil(t, err)
+		assert.Equal(t, s.req.after, next)
+	})
+}
+
+func TestSyncWithLedger(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockExchange(ctrl)
+
+	l, _ := openLedger("")
+
+	s := gdaxSchedule{}
+	s.logger = loggerStub(t).Sugar()
+	s.req = syncRequest{exchange: "coinbase", every: 24 * time.Hour, orderType: exchanges.Market, currency: "USD", usd: 50, historyFallback: true} // setup run every 24 hrs
+	s.coins = map[string]orderDetails{"BTC": {symbol: "btcusd", amount: 50}}
+	s.sleepFunc = func(d time.Duration) {}
+	s.ledger = l
+	s.exchange = m
+
+	t.Run("when ledger is empty falls back to exchange history", func(t *testing.T) {
+		result := exchanges.Order{OrderID: "1"}
+
+		m.EXPECT().LastPurchaseTime("BTC", "USD", gomock.Any()).Return(nil, nil)
+		m.EXPECT().GetFiatAccount("USD").Return(&exchanges.Account{Available: 50}, nil)
+		m.EXPECT().CreateOrder("btcusd", gomock.Any(), 50.0, exchanges.Market, gomock.Any()).Return(&result, nil)
+
+		err := s.Sync()
+
+		assert.Nil(t, err)
+		assert.Len(t, l.entries, 1)
+		assert.Equal(t, ledgerOrder, l.entries[0].Kind)
+		assert.Equal(t, "1", l.entries[0].OrderId)
+		assert.Equal(t, "BTC", l.entries[0].Coin)
+	})
+
+	t.Run("when ledger has a recent purchase", func(t *testing.T) {
+		err := s.Sync()
+
+		assert.Equal(t, "Detected a recent purchase, waiting for next purchase window", err.Error())
+	})
+
+	t.Run("when window is skipped", func(t *testing.T) {
+		l.entries[0].Time = time.Now().Add(-48 * time.Hour)
+
+		m.EXPECT().GetFiatAccount("USD").Return(&exchanges.Account{Available: 25}, nil)
+		m.EXPECT().GetPendingTransfers("USD").Return([]exchanges.PendingTransfer{}, nil)
+
+		err := s.Sync()
+
+		assert.Equal(t, "No sufficient amount for trade and autofund is disabled. Deposit money to proceed", err.Error())
+		assert.Len(t, l.entries, 2)
+		assert.Equal(t, ledgerSkip, l.entries[1].Kind)
+	})
+}
+
+func TestSyncConfirmsFills(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockExchange(ctrl)
+
+	l, _ := openLedger("")
+	slept := time.Duration(0)
+
+	s := gdaxSchedule{}
+	s.logger = loggerStub(t).Sugar()
+	s.req = syncRequest{exchange: "coinbase", every: 24 * time.Hour, orderType: exchanges.Market, currency: "USD", usd: 50, fillTimeout: 20 * time.Second}
+	s.coins = map[string]orderDetails{"BTC": {symbol: "BTC-USD", amount: 50}}
+	s.sleepFunc = func(d time.Duration) { slept += d }
+	s.ledger = l
+	s.exchange = m
+
+	t.Run("when order fills", func(t *testing.T) {
+		open := exchanges.Order{Symbol: "BTC-USD", OrderID: "1", Status: exchanges.OrderOpen}
+		filled := exchanges.Order{Symbol: "BTC-USD", OrderID: "1", Status: exchanges.OrderFilled, FilledSize: 0.002, AveragePrice: 24800, Fee: 0.4}
+
+		m.EXPECT().GetFiatAccount("USD").Return(&exchanges.Account{Available: 50}, nil)
+		m.EXPECT().CreateOrder("BTC-USD", gomock.Any(), 50.0, exchanges.Market, gomock.Any()).Return(&open, nil)
+		gomock.InOrder(
+			m.EXPECT().GetOrder("BTC-USD", "1").Return(&open, nil),
+			m.EXPECT().GetOrder("BTC-USD", "1").Return(&filled, nil),
+		)
+
+		err := s.Sync()
+
+		assert.Nil(t, err)
+		assert.Equal(t, 2*fillPollInterval, slept)
+		assert.Len(t, l.entries, 2)
+		assert.Equal(t, ledgerFill, l.entries[1].Kind)
+		assert.Equal(t, "1", l.entries[1].OrderId)
+		assert.Equal(t, 0.002, l.entries[1].Size)
+		assert.Equal(t, 24800.0, l.entries[1].Price)
+		assert.Equal(t, 0.4, l.entries[1].Fee)
+		assert.InDelta(t, 50.0, l.entries[1].Amount, 0.000001)
+	})
+
+	t.Run("when order doesn't fill within the timeout", func(t *testing.T) {
+		l.entries = nil
+		slept = 0
+		open := exchanges.Order{Symbol: "BTC-USD", OrderID: "2", Status: exchanges.OrderOpen}
+
+		m.EXPECT().GetFiatAccount("USD").Return(&exchanges.Account{Available: 50}, nil)
+		m.EXPECT().CreateOrder("BTC-USD", gomock.Any(), 50.0, exchanges.Market, gomock.Any()).Return(&open, nil)
+		m.EXPECT().GetOrder("BTC-USD", "2").Return(&open, nil).Times(4)
+
+		err := s.Sync()
+
+		assert.Nil(t, err)
+		assert.Equal(t, 4*fillPollInterval, slept)
+		assert.Len(t, l.entries, 1)
+		assert.Equal(t, ledgerOrder, l.entries[0].Kind)
+	})
+}
+
+func TestSyncRepricesUnfilledLimitOrders(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockExchange(ctrl)
+
+	l, _ := openLedger("")
+
+	s := gdaxSchedule{}
+	s.logger = loggerStub(t).Sugar()
+	s.req = syncRequest{exchange: "gemini", every: 24 * time.Hour, orderType: exchanges.Limit, currency: "USD", usd: 50, fillTimeout: 5 * time.Second, reprices: 1, unfilled: unfilledCancel}
+	s.coins = map[string]orderDetails{"BTC": {symbol: "btcusd", amount: 50}}
+	s.sleepFunc = func(d time.Duration) {}
+	s.ledger = l
+	s.exchange = m
+
+	kinds := func() []ledgerEntryKind {
+		kinds := []ledgerEntryKind{}
+		for _, e := range l.entries {
+			kinds = append(kinds, e.Kind)
+		}
+		return kinds
+	}
+
+	t.Run("when edited order fills", func(t *testing.T) {
+		l.entries = nil
+		open := exchanges.Order{Symbol: "btcusd", OrderID: "1", Status: exchanges.OrderOpen, FilledSize: 0.001, AveragePrice: 25000}
+		filled := exchanges.Order{Symbol: "btcusd", OrderID: "1", Status: exchanges.OrderFilled, FilledSize: 0.002, AveragePrice: 25000}
+
+		m.EXPECT().GetFiatAccount("USD").Return(&exchanges.Account{Available: 50}, nil)
+		m.EXPECT().CreateOrder("btcusd", gomock.Any(), 50.0, exchanges.Limit, gomock.Any()).Return(&open, nil)
+		m.EXPECT().GetOrder("btcusd", "1").Return(&open, nil)
+		m.EXPECT().GetTicker("btcusd").Return(&exchanges.Ticker{Price: 25000}, nil)
+		// 25.00 remaining buys 0.001 at 25000, on top of 0.001 filled
+		m.EXPECT().EditOrder("btcusd", "1", gomock.Any(), gomock.Any()).DoAndReturn(
+			func(productId string, orderId string, price decimal.Decimal, size decimal.Decimal) (*exchanges.Order, error) {
+				assert.Equal(t, "25000", price.String())
+				assert.Equal(t, "0.002", size.String())
+				return &filled, nil
+			})
+
+		err := s.Sync()
+
+		assert.Nil(t, err)
+		assert.Equal(t, []ledgerEntryKind{ledgerOrder, ledgerFill}, kinds())
+		assert.Equal(t, 0.002, l.entries[1].Size)
+	})
+
+	t.Run("when exchange cancels and replaces", func(t *testing.T) {
+		l.entries = nil
+		open := exchanges.Order{Symbol: "btcusd", OrderID: "2", Status: exchanges.OrderOpen}
+		cancelled := exchanges.Order{Symbol: "btcusd", OrderID: "2", Status: exchanges.OrderCancelled, FilledSize: 0.001, AveragePrice: 20000}
+		replaced := exchanges.Order{Symbol: "btcusd", OrderID: "3", Status: exchanges.OrderFilled, FilledSize: 0.0012, AveragePrice: 25000}
+
+		m.EXPECT().CreateOrder("btcusd", gomock.Any(), 50.0, exchanges.Limit, gomock.Any()).Return(&open, nil)
+		m.EXPECT().GetOrder("btcusd", "2").Return(&open, nil)
+		m.EXPECT().GetTicker("btcusd").Return(&exchanges.Ticker{Price: 25000}, nil)
+		m.EXPECT().EditOrder("btcusd", "2", gomock.Any(), gomock.Any()).Return(nil, exchanges.ErrEditNotSupported)
+		m.EXPECT().CancelOrder("btcusd", "2").Return(&cancelled, nil)
+		m.EXPECT().CreateOrder("btcusd", gomock.Any(), 30.0, exchanges.Limit, gomock.Any()).Return(&replaced, nil)
+
+		placed, err := s.makePurchase("BTC", "btcusd", 50)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []ledgerEntryKind{ledgerOrder, ledgerOrder, ledgerFill, ledgerFill}, kinds())
+		assert.Equal(t, "3", placed.OrderID)
+		assert.Equal(t, exchanges.OrderFilled, placed.Status)
+		assert.InDelta(t, 0.0022, placed.FilledSize, 0.0000001)
+		assert.InDelta(t, 50.0, placed.FilledValue(), 0.000001)
+	})
+
+	t.Run("when still unfilled after re-pricing", func(t *testing.T) {
+		l.entries = nil
+		open := exchanges.Order{Symbol: "btcusd", OrderID: "4", Status: exchanges.OrderOpen}
+		cancelled := exchanges.Order{Symbol: "btcusd", OrderID: "4", Status: exchanges.OrderCancelled, FilledSize: 0.001, AveragePrice: 20000}
+
+		m.EXPECT().CreateOrder("btcusd", gomock.Any(), 50.0, exchanges.Limit, gomock.Any()).Return(&open, nil)
+		m.EXPECT().GetOrder("btcusd", "4").Return(&open, nil).Times(2)
+		m.EXPECT().GetTicker("btcusd").Return(&exchanges.Ticker{Price: 25000}, nil)
+		m.EXPECT().EditOrder("btcusd", "4", gomock.Any(), gomock.Any()).Return(&open, nil)
+		m.EXPECT().CancelOrder("btcusd", "4").Return(&cancelled, nil)
+
+		placed, err := s.makePurchase("BTC", "btcusd", 50)
+
+		assert.Nil(t, err)
+		assert.Equal(t, exchanges.OrderCancelled, placed.Status)
+		assert.Equal(t, []ledgerEntryKind{ledgerOrder, ledgerSkip, ledgerFill}, kinds())
+		assert.Equal(t, 30.0, l.entries[1].Amount)
+		assert.Equal(t, "Limit order 4 is not filled after 1 re-prices, cancelled", l.entries[1].Reason)
+	})
+
+	t.Run("when the rest is bought at market", func(t *testing.T) {
+		l.entries = nil
+		s.req.unfilled = unfilledMarket
+		s.req.reprices = 0
+		defer func() { s.req.unfilled = unfilledCancel; s.req.reprices = 1 }()
+
+		open := exchanges.Order{Symbol: "btcusd", OrderID: "5", Status: exchanges.OrderOpen}
+		cancelled := exchanges.Order{Symbol: "btcusd", OrderID: "5", Status: exchanges.OrderCancelled}
+		market := exchanges.Order{Symbol: "btcusd", OrderID: "6", Status: exchanges.OrderFilled, FilledSize: 0.002, AveragePrice: 24900, Fee: 0.2}
+
+		m.EXPECT().CreateOrder("btcusd", gomock.Any(), 50.0, exchanges.Limit, gomock.Any()).Return(&open, nil)
+		m.EXPECT().GetOrder("btcusd", "5").Return(&open, nil)
+		m.EXPECT().CancelOrder("btcusd", "5").Return(&cancelled, nil)
+		m.EXPECT().CreateOrder("btcusd", gomock.Any(), 50.0, exchanges.Market, gomock.Any()).Return(&market, nil)
+
+		placed, err := s.makePurchase("BTC", "btcusd", 50)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "6", placed.OrderID)
+		//the cancelled order is recorded as done so caps stop counting it
+		assert.Equal(t, []ledgerEntryKind{ledgerOrder, ledgerOrder, ledgerFill, ledgerFill}, kinds())
+		assert.Zero(t, l.entries[2].Size)
+		assert.True(t, l.entries[2].Done)
+	})
+}
+
+func TestSyncMakerOrders(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockExchange(ctrl)
+
+	l, _ := openLedger("")
+
+	s := gdaxSchedule{}
+	s.logger = loggerStub(t).Sugar()
+	s.req = syncRequest{exchange: "coinbase", every: 24 * time.Hour, orderType: exchanges.Maker, currency: "USD", usd: 50, makerTimeout: 10 * time.Second, fillTimeout: 5 * time.Second, unfilled: unfilledCancel}
+	s.coins = map[string]orderDetails{"BTC": {symbol: "BTC-USD", amount: 50}}
+	s.sleepFunc = func(d time.Duration) {}
+	s.ledger = l
+	s.exchange = m
+
+	t.Run("when maker order fills", func(t *testing.T) {
+		l.entries = nil
+		open := exchanges.Order{Symbol: "BTC-USD", OrderID: "1", Status: exchanges.OrderOpen}
+		filled := exchanges.Order{Symbol: "BTC-USD", OrderID: "1", Status: exchanges.OrderFilled, FilledSize: 0.002, AveragePrice: 24900, Fee: 0.02}
+
+		m.EXPECT().CreateOrder("BTC-USD", gomock.Any(), 50.0, exchanges.Maker, gomock.Any()).Return(&open, nil)
+		gomock.InOrder(
+			m.EXPECT().GetOrder("BTC-USD", "1").Return(&open, nil),
+			m.EXPECT().GetOrder("BTC-USD", "1").Return(&filled, nil),
+		)
+
+		placed, err := s.makePurchase("BTC", "BTC-USD", 50)
+
+		assert.Nil(t, err)
+		assert.Equal(t, filled, *placed)
+		assert.Len(t, l.entries, 2)
+	})
+
+	t.Run("when maker order times out", func(t *testing.T) {
+		l.entries = nil
+		open := exchanges.Order{Symbol: "BTC-USD", OrderID: "2", Status: exchanges.OrderOpen}
+		cancelled := exchanges.Order{Symbol: "BTC-USD", OrderID: "2", Status: exchanges.OrderCancelled, FilledSize: 0.001, AveragePrice: 24900, Fee: 0.01}
+		taker := exchanges.Order{Symbol: "BTC-USD", OrderID: "3", Status: exchanges.OrderFilled, FilledSize: 0.001, AveragePrice: 25000, Fee: 0.05}
+
+		m.EXPECT().CreateOrder("BTC-USD", gomock.Any(), 50.0, exchanges.Maker, gomock.Any()).Return(&open, nil)
+		m.EXPECT().GetOrder("BTC-USD", "2").Return(&open, nil).Times(2)
+		m.EXPECT().CancelOrder("BTC-USD", "2").Return(&cancelled, nil)
+		m.EXPECT().CreateOrder("BTC-USD", gomock.Any(), 25.09, exchanges.Limit, gomock.Any()).Return(&taker, nil)
+
+		placed, err := s.makePurchase("BTC", "BTC-USD", 50)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "3", placed.OrderID)
+		assert.Equal(t, exchanges.OrderFilled, placed.Status)
+		assert.Equal(t, 0.002, placed.FilledSize)
+		assert.InDelta(t, 24950.0, placed.AveragePrice, 0.000001)
+		assert.InDelta(t, 0.06, placed.Fee, 0.000001)
+
+		kinds := []ledgerEntryKind{}
+		for _, e := range l.entries {
+			kinds = append(kinds, e.Kind)
+		}
+		assert.Equal(t, []ledgerEntryKind{ledgerOrder, ledgerOrder, ledgerFill, ledgerFill}, kinds)
+	})
+
+	t.Run("when maker order is rejected", func(t *testing.T) {
+		l.entries = nil
+		taker := exchanges.Order{Symbol: "BTC-USD", OrderID: "4", Status: exchanges.OrderFilled, FilledSize: 0.002, AveragePrice: 25000, Fee: 0.1}
+
+		m.EXPECT().CreateOrder("BTC-USD", gomock.Any(), 50.0, exchanges.Maker, gomock.Any()).Return(nil, fmt.Errorf("order failed with INVALID_LIMIT_PRICE_POST_ONLY: %w", exchanges.ErrPostOnlyRejected))
+		m.EXPECT().CreateOrder("BTC-USD", gomock.Any(), 50.0, exchanges.Limit, gomock.Any()).Return(&taker, nil)
+
+		placed, err := s.makePurchase("BTC", "BTC-USD", 50)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "4", placed.OrderID)
+		assert.Len(t, l.entries, 2)
+	})
+
+	t.Run("when maker order fails otherwise", func(t *testing.T) {
+		l.entries = nil
+
+		m.EXPECT().CreateOrder("BTC-USD", gomock.Any(), 50.0, exchanges.Maker, gomock.Any()).Return(nil, errors.New("order failed with INSUFFICIENT_FUND"))
+
+		_, err := s.makePurchase("BTC", "BTC-USD", 50)
+
+		assert.EqualError(t, err, "order failed with INSUFFICIENT_FUND")
+		assert.Len(t, l.entries, 0)
+	})
+}
+
+func TestNewScheduleLooksUpFees(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockExchange(ctrl)
+	req := syncRequest{every: 24 * time.Hour, orderType: exchanges.Limit, currency: "USD", usd: 50, coins: []string{"BTC:100"}, fee: 0.5, lookupFees: true}
+
+	expectProduct := func() {
+		m.EXPECT().GetTickerSymbol("BTC", "USD").Return("BTC-USD")
+		m.EXPECT().GetProduct("BTC-USD").Return(&exchanges.Product{BaseMinSize: 0.0001}, nil)
+		m.EXPECT().GetTicker("BTC-USD").Return(&exchanges.Ticker{Price: 1000}, nil)
+	}
+
+	t.Run("when exchange tells the rates", func(t *testing.T) {
+		expectProduct()
+		m.EXPECT().GetFeeRates().Return(&exchanges.FeeRates{Maker: 0.4, Taker: 0.6}, nil)
+
+		s, err := newGdaxSchedule(m, loggerStub(t).Sugar(), false, req, nil)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 0.6, s.takerFee())
+		assert.Equal(t, 0.4, s.makerFee())
+
+		_, size := s.calcLimitOrder(decimal.NewFromInt(100), decimal.NewFromInt(100))
+		assert.Equal(t, "0.994", size.String())
+	})
+
+	t.Run("when rates are unknown", func(t *testing.T) {
+		expectProduct()
+		m.EXPECT().GetFeeRates().Return(nil, errors.New("forbidden"))
+
+		s, err := newGdaxSchedule(m, loggerStub(t).Sugar(), false, req, nil)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 0.5, s.takerFee())
+		assert.Equal(t, 0.5, s.makerFee())
+	})
+
+	t.Run("when --fee overrides", func(t *testing.T) {
+		expectProduct()
+		override := req
+		override.lookupFees = false
+		override.fee = 0.1
+
+		s, err := newGdaxSchedule(m, loggerStub(t).Sugar(), false, override, nil)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 0.1, s.takerFee())
+	})
+}
+
+func TestBookLimitOrder(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockExchange(ctrl)
+
+	s := gdaxSchedule{}
+	s.logger = loggerStub(t).Sugar()
+	s.req = syncRequest{fee: 0.5, pricing: pricingBook, bookBuffer: 0.1, maxSlippage: 1}
+	s.exchange = m
+
+	book := &exchanges.OrderBook{
+		Bids: []exchanges.BookLevel{{Price: 19990, Size: 1}},
+		Asks: []exchanges.BookLevel{{Price: 20010, Size: 0.001}, {Price: 20100, Size: 0.01}, {Price: 22000, Size: 1}},
+	}
+
+	t.Run("when the book is deep enough", func(t *testing.T) {
+		m.EXPECT().GetOrderBook("BTC-USD").Return(book, nil)
+
+		calc, err := s.bookLimitOrder("BTC-USD", 100)
+		assert.Nil(t, err)
+
+		// the ask is ignored, 100 reaches the second level
+		price, size := calc(decimal.NewFromInt(1), decimal.NewFromInt(100))
+		assert.Equal(t, "20120.1", price.String())
+		assert.Equal(t, "0.00494530", size.StringFixed(8))
+	})
+
+	t.Run("when slippage is too high", func(t *testing.T) {
+		m.EXPECT().GetOrderBook("BTC-USD").Return(book, nil)
+
+		_, err := s.bookLimitOrder("BTC-USD", 1000)
+		assert.Equal(t, "slippage 7.74% versus mid price 20000.00 is above --max-slippage 1.00%", err.Error())
+	})
+
+	t.Run("when placing an order", func(t *testing.T) {
+		l, _ := openLedger("")
+		s.ledger = l
+		s.sleepFunc = func(time.Duration) {}
+		s.req.orderType = exchanges.Limit
+		defer func() { s.ledger = nil }()
+
+		m.EXPECT().GetOrderBook("BTC-USD").Return(book, nil)
+		m.EXPECT().CreateOrder("BTC-USD", gomock.Any(), 100.0, exchanges.Limit, gomock.Any()).DoAndReturn(
+			func(productId string, clientOrderId string, amount float64, orderType exchanges.OrderTypeType, calc exchanges.CalcLimitOrder) (*exchanges.Order, error) {
+				price, _ := calc(decimal.NewFromInt(20010), decimal.NewFromFloat(amount))
+				assert.Equal(t, "20120.1", price.String())
+				return &exchanges.Order{Symbol: "BTC-USD", OrderID: "1", Status: exchanges.OrderFilled, FilledSize: 0.0049453, AveragePrice: 20080}, nil
+			})
+
+		placed, err := s.makePurchase("BTC", "BTC-USD", 100)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "1", placed.OrderID)
+	})
+}
+
+func TestSliceAmounts(t *testing.T) {
+	s := gdaxSchedule{req: syncRequest{slices: 3}}
+
+	assert.Equal(t, []float64{33.33, 33.33, 33.34}, s.sliceAmounts(100, 1))
+	// 25 allows only two slices of at least 10
+	assert.Equal(t, []float64{12.5, 12.5}, s.sliceAmounts(25, 10))
+	assert.Equal(t, []float64{15}, s.sliceAmounts(15, 10))
+
+	s.req.slices = 0
+	assert.Equal(t, []float64{100}, s.sliceAmounts(100, 1))
+}
+
+func TestSliceDelays(t *testing.T) {
+	assert.Equal(t, []time.Duration{0}, sliceDelays(1, 2*time.Hour, false, nil))
+	assert.Equal(t, []time.Duration{0, 40 * time.Minute, 80 * time.Minute}, sliceDelays(3, 2*time.Hour, false, nil))
+
+	random := []int64{int64(90 * time.Minute), int64(10 * time.Minute)}
+	int63n := func(n int64) int64 {
+		assert.Equal(t, int64(2*time.Hour), n)
+		r := random[0]
+		random = random[1:]
+		return r
+	}
+	assert.Equal(t, []time.Duration{0, 10 * time.Minute, 90 * time.Minute}, sliceDelays(3, 2*time.Hour, true, int63n))
+}
+
+func TestSyncSlicesPurchases(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockExchange(ctrl)
+
+	l, _ := openLedger("")
+	slept := []time.Duration{}
+
+	s := gdaxSchedule{}
+	s.logger = loggerStub(t).Sugar()
+	s.req = syncRequest{exchange: "coinbase", every: 24 * time.Hour, orderType: exchanges.Market, currency: "USD", usd: 100, slices: 2, sliceOver: 2 * time.Hour}
+	s.coins = map[string]orderDetails{"BTC": {symbol: "BTC-USD", amount: 60, minimum: 1}, "ETH": {symbol: "ETH-USD", amount: 40, minimum: 1}}
+	s.sleepFunc = func(d time.Duration) { slept = append(slept, d) }
+	s.ledger = l
+	s.exchange = m
+
+	filled := func(productId string, id string, size float64, price float64) *exchanges.Order {
+		return &exchanges.Order{Symbol: productId, OrderID: id, Status: exchanges.OrderFilled, FilledSize: size, AveragePrice: price, Fee: 0.1}
+	}
+
+	m.EXPECT().GetFiatAccount("USD").Return(&exchanges.Account{Available: 100}, nil)
+	gomock.InOrder(
+		m.EXPECT().CreateOrder("BTC-USD", gomock.Any(), 30.0, exchanges.Market, gomock.Any()).Return(filled("BTC-USD", "1", 0.001, 29900), nil),
+		m.EXPECT().CreateOrder("ETH-USD", gomock.Any(), 20.0, exchanges.Market, gomock.Any()).Return(filled("ETH-USD", "2", 0.01, 1990), nil),
+		m.EXPECT().CreateOrder("BTC-USD", gomock.Any(), 30.0, exchanges.Market, gomock.Any()).Return(filled("BTC-USD", "3", 0.001, 29700), nil),
+		m.EXPECT().CreateOrder("ETH-USD", gomock.Any(), 20.0, exchanges.Market, gomock.Any()).Return(nil, errors.New("insufficient funds")),
+	)
+
+	err := s.Sync()
+
+	assert.Nil(t, err)
+	assert.Equal(t, []time.Duration{time.Hour}, slept)
+
+	kinds := []ledgerEntryKind{}
+	for _, e := range l.entries {
+		kinds = append(kinds, e.Kind)
+	}
+	assert.Equal(t, []ledgerEntryKind{
+		ledgerOrder, ledgerFill, ledgerOrder, ledgerFill,
+		ledgerOrder, ledgerFill, ledgerSkip, ledgerPurchase,
+	}, kinds)
+
+	skip := l.entries[6]
+	assert.Equal(t, "ETH", skip.Coin)
+	assert.Equal(t, 20.0, skip.Amount)
+
+	purchase := l.entries[7]
+	assert.Equal(t, "BTC", purchase.Coin)
+	assert.Equal(t, []string{"1", "3"}, purchase.OrderIds)
+	assert.Equal(t, 0.002, purchase.Size)
+	assert.InDelta(t, 29800.0, purchase.Price, 0.000001)
+	assert.InDelta(t, 0.2, purchase.Fee, 0.000001)
+	assert.InDelta(t, 59.8, purchase.Amount, 0.000001)
+}
+
+func TestNewScheduleWithCoinCadence(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockExchange(ctrl)
+
+	t.Run("when success", func(t *testing.T) {
+		req := syncRequest{every: 24 * time.Hour, orderType: exchanges.Market, currency: "USD", usd: 50, coins: []string{"BTC:50:7d", "ETH:50"}}
+
+		m.EXPECT().GetTickerSymbol("BTC", "USD").Return("BTC:USD")
+		m.EXPECT().GetProduct("BTC:USD").Return(&exchanges.Product{BaseMinSize: 0.001}, nil)
+		m.EXPECT().GetTicker("BTC:USD").Return(&exchanges.Ticker{Price: 1000}, nil)
+
+		m.EXPECT().GetTickerSymbol("ETH", "USD").Return("ETH:USD")
+		m.EXPECT().GetProduct("ETH:USD").Return(&exchanges.Product{BaseMinSize: 0.5}, nil)
+		m.EXPECT().GetTicker("ETH:USD").Return(&exchanges.Ticker{Price: 10}, nil)
+
+		s, err := newGdaxSchedule(m, loggerStub(t).Sugar(), false, req, nil)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 7*24*time.Hour, s.everyFor("BTC"))
+		assert.Equal(t, 24*time.Hour, s.everyFor("ETH"))
+	})
+
+	t.Run("when cadence is misformatted", func(t *testing.T) {
+		req := syncRequest{every: 24 * time.Hour, orderType: exchanges.Market, currency: "USD", usd: 50, coins: []string{"BTC:100:weekly"}}
+
+		s, err := newGdaxSchedule(m, loggerStub(t).Sugar(), false, req, nil)
+
+		assert.Nil(t, s)
+		assert.Equal(t, "--coin BTC:100:weekly cadence misformatted, e.g. 1h, 7d, 3w", err.Error())
+	})
+}
+
+func TestSyncBuysOnlyDueCoins(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockExchange(ctrl)
+
+	s := gdaxSchedule{}
+	s.logger = loggerStub(t).Sugar()
+	s.req = syncRequest{every: 24 * time.Hour, orderType: exchanges.Market, currency: "USD", usd: 100} // setup run every 24 hrs
+	s.coins = map[string]orderDetails{
+		"BTC": {symbol: "btcusd", amount: 50, every: 7 * 24 * time.Hour},
+		"ETH": {symbol: "ethusd", amount: 50},
+	}
+	s.sleepFunc = func(d time.Duration) {}
+	s.exchange = m
+
+	//both were bought 2 days ago, only ETH bought daily is due
+	lastPurchaseTime := time.Now().Add(-48 * time.Hour)
+	result := exchanges.Order{OrderID: "1"}
+
+	m.EXPECT().LastPurchaseTime("BTC", "USD", gomock.Any()).Return(&lastPurchaseTime, nil).Times(2)
+	m.EXPECT().LastPurchaseTime("ETH", "USD", gomock.Any()).Return(&lastPurchaseTime, nil).Times(2)
+	m.EXPECT().GetFiatAccount("USD").Return(&exchanges.Account{Available: 50}, nil)
+	m.EXPECT().CreateOrder("ethusd", gomock.Any(), 50.0, exchanges.Market, gomock.Any()).Return(&result, nil)
+
+	err := s.Sync()
+	assert.Nil(t, err)
+
+	next, err := s.nextPurchaseTime()
+	assert.Nil(t, err)
+	assert.WithinDuration(t, lastPurchaseTime.Add(24*time.Hour), next, time.Second)
+}
+
+func TestSyncAgainstFakeCoinbase(t *testing.T) {
+	t.Setenv("COINBASE_KEY", "key")
+	t.Setenv("COINBASE_SECRET", "secret")
+
+	server := coinbasefake.NewServer()
+	defer server.Close()
+
+	server.SetAccount("USD", 20)
+	server.SetProduct("BTC-USD", 20000, 0.0001)
+	server.SetProduct("ETH-USD", 1000, 0.001)
+	server.AddPaymentMethod(coinbasev3.PaymentMethod{ID: "bank", Type: "ACH", Currency: "USD", AllowDeposit: true})
+
+	exchange, err := exchanges.NewCoinbaseV3()
+	assert.Nil(t, err)
+	exchange.SetBaseUrlV3(server.UrlV3())
+	exchange.SetBaseUrlV2(server.UrlV2())
+
+	purchases, err := openLedger("")
+	assert.Nil(t, err)
+
+	s, err := newGdaxSchedule(exchange, loggerStub(t).Sugar(), false, syncRequest{
+		exchange:        "coinbase",
+		coins:           []string{"BTC:70", "ETH:30"},
+		usd:             100,
+		every:           24 * time.Hour,
+		currency:        "USD",
+		orderType:       exchanges.Market,
+		autoFund:        true,
+		historyFallback: true,
+		fillTimeout:     time.Minute,
+	}, purchases)
+	assert.Nil(t, err)
+	s.sleepFunc = func(d time.Duration) {}
+
+	assert.Nil(t, s.Sync())
+
+	deposits := server.Deposits()
+	assert.Len(t, deposits, 1)
+	assert.Equal(t, 80.0, deposits[0].Amount)
+
+	orders := server.Orders()
+	assert.Len(t, orders, 2)
+	assert.Equal(t, "BTC-USD", orders[0].ProductId)
+	assert.Equal(t, "70.00", orders[0].OrderConfiguration.MarketMarketIoc.QuoteSize)
+	assert.Equal(t, "ETH-USD", orders[1].ProductId)
+	assert.Equal(t, "30.00", orders[1].OrderConfiguration.MarketMarketIoc.QuoteSize)
+
+	fills := []ledgerEntry{}
+	for _, e := range purchases.entries {
+		if e.Kind == ledgerFill {
+			fills = append(fills, e)
+		}
+	}
+	assert.Len(t, fills, 2)
+	assert.Equal(t, orders[0].OrderId, fills[0].OrderId)
+	assert.Equal(t, 0.0035, fills[0].Size)
+	assert.Equal(t, 20000.0, fills[0].Price)
+
+	usd, _ := server.Balance("USD")
+	assert.Equal(t, 0.0, usd)
+	btc, _ := server.Balance("BTC")
+	assert.Equal(t, 0.0035, btc)
+
+	// next run is within the window
+	assert.Equal(t, "Detected a recent purchase, waiting for next purchase window", s.Sync().Error())
+	assert.Len(t, server.Orders(), 2)
+}
+
+func TestSyncRepricesLimitOrderAgainstFakeCoinbase(t *testing.T) {
+	t.Setenv("COINBASE_KEY", "key")
+	t.Setenv("COINBASE_SECRET", "secret")
+
+	server := coinbasefake.NewServer()
+	defer server.Close()
+
+	server.SetAccount("USD", 100)
+	server.SetProduct("BTC-USD", 20000, 0.0001)
+
+	exchange, err := exchanges.NewCoinbaseV3()
+	assert.Nil(t, err)
+	exchange.SetBaseUrlV3(server.UrlV3())
+	exchange.SetBaseUrlV2(server.UrlV2())
+
+	purchases, err := openLedger("")
+	assert.Nil(t, err)
+
+	s, err := newGdaxSchedule(exchange, loggerStub(t).Sugar(), false, syncRequest{
+		exchange:  "coinbase",
+		coins:     []string{"BTC:100"},
+		usd:       100,
+		every:     24 * time.Hour,
+		currency:  "USD",
+		orderType: exchanges.Limit,
+		// bid below the ask so the order sits on the book
+		orderSpread: -1,
+		fee:         0.5,
+		fillTimeout: 5 * time.Second,
+		reprices:    1,
+		unfilled:    unfilledMarket,
+	}, purchases)
+	assert.Nil(t, err)
+	s.sleepFunc = func(d time.Duration) {}
+
+	assert.Nil(t, s.Sync())
+
+	orders := server.Orders()
+	assert.Len(t, orders, 2)
+
+	limit := orders[0]
+	assert.Equal(t, coinbasefake.StatusCancelled, limit.Status)
+	assert.Equal(t, "19800", limit.OrderConfiguration.LimitLimitGtc.LimitPrice)
+	assert.Equal(t, "0.00502525", limit.OrderConfiguration.LimitLimitGtc.BaseSize)
+	assert.Len(t, limit.EditHistory, 1)
+
+	market := orders[1]
+	assert.Equal(t, coinbasefake.StatusFilled, market.Status)
+	assert.Equal(t, "100.00", market.OrderConfiguration.MarketMarketIoc.QuoteSize)
+
+	usd, hold := server.Balance("USD")
+	assert.Equal(t, 0.0, usd)
+	assert.Equal(t, 0.0, hold)
+	btc, _ := server.Balance("BTC")
+	assert.Equal(t, 0.005, btc)
+}
+
+func TestSyncMakerOrderAgainstFakeCoinbase(t *testing.T) {
+	t.Setenv("COINBASE_KEY", "key")
+	t.Setenv("COINBASE_SECRET", "secret")
+
+	server := coinbasefake.NewServer()
+	defer server.Close()
+
+	server.SetAccount("USD", 100)
+	server.SetProduct("BTC-USD", 20000, 0.0001)
+
+	exchange, err := exchanges.NewCoinbaseV3()
+	assert.Nil(t, err)
+	exchange.SetBaseUrlV3(server.UrlV3())
+	exchange.SetBaseUrlV2(server.UrlV2())
+
+	purchases, err := openLedger("")
+	assert.Nil(t, err)
+
+	s, err := newGdaxSchedule(exchange, loggerStub(t).Sugar(), false, syncRequest{
+		exchange:     "coinbase",
+		coins:        []string{"BTC:100"},
+		usd:          100,
+		every:        24 * time.Hour,
+		currency:     "USD",
+		orderType:    exchanges.Maker,
+		makerOffset:  1,
+		makerTimeout: time.Minute,
+		fillTimeout:  time.Minute,
+	}, purchases)
+	assert.Nil(t, err)
+	// the price comes down to the bid while waiting
+	s.sleepFunc = func(d time.Duration) { server.SetProduct("BTC-USD", 19700, 0.0001) }
+
+	assert.Nil(t, s.Sync())
+
+	orders := server.Orders()
+	assert.Len(t, orders, 1)
+	assert.Equal(t, coinbasefake.StatusFilled, orders[0].Status)
+	assert.True(t, orders[0].OrderConfiguration.LimitLimitGtc.PostOnly)
+	assert.Equal(t, "19800", orders[0].OrderConfiguration.LimitLimitGtc.LimitPrice)
+
+	btc, _ := server.Balance("BTC")
+	assert.Equal(t, 0.00505050, btc)
+}
+
+func TestSyncRetriedInTheSameWindowAgainstFakeCoinbase(t *testing.T) {
+	t.Setenv("COINBASE_KEY", "key")
+	t.Setenv("COINBASE_SECRET", "secret")
+
+	server := coinbasefake.NewServer()
+	defer server.Close()
+
+	server.SetAccount("USD", 200)
+	server.SetProduct("BTC-USD", 20000, 0.0001)
+
+	exchange, err := exchanges.NewCoinbaseV3()
+	assert.Nil(t, err)
+	exchange.SetBaseUrlV3(server.UrlV3())
+	exchange.SetBaseUrlV2(server.UrlV2())
+
+	now := time.Now()
+	run := func() {
+		// every run starts with an empty ledger, as if the previous one crashed before recording its order
+		purchases, err := openLedger("")
+		assert.Nil(t, err)
+
+		s, err := newGdaxSchedule(exchange, loggerStub(t).Sugar(), false, syncRequest{
+			plan:        "weekly",
+			exchange:    "coinbase",
+			coins:       []string{"BTC:100"},
+			usd:         100,
+			every:       24 * time.Hour,
+			currency:    "USD",
+			orderType:   exchanges.Market,
+			fillTimeout: time.Minute,
+		}, purchases)
+		assert.Nil(t, err)
+		s.sleepFunc = func(d time.Duration) {}
+		s.nowFunc = func() time.Time { return now }
+
+		assert.Nil(t, s.Sync())
+	}
+
+	run()
+	run()
+
+	orders := server.Orders()
+	assert.Len(t, orders, 1)
+
+	usd, _ := server.Balance("USD")
+	assert.Equal(t, 100.0, usd)
+}
+
+func TestClientOrderId(t *testing.T) {
+	window := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	s := gdaxSchedule{}
+	s.req = syncRequest{plan: "weekly"}
+	s.windows = map[string]time.Time{"BTC": window, "ETH": window}
+
+	first := s.clientOrderId("BTC", 0, 0)
+	assert.Equal(t, first, s.clientOrderId("BTC", 0, 0))
+	assert.NotEqual(t, first, s.clientOrderId("ETH", 0, 0))
+
+	assert.NotEqual(t, first, s.clientOrderId("BTC", 0, 1))
+	assert.NotEqual(t, first, s.clientOrderId("BTC", 1, 0))
+	assert.NotEqual(t, s.clientOrderId("BTC", 0, 1), s.clientOrderId("BTC", 1, 0))
+
+	s.req.plan = "daily"
+	assert.NotEqual(t, first, s.clientOrderId("BTC", 0, 0))
+
+	s.req.plan = "weekly"
+	s.windows["BTC"] = window.Add(7 * 24 * time.Hour)
+	assert.NotEqual(t, first, s.clientOrderId("BTC", 0, 0))
 }
@@ -1,5 +1,5 @@
 
 docker rm -f dcagdax || true
 docker build -t dcagdax .
-docker run -d --name dcagdax -e TZ=America/Los_Angeles  --env-file .env --restart unless-stopped dcagdax
+docker run -d --name dcagdax -e TZ=America/Los_Angeles  --env-file .env -v dcagdax:/root/.dcagdax --restart unless-stopped dcagdax
 docker logs dcagdax --follow
\ No newline at end of file
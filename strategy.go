@@ -0,0 +1,251 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Strategy decides how much fiat to spend on a coin every time its purchase window opens.
+// Returning zero skips the window for the coin.
+type Strategy interface {
+	Amount(window purchaseWindow) (float64, error)
+}
+
+// purchaseWindow describes an open purchase window of a coin.
+type purchaseWindow struct {
+	coin  string
+	order orderDetails
+	every time.Duration
+	time  time.Time
+}
+
+func newStrategy(s *gdaxSchedule) (Strategy, error) {
+	switch s.req.strategy {
+	case "", "fixed":
+		return fixedStrategy{}, nil
+	case "value":
+		if s.req.after.IsZero() {
+			return nil, errors.New("value strategy requires --after date to count periods from")
+		}
+		return &valueStrategy{schedule: s, start: s.req.after}, nil
+	case "dip":
+		return newDipStrategy(s)
+	default:
+		return nil, fmt.Errorf("unsupported strategy %s", s.req.strategy)
+	}
+}
+
+// fixedStrategy spends the coin's share of --usd every window, this is classic dollar cost averaging.
+type fixedStrategy struct{}
+
+func (fixedStrategy) Amount(window purchaseWindow) (float64, error) {
+	return window.order.amount, nil
+}
+
+// valueStrategy implements value averaging, target value of the coin holdings grows by the coin's regular amount every period
+// since the start date and every window buys the difference between the target and the current value of the holdings.
+// Nothing is bought when holdings are above the target, purchases are capped by the coin's max.
+type valueStrategy struct {
+	schedule *gdaxSchedule
+	start    time.Time
+}
+
+func (v *valueStrategy) Amount(window purchaseWindow) (float64, error) {
+	s := v.schedule
+
+	account, err := s.exchange.GetCryptoAccount(window.coin)
+	if err != nil {
+		return 0, err
+	}
+
+	ticker, err := s.exchange.GetTicker(window.order.symbol)
+	if err != nil {
+		return 0, err
+	}
+
+	periods := int64(window.time.Sub(v.start)/window.every) + 1
+	if periods < 1 {
+		periods = 1
+	}
+
+	//amount * periods
+	target := decimal.NewFromFloat(window.order.amount).Mul(decimal.NewFromInt(periods))
+	//balance * price
+	value := decimal.NewFromFloat(account.Balance).Mul(decimal.NewFromFloat(ticker.Price))
+
+	amount, _ := target.Sub(value).Truncate(2).Float64()
+	amount = math.Min(math.Max(amount, 0), window.order.max)
+
+	s.logger.Infow(
+		"Value averaging",
+		"coin", window.coin,
+		"period", periods,
+		"target", target.StringFixed(2),
+		"value", value.StringFixed(2),
+		"amount", amount,
+	)
+
+	return amount, nil
+}
+
+// dipBand applies the multiplier when price deviates from the moving average by the percentage or more,
+// negative percentage is below the average and positive is above.
+type dipBand struct {
+	deviation  float64
+	multiplier float64
+}
+
+// dipStrategy scales the regular amount up when price is below the moving average and down when it's above,
+// purchases are capped by the coin's max.
+type dipStrategy struct {
+	schedule *gdaxSchedule
+	period   int
+	ema      bool
+	bands    []dipBand
+}
+
+func newDipStrategy(s *gdaxSchedule) (*dipStrategy, error) {
+	if s.req.maPeriod < 2 {
+		return nil, fmt.Errorf("moving average period must be at least 2 days, provided %d", s.req.maPeriod)
+	}
+
+	d := &dipStrategy{schedule: s, period: s.req.maPeriod}
+
+	switch s.req.maType {
+	case "", "sma":
+	case "ema":
+		d.ema = true
+	default:
+		return nil, fmt.Errorf("unsupported moving average type %s", s.req.maType)
+	}
+
+	for _, b := range s.req.dipBands {
+		arr := strings.Split(b, ":")
+		if len(arr) != 2 {
+			return nil, fmt.Errorf("--dip-band %s misformatted, expected PERCENTAGE:MULTIPLIER", b)
+		}
+
+		deviation, err := strconv.ParseFloat(arr[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("--dip-band %s misformatted, expected PERCENTAGE:MULTIPLIER", b)
+		}
+
+		multiplier, err := strconv.ParseFloat(arr[1], 64)
+		if err != nil || multiplier < 0 {
+			return nil, fmt.Errorf("--dip-band %s misformatted, expected PERCENTAGE:MULTIPLIER", b)
+		}
+
+		d.bands = append(d.bands, dipBand{deviation: deviation, multiplier: multiplier})
+	}
+
+	return d, nil
+}
+
+func (d *dipStrategy) Amount(window purchaseWindow) (float64, error) {
+	s := d.schedule
+
+	//ema needs extra history to settle
+	days := d.period + 1
+	if d.ema {
+		days = d.period * 3
+	}
+
+	candles, err := s.exchange.GetCandles(window.order.symbol, window.time.AddDate(0, 0, -days), window.time)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(candles) < d.period {
+		return 0, fmt.Errorf("not enough price history for %d days moving average of %s, got %d days", d.period, window.coin, len(candles))
+	}
+
+	closes := make([]float64, len(candles))
+	for i, c := range candles {
+		closes[i] = c.Close
+	}
+
+	average := simpleMovingAverage(closes, d.period)
+	if d.ema {
+		average = exponentialMovingAverage(closes, d.period)
+	}
+
+	ticker, err := s.exchange.GetTicker(window.order.symbol)
+	if err != nil {
+		return 0, err
+	}
+
+	//(price - average) / average * 100
+	deviation := (ticker.Price - average) / average * 100
+	multiplier := d.multiplier(deviation)
+
+	amount, _ := decimal.NewFromFloat(window.order.amount).Mul(decimal.NewFromFloat(multiplier)).Truncate(2).Float64()
+	amount = math.Min(amount, window.order.max)
+
+	s.logger.Infow(
+		"Buy the dip",
+		"coin", window.coin,
+		"price", ticker.Price,
+		"average", decimal.NewFromFloat(average).StringFixed(2),
+		"deviation", decimal.NewFromFloat(deviation).StringFixed(2),
+		"multiplier", multiplier,
+		"amount", amount,
+	)
+
+	return amount, nil
+}
+
+// multiplier picks the most extreme band the deviation reaches, 1 when none applies.
+func (d *dipStrategy) multiplier(deviation float64) float64 {
+	bands := append([]dipBand{}, d.bands...)
+	sort.Slice(bands, func(i, j int) bool {
+		return math.Abs(bands[i].deviation) > math.Abs(bands[j].deviation)
+	})
+
+	for _, b := range bands {
+		if b.deviation < 0 && deviation <= b.deviation {
+			return b.multiplier
+		}
+		if b.deviation >= 0 && deviation >= b.deviation {
+			return b.multiplier
+		}
+	}
+
+	return 1
+}
+
+// simpleMovingAverage of the last period values.
+func simpleMovingAverage(values []float64, period int) float64 {
+	if len(values) < period {
+		period = len(values)
+	}
+
+	sum := 0.0
+	for _, v := range values[len(values)-period:] {
+		sum += v
+	}
+
+	return sum / float64(period)
+}
+
+// exponentialMovingAverage of the values seeded with the simple average of the first period values.
+func exponentialMovingAverage(values []float64, period int) float64 {
+	if len(values) <= period {
+		return simpleMovingAverage(values, period)
+	}
+
+	k := 2 / float64(period+1)
+	average := simpleMovingAverage(values[:period], period)
+
+	for _, v := range values[period:] {
+		average = v*k + average*(1-k)
+	}
+
+	return average
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/sberserker/dcagdax/exchanges"
+	"github.com/sberserker/dcagdax/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+type strategyStub map[string]float64
+
+func (s strategyStub) Amount(window purchaseWindow) (float64, error) {
+	return s[window.coin], nil
+}
+
+func TestNewStrategy(t *testing.T) {
+	s := gdaxSchedule{}
+
+	strategy, err := newStrategy(&s)
+	assert.Nil(t, err)
+	assert.Equal(t, fixedStrategy{}, strategy)
+
+	s.req.strategy = "unknown"
+	_, err = newStrategy(&s)
+	assert.Equal(t, "unsupported strategy unknown", err.Error())
+}
+
+func TestSyncUsesStrategyAmounts(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockExchange(ctrl)
+
+	s := gdaxSchedule{}
+	s.logger = loggerStub(t).Sugar()
+	s.req = syncRequest{every: 24 * time.Hour, orderType: exchanges.Market, currency: "USD", usd: 100} // setup run every 24 hrs
+	s.coins = map[string]orderDetails{
+		"BTC": {symbol: "btcusd", amount: 50, minimum: 1},
+		"ETH": {symbol: "ethusd", amount: 50, minimum: 1},
+		"LTC": {symbol: "ltcusd", amount: 50, minimum: 1},
+	}
+	s.sleepFunc = func(d time.Duration) {}
+	s.exchange = m
+
+	t.Run("when strategy skips some coins", func(t *testing.T) {
+		s.strategy = strategyStub{"BTC": 75.129, "ETH": 0, "LTC": 0.5}
+		result := exchanges.Order{OrderID: "1"}
+
+		m.EXPECT().LastPurchaseTime(gomock.Any(), "USD", gomock.Any()).Return(nil, nil).Times(3)
+		m.EXPECT().GetFiatAccount("USD").Return(&exchanges.Account{Available: 100}, nil)
+		m.EXPECT().CreateOrder("btcusd", gomock.Any(), 75.12, exchanges.Market, gomock.Any()).Return(&result, nil)
+
+		err := s.Sync()
+
+		assert.Nil(t, err)
+	})
+
+	t.Run("when strategy skips all coins", func(t *testing.T) {
+		s.strategy = strategyStub{}
+
+		m.EXPECT().LastPurchaseTime(gomock.Any(), "USD", gomock.Any()).Return(nil, nil).Times(3)
+
+		err := s.Sync()
+
+		assert.Equal(t, "Strategy decided not to buy anything this window", err.Error())
+	})
+}
+
+func TestValueStrategy(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockExchange(ctrl)
+
+	s := gdaxSchedule{}
+	s.logger = loggerStub(t).Sugar()
+	s.exchange = m
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := valueStrategy{schedule: &s, start: start}
+
+	window := purchaseWindow{
+		coin:  "BTC",
+		order: orderDetails{symbol: "btcusd", amount: 50, max: 150},
+		every: 7 * 24 * time.Hour,
+		time:  start.AddDate(0, 0, 15), // third period, target is $150
+	}
+
+	t.Run("when holdings are below the target", func(t *testing.T) {
+		m.EXPECT().GetCryptoAccount("BTC").Return(&exchanges.Account{Balance: 0.5}, nil)
+		m.EXPECT().GetTicker("btcusd").Return(&exchanges.Ticker{Price: 100}, nil)
+
+		amount, err := v.Amount(window)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 100.0, amount)
+	})
+
+	t.Run("when difference is above the max", func(t *testing.T) {
+		m.EXPECT().GetCryptoAccount("BTC").Return(&exchanges.Account{Balance: 0}, nil)
+		m.EXPECT().GetTicker("btcusd").Return(&exchanges.Ticker{Price: 100}, nil)
+
+		amount, err := v.Amount(window)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 150.0, amount)
+	})
+
+	t.Run("when holdings are above the target", func(t *testing.T) {
+		m.EXPECT().GetCryptoAccount("BTC").Return(&exchanges.Account{Balance: 2}, nil)
+		m.EXPECT().GetTicker("btcusd").Return(&exchanges.Ticker{Price: 100}, nil)
+
+		amount, err := v.Amount(window)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 0.0, amount)
+	})
+
+	t.Run("when start date is missing", func(t *testing.T) {
+		s.req.strategy = "value"
+
+		_, err := newStrategy(&s)
+
+		assert.Equal(t, "value strategy requires --after date to count periods from", err.Error())
+	})
+}
+
+func TestMovingAverages(t *testing.T) {
+	values := []float64{1, 2, 3, 4, 5, 6}
+
+	assert.Equal(t, 5.0, simpleMovingAverage(values, 3))
+	assert.Equal(t, 3.5, simpleMovingAverage(values, 10))
+	// seeded with sma of 1,2,3 = 2, then k = 0.5: 3, 4, 5
+	assert.Equal(t, 5.0, exponentialMovingAverage(values, 3))
+}
+
+func TestDipStrategy(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := mocks.NewMockExchange(ctrl)
+
+	s := gdaxSchedule{}
+	s.logger = loggerStub(t).Sugar()
+	s.req = syncRequest{maPeriod: 3, maType: "sma", dipBands: []string{"-20:2", "-10:1.5", "10:0.75", "20:0.5"}}
+	s.exchange = m
+
+	d, err := newDipStrategy(&s)
+	assert.Nil(t, err)
+
+	now := time.Now()
+	candles := []exchanges.Candle{{Close: 100}, {Close: 100}, {Close: 100}}
+
+	window := purchaseWindow{
+		coin:  "BTC",
+		order: orderDetails{symbol: "btcusd", amount: 50, max: 90},
+		every: 7 * 24 * time.Hour,
+		time:  now,
+	}
+
+	tests := []struct {
+		price  float64
+		amount float64
+	}{
+		{price: 100, amount: 50},
+		{price: 89, amount: 75},
+		{price: 70, amount: 90}, // 2x capped by the max
+		{price: 115, amount: 37.5},
+		{price: 130, amount: 25},
+	}
+
+	for _, tc := range tests {
+		m.EXPECT().GetCandles("btcusd", now.AddDate(0, 0, -4), now).Return(candles, nil)
+		m.EXPECT().GetTicker("btcusd").Return(&exchanges.Ticker{Price: tc.price}, nil)
+
+		amount, err := d.Amount(window)
+
+		assert.Nil(t, err)
+		assert.Equal(t, tc.amount, amount, "price %v", tc.price)
+	}
+
+	t.Run("when not enough history", func(t *testing.T) {
+		m.EXPECT().GetCandles("btcusd", gomock.Any(), gomock.Any()).Return(candles[:1], nil)
+
+		_, err := d.Amount(window)
+
+		assert.Equal(t, "not enough price history for 3 days moving average of BTC, got 1 days", err.Error())
+	})
+
+	t.Run("when band is misformatted", func(t *testing.T) {
+		s.req.dipBands = []string{"-10"}
+
+		_, err := newDipStrategy(&s)
+
+		assert.Equal(t, "--dip-band -10 misformatted, expected PERCENTAGE:MULTIPLIER", err.Error())
+	})
+}